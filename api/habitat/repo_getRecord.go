@@ -4,13 +4,16 @@ package habitat
 
 // NetworkHabitatRepoGetRecordParams represents the input parameters for network.habitat.repo.getRecord
 type NetworkHabitatRepoGetRecordParams struct {
-	Collection string `json:"collection"`
-	Repo       string `json:"repo"`
-	Rkey       string `json:"rkey"`
+	Collection    string `json:"collection"`
+	IncludePublic bool   `json:"includePublic,omitempty"`
+	Repo          string `json:"repo"`
+	Rkey          string `json:"rkey"`
 }
 
 // NetworkHabitatRepoGetRecordOutput represents the output for network.habitat.repo.getRecord
 type NetworkHabitatRepoGetRecordOutput struct {
-	Uri   string      `json:"uri"`
-	Value interface{} `json:"value"`
+	Author     string      `json:"author,omitempty"`
+	Uri        string      `json:"uri"`
+	Value      interface{} `json:"value"`
+	Visibility string      `json:"visibility,omitempty"`
 }
@@ -4,11 +4,14 @@ package habitat
 
 // NetworkHabitatRepoListRecordsParams represents the input parameters for network.habitat.repo.listRecords
 type NetworkHabitatRepoListRecordsParams struct {
-	Collection string `json:"collection"`
-	Cursor     string `json:"cursor,omitempty"`
-	Limit      int64  `json:"limit,omitempty"`
-	Repo       string `json:"repo"`
-	Reverse    bool   `json:"reverse,omitempty"`
+	Collection    string   `json:"collection"`
+	Cursor        string   `json:"cursor,omitempty"`
+	Filter        []string `json:"filter,omitempty"`
+	IncludePublic bool     `json:"includePublic,omitempty"`
+	Limit         int64    `json:"limit,omitempty"`
+	Repo          string   `json:"repo"`
+	Reverse       bool     `json:"reverse,omitempty"`
+	Sort          string   `json:"sort,omitempty"`
 }
 
 // NetworkHabitatRepoListRecordsOutput represents the output for network.habitat.repo.listRecords
@@ -19,7 +22,9 @@ type NetworkHabitatRepoListRecordsOutput struct {
 
 // NetworkHabitatRepoListRecordsRecord represents a record object
 type NetworkHabitatRepoListRecordsRecord struct {
-	Cid   string      `json:"cid"`
-	Uri   string      `json:"uri"`
-	Value interface{} `json:"value"`
+	Author     string      `json:"author,omitempty"`
+	Cid        string      `json:"cid"`
+	Uri        string      `json:"uri"`
+	Value      interface{} `json:"value"`
+	Visibility string      `json:"visibility,omitempty"`
 }
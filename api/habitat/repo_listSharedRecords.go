@@ -0,0 +1,24 @@
+package habitat
+
+// Code generated by lexgen. DO NOT EDIT.
+
+// NetworkHabitatRepoListSharedRecordsParams represents the input parameters for network.habitat.repo.listSharedRecords
+type NetworkHabitatRepoListSharedRecordsParams struct {
+	Collection string `json:"collection"`
+	Cursor     string `json:"cursor,omitempty"`
+	Limit      int64  `json:"limit,omitempty"`
+}
+
+// NetworkHabitatRepoListSharedRecordsOutput represents the output for network.habitat.repo.listSharedRecords
+type NetworkHabitatRepoListSharedRecordsOutput struct {
+	Cursor  string                                      `json:"cursor,omitempty"`
+	Records []NetworkHabitatRepoListSharedRecordsRecord `json:"records"`
+}
+
+// NetworkHabitatRepoListSharedRecordsRecord represents a record object
+type NetworkHabitatRepoListSharedRecordsRecord struct {
+	Author string      `json:"author,omitempty"`
+	Cid    string      `json:"cid"`
+	Uri    string      `json:"uri"`
+	Value  interface{} `json:"value"`
+}
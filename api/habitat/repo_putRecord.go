@@ -9,6 +9,7 @@ type NetworkHabitatRepoPutRecordInput struct {
 	Repo       string                 `json:"repo"`
 	Rkey       string                 `json:"rkey"`
 	Validate   bool                   `json:"validate,omitempty"`
+	Visibility string                 `json:"visibility,omitempty"`
 }
 
 // NetworkHabitatRepoPutRecordOutput represents the output for network.habitat.repo.putRecord
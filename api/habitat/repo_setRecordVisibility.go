@@ -0,0 +1,16 @@
+package habitat
+
+// Code generated by lexgen. DO NOT EDIT.
+
+// NetworkHabitatRepoSetRecordVisibilityInput represents the input for network.habitat.repo.setRecordVisibility
+type NetworkHabitatRepoSetRecordVisibilityInput struct {
+	Collection string `json:"collection"`
+	Repo       string `json:"repo"`
+	Rkey       string `json:"rkey"`
+	Visibility string `json:"visibility"`
+}
+
+// NetworkHabitatRepoSetRecordVisibilityOutput represents the output for network.habitat.repo.setRecordVisibility
+type NetworkHabitatRepoSetRecordVisibilityOutput struct {
+	Uri string `json:"uri"`
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/eagraf/habitat-new/internal/backup"
+	"github.com/rs/zerolog/log"
+	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
+)
+
+const fBackupOut = "out"
+
+func backupCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "backup",
+		Usage: "Take a snapshot of the sqlite database, which is safe to do while privi is running",
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:      fBackupOut,
+				Usage:     "The path to write the snapshot to. Defaults to a new file in the backup directory",
+				TakesFile: true,
+			},
+		},
+		Action: runBackup,
+	}
+}
+
+func restoreCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "restore",
+		Usage:     "Verify a snapshot and swap it in as the sqlite database. privi must be stopped",
+		ArgsUsage: "<snapshot>",
+		Action:    runRestore,
+	}
+}
+
+func warnIfPostgres(cmd *cli.Command) {
+	if cmd.String(fBackend) == "postgres" {
+		log.Warn().Msg("records and blobs are stored in postgres and are not included; back them up with pg_dump")
+	}
+}
+
+func setupBackupManager(cmd *cli.Command, db *gorm.DB) *backup.Manager {
+	manager, err := backup.NewManager(db, cmd.String(fBackupDir), int(cmd.Int(fBackupRetain)))
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to setup backups")
+	}
+	return manager
+}
+
+func runBackup(_ context.Context, cmd *cli.Command) error {
+	warnIfPostgres(cmd)
+	db := setupDB(cmd)
+	path := cmd.String(fBackupOut)
+	if path != "" {
+		if err := backup.Snapshot(db, path); err != nil {
+			return err
+		}
+	} else {
+		var err error
+		path, err = setupBackupManager(cmd, db).Snapshot()
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Printf("wrote backup to %s\n", path)
+	return nil
+}
+
+func runRestore(_ context.Context, cmd *cli.Command) error {
+	if cmd.Args().Len() != 1 {
+		return fmt.Errorf("expected exactly one snapshot to restore")
+	}
+	warnIfPostgres(cmd)
+	src := cmd.Args().First()
+	if err := backup.Restore(src, cmd.String(fDb)); err != nil {
+		return err
+	}
+	fmt.Printf("restored %s to %s\n", src, cmd.String(fDb))
+	return nil
+}
+
+// requireAdminToken only lets through requests that carry the node's admin token as a bearer token.
+func requireAdminToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
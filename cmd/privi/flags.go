@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	altsrc "github.com/urfave/cli-altsrc/v3"
 	yaml "github.com/urfave/cli-altsrc/v3/yaml"
@@ -16,7 +17,31 @@ var (
 	fPort       = "port"
 	fHttpsCerts = "httpscerts"
 	fKeyFile    = "keyfile"
+	fNodeKey    = "nodekeyfile"
+
+	fDIDWebAccounts = "didwebaccounts"
+
+	fIndexedFields = "indexedfields"
+	fBackend       = "backend"
+	fPostgresDSN   = "postgresdsn"
+
+	fNodeAuthKey = "nodeauthkey"
+
+	fGrantSweepInterval = "grantsweepinterval"
+
+	fAdminToken     = "admintoken"
+	fBackupDir      = "backupdir"
+	fBackupInterval = "backupinterval"
+	fBackupRetain   = "backupretain"
 )
+
+// secretFlags are the flags whose values are never logged.
+var secretFlags = map[string]bool{
+	fPostgresDSN: true,
+	fAdminToken:  true,
+	fNodeAuthKey: true,
+}
+
 var profiles []string
 
 func getFlags() ([]cli.Flag, []cli.MutuallyExclusiveFlags) {
@@ -33,10 +58,10 @@ func getFlags() ([]cli.Flag, []cli.MutuallyExclusiveFlags) {
 			Destination: &profiles,
 		},
 		&cli.StringFlag{
-			Name:     fDomain,
-			Required: true,
-			Usage:    "The publicly available domain at which the server can be found",
-			Sources:  getSources(fDomain),
+			// Required for running the server, but not for subcommands like migrate (see run).
+			Name:    fDomain,
+			Usage:   "The publicly available domain at which the server can be found",
+			Sources: getSources(fDomain),
 		},
 		&cli.StringFlag{
 			Name:    fDb,
@@ -62,6 +87,75 @@ func getFlags() ([]cli.Flag, []cli.MutuallyExclusiveFlags) {
 			TakesFile: true,
 			Sources:   getSources(fKeyFile),
 		},
+		&cli.StringFlag{
+			Name:      fNodeKey,
+			Usage:     "The path to the multibase-encoded secp256k1 or P-256 key this node signs service-auth tokens with. Generated if it does not exist",
+			Value:     "./node-key.txt",
+			TakesFile: true,
+			Sources:   getSources(fNodeKey),
+		},
+		&cli.StringFlag{
+			Name:      fDIDWebAccounts,
+			Usage:     "The path to a JSON file listing accounts whose did:web documents this node serves at /user/<name>/did.json",
+			TakesFile: true,
+			Sources:   getSources(fDIDWebAccounts),
+		},
+		&cli.StringFlag{
+			Name:    fBackend,
+			Usage:   "The storage backend for records and blobs: sqlite or postgres. Permissions are always stored in the sqlite database",
+			Value:   "sqlite",
+			Sources: getSources(fBackend),
+			Validator: func(backend string) error {
+				if backend != "sqlite" && backend != "postgres" {
+					return fmt.Errorf("unknown backend %q, must be sqlite or postgres", backend)
+				}
+				return nil
+			},
+		},
+		&cli.StringFlag{
+			Name:    fPostgresDSN,
+			Usage:   "The Postgres connection string to use when the backend is postgres",
+			Sources: getSources(fPostgresDSN),
+		},
+		&cli.StringSliceFlag{
+			Name:    fIndexedFields,
+			Usage:   "Record field paths (e.g. createdAt, subject.uri) to index for filtering and sorting in listRecords",
+			Sources: getSources(fIndexedFields),
+		},
+		&cli.StringFlag{
+			Name:    fNodeAuthKey,
+			Usage:   "Key used to sign and verify bffauth tokens held by other Habitat nodes. Node-to-node auth is disabled if unset",
+			Sources: getSources(fNodeAuthKey),
+		},
+		&cli.StringFlag{
+			Name:    fAdminToken,
+			Usage:   "Bearer token that grants access to admin endpoints. Admin endpoints are disabled if unset",
+			Sources: getSources(fAdminToken),
+		},
+		&cli.StringFlag{
+			Name:      fBackupDir,
+			Usage:     "The directory in which backups of the sqlite database are stored",
+			Value:     "./backups",
+			TakesFile: true,
+			Sources:   getSources(fBackupDir),
+		},
+		&cli.DurationFlag{
+			Name:    fBackupInterval,
+			Usage:   "How often to back up the sqlite database while the server runs. Scheduled backups are disabled if 0",
+			Sources: getSources(fBackupInterval),
+		},
+		&cli.IntFlag{
+			Name:    fBackupRetain,
+			Usage:   "The number of most recent backups to keep in the backup directory, or 0 to keep all of them",
+			Value:   7,
+			Sources: getSources(fBackupRetain),
+		},
+		&cli.DurationFlag{
+			Name:    fGrantSweepInterval,
+			Usage:   "How often to delete expired permission grants. Expired grants are never applied, so this only reclaims space",
+			Value:   time.Hour,
+			Sources: getSources(fGrantSweepInterval),
+		},
 	}, []cli.MutuallyExclusiveFlags{}
 }
 
@@ -11,12 +11,17 @@ import (
 	"net/http"
 	"net/http/httputil"
 	"os"
+	"strings"
 
 	jose "github.com/go-jose/go-jose/v3"
+	"gorm.io/driver/postgres"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
 	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/eagraf/habitat-new/internal/bffauth"
+	"github.com/eagraf/habitat-new/internal/didweb"
 	"github.com/eagraf/habitat-new/internal/oauthclient"
 	"github.com/eagraf/habitat-new/internal/oauthserver"
 	"github.com/eagraf/habitat-new/internal/permissions"
@@ -32,20 +37,42 @@ func main() {
 		Flags:                  flags,
 		MutuallyExclusiveFlags: mutuallyExclusiveFlags,
 		Action:                 run,
+		Commands: []*cli.Command{
+			migrateCommand(),
+			backupCommand(),
+			restoreCommand(),
+		},
 	}
 	if err := cmd.Run(context.Background(), os.Args); err != nil {
 		log.Fatal().Err(err).Msg("error running command")
 	}
 }
 
-func run(_ context.Context, cmd *cli.Command) error {
+func run(ctx context.Context, cmd *cli.Command) error {
+	if cmd.String(fDomain) == "" {
+		return fmt.Errorf("required flag %q not set", fDomain)
+	}
 	log.Info().Msgf("running with flags: ")
 	for _, flag := range cmd.FlagNames() {
-		log.Info().Msgf("%s: %v", flag, cmd.Value(flag))
+		value := cmd.Value(flag)
+		if secretFlags[flag] {
+			value = "<redacted>"
+		}
+		log.Info().Msgf("%s: %v", flag, value)
 	}
 	db := setupDB(cmd)
 	oauthServer := setupOAuthServer(cmd)
-	priviServer := setupPriviServer(db, oauthServer)
+	nodeKey := setupNodeKey(cmd)
+	perms := setupPermissions(db)
+	priviServer := setupPriviServer(cmd, perms, setupRepo(cmd, db), oauthServer, nodeKey)
+	if interval := cmd.Duration(fGrantSweepInterval); interval > 0 {
+		go permissions.RunSweeper(ctx, perms, interval)
+	}
+	backupManager := setupBackupManager(cmd, db)
+	if interval := cmd.Duration(fBackupInterval); interval > 0 {
+		warnIfPostgres(cmd)
+		go backupManager.Run(ctx, interval)
+	}
 
 	mux := http.NewServeMux()
 
@@ -58,35 +85,44 @@ func run(_ context.Context, cmd *cli.Command) error {
 	// privi routes
 	mux.HandleFunc("/xrpc/com.habitat.putRecord", priviServer.PutRecord)
 	mux.HandleFunc("/xrpc/com.habitat.getRecord", priviServer.GetRecord)
+	mux.HandleFunc("/xrpc/com.habitat.listRecords", priviServer.ListRecords)
+	mux.HandleFunc("/xrpc/com.habitat.listSharedRecords", priviServer.ListSharedRecords)
+	mux.HandleFunc("/xrpc/com.habitat.setRecordVisibility", priviServer.SetRecordVisibility)
+	mux.HandleFunc("/xrpc/com.habitat.importCollection", priviServer.ImportCollection)
+	mux.HandleFunc("/xrpc/com.habitat.getImportStatus", priviServer.GetImportStatus)
 	mux.HandleFunc("/xrpc/network.habitat.uploadBlob", priviServer.UploadBlob)
 	mux.HandleFunc("/xrpc/network.habitat.getBlob", priviServer.GetBlob)
 	mux.HandleFunc("/xrpc/com.habitat.listPermissions", priviServer.ListPermissions)
 	mux.HandleFunc("/xrpc/com.habitat.addPermission", priviServer.AddPermission)
 	mux.HandleFunc("/xrpc/com.habitat.removePermission", priviServer.RemovePermission)
+	mux.HandleFunc("/xrpc/com.habitat.listShared", priviServer.ListShared)
+	mux.HandleFunc("/xrpc/com.habitat.listShareEvents", priviServer.ListShareEvents)
+	mux.HandleFunc("/xrpc/com.habitat.listGrants", priviServer.ListGrants)
+	mux.HandleFunc("/xrpc/com.habitat.addGrant", priviServer.AddGrant)
+	mux.HandleFunc("/xrpc/com.habitat.removeGrant", priviServer.RemoveGrant)
+	mux.HandleFunc("/xrpc/com.habitat.listPermissionEvents", priviServer.ListPermissionEvents)
+	mux.HandleFunc("/xrpc/com.habitat.explainPermission", priviServer.ExplainPermission)
+	mux.HandleFunc("/xrpc/com.habitat.checkRelation", priviServer.CheckRelation)
+	mux.HandleFunc("/xrpc/com.habitat.expandRelation", priviServer.ExpandRelation)
+	mux.HandleFunc("/xrpc/com.habitat.listGroups", priviServer.ListGroups)
+	mux.HandleFunc("/xrpc/com.habitat.createGroup", priviServer.CreateGroup)
+	mux.HandleFunc("/xrpc/com.habitat.deleteGroup", priviServer.DeleteGroup)
+	mux.HandleFunc("/xrpc/com.habitat.addGroupMember", priviServer.AddGroupMember)
+	mux.HandleFunc("/xrpc/com.habitat.removeGroupMember", priviServer.RemoveGroupMember)
+	mux.HandleFunc("/xrpc/com.habitat.createCapability", priviServer.CreateCapability)
+	mux.HandleFunc("/xrpc/com.habitat.listCapabilities", priviServer.ListCapabilities)
+	mux.HandleFunc("/xrpc/com.habitat.revokeCapability", priviServer.RevokeCapability)
 
-	mux.HandleFunc("/.well-known/did.json", func(w http.ResponseWriter, r *http.Request) {
-		template := `{
-  "id": "did:web:%s",
-  "@context": [
-    "https://www.w3.org/ns/did/v1",
-    "https://w3id.org/security/multikey/v1", 
-    "https://w3id.org/security/suites/secp256k1-2019/v1"
-  ],
-  "service": [
-    {
-      "id": "#habitat",
-      "serviceEndpoint": "https://%s",
-      "type": "HabitatServer"
-    }
-  ]
-}`
-		domain := cmd.String(fDomain)
-		_, err := fmt.Fprintf(w, template, domain, domain)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
-	})
+	// admin routes
+	if token := cmd.String(fAdminToken); token != "" {
+		mux.HandleFunc("/admin/backup", requireAdminToken(token, backupManager.HandleBackup))
+	}
+
+	didDocs, err := setupDIDDocuments(cmd, nodeKey)
+	if err != nil {
+		return err
+	}
+	didDocs.Register(mux)
 
 	port := cmd.String(fPort)
 	s := &http.Server{
@@ -115,17 +151,119 @@ func setupDB(cmd *cli.Command) *gorm.DB {
 	return priviDB
 }
 
-func setupPriviServer(db *gorm.DB, oauthServer *oauthserver.OAuthServer) *privi.Server {
-	repo, err := privi.NewSQLiteRepo(db)
+// setupRepoDB returns the database backing the privi repo, which is the given sqlite database unless
+// the postgres backend is selected.
+func setupRepoDB(cmd *cli.Command, db *gorm.DB) *gorm.DB {
+	if cmd.String(fBackend) != "postgres" {
+		return db
+	}
+	dsn := cmd.String(fPostgresDSN)
+	if dsn == "" {
+		log.Fatal().Msgf("--%s is required with the postgres backend", fPostgresDSN)
+	}
+	repoDB, err := gorm.Open(postgres.Open(dsn))
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to connect to postgres backing privi server")
+	}
+	return repoDB
+}
+
+func setupRepo(cmd *cli.Command, db *gorm.DB) privi.Repo {
+	db = setupRepoDB(cmd, db)
+	indexedFields := cmd.StringSlice(fIndexedFields)
+
+	var repo privi.Repo
+	var err error
+	switch cmd.String(fBackend) {
+	case "postgres":
+		repo, err = privi.NewPostgresRepo(db, indexedFields...)
+	default:
+		repo, err = privi.NewSQLiteRepo(db, indexedFields...)
+	}
 	if err != nil {
-		log.Fatal().Err(err).Msg("unable to setup privi sqlite db")
+		log.Fatal().Err(err).Msg("unable to setup privi repo")
 	}
+	return repo
+}
 
-	adapter, err := permissions.NewSQLiteStore(db)
+func setupPermissions(db *gorm.DB) permissions.Store {
+	store, err := permissions.NewSQLiteStore(db)
 	if err != nil {
 		log.Fatal().Err(err).Msg("unable to setup permissions store")
 	}
-	return privi.NewServer(adapter, repo, oauthServer)
+	return store
+}
+
+func setupPriviServer(
+	cmd *cli.Command,
+	perms permissions.Store,
+	repo privi.Repo,
+	oauthServer *oauthserver.OAuthServer,
+	nodeKey atcrypto.PrivateKey,
+) *privi.Server {
+	domain := cmd.String(fDomain)
+	signer, err := privi.NewServiceAuthSigner(didweb.DID(domain).String(), nodeKey)
+	if err != nil {
+		log.Fatal().Err(err).Msg("unable to setup service auth signer")
+	}
+	node := privi.NodeConfig{
+		ServiceEndpoint: "https://" + domain,
+		NodeTokens:      bffauth.NewClient(),
+		Signer:          signer,
+	}
+	if key := cmd.String(fNodeAuthKey); key != "" {
+		node.NodeAuth = bffauth.NewProvider(bffauth.NewInMemorySessionPersister(), []byte(key))
+	}
+	return privi.NewServer(perms, repo, oauthServer, node)
+}
+
+// setupDIDDocuments configures the did:web documents for this node and the accounts it hosts.
+func setupDIDDocuments(cmd *cli.Command, nodeKey atcrypto.PrivateKey) (*didweb.Config, error) {
+	nodePublicKey, err := nodeKey.PublicKey()
+	if err != nil {
+		return nil, fmt.Errorf("getting node public key: %w", err)
+	}
+	config := &didweb.Config{
+		Domain: cmd.String(fDomain),
+		Keys:   []didweb.Key{{ID: didweb.AtprotoKeyID, PublicKey: nodePublicKey}},
+	}
+	if path := cmd.String(fDIDWebAccounts); path != "" {
+		config.Accounts, err = didweb.LoadAccounts(path)
+		if err != nil {
+			return nil, fmt.Errorf("loading did:web accounts: %w", err)
+		}
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// setupNodeKey loads the key this node signs service-auth tokens with, generating a secp256k1 key if there is
+// none yet.
+func setupNodeKey(cmd *cli.Command) atcrypto.PrivateKeyExportable {
+	keyFile := cmd.String(fNodeKey)
+
+	bytes, err := os.ReadFile(keyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err := atcrypto.GeneratePrivateKeyK256()
+		if err != nil {
+			log.Fatal().Err(err).Msgf("failed to generate node key")
+		}
+		if err := os.WriteFile(keyFile, []byte(key.Multibase()), 0o600); err != nil {
+			log.Fatal().Err(err).Msgf("failed to write node key to file")
+		}
+		log.Info().Msgf("created node key file at %s", keyFile)
+		return key
+	} else if err != nil {
+		log.Fatal().Err(err).Msgf("failed to read node key from file")
+	}
+
+	key, err := atcrypto.ParsePrivateMultibase(strings.TrimSpace(string(bytes)))
+	if err != nil {
+		log.Fatal().Err(err).Msgf("failed to parse node key")
+	}
+	return key
 }
 
 func setupOAuthServer(cmd *cli.Command) *oauthserver.OAuthServer {
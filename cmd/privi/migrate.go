@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/eagraf/habitat-new/internal/migrations"
+	"github.com/eagraf/habitat-new/internal/permissions"
+	"github.com/eagraf/habitat-new/internal/privi"
+	"github.com/urfave/cli/v3"
+	"gorm.io/gorm"
+)
+
+// migrationTarget is a set of migrations along with the database it applies to.
+type migrationTarget struct {
+	db  *gorm.DB
+	set migrations.Set
+}
+
+// migrationTargets returns all migration sets for the selected backend, in the order they should be applied.
+func migrationTargets(cmd *cli.Command) []migrationTarget {
+	db := setupDB(cmd)
+	if cmd.String(fBackend) == "postgres" {
+		return []migrationTarget{
+			{db: setupRepoDB(cmd, db), set: privi.PostgresMigrations},
+			{db: db, set: permissions.Migrations},
+		}
+	}
+	return []migrationTarget{
+		{db: db, set: privi.SQLiteMigrations},
+		{db: db, set: permissions.Migrations},
+	}
+}
+
+func migrateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "migrate",
+		Usage: "Inspect and apply schema migrations to the privi database",
+		Commands: []*cli.Command{
+			{
+				Name:   "status",
+				Usage:  "List all migrations and whether they have been applied",
+				Action: migrateStatus,
+			},
+			{
+				Name:   "up",
+				Usage:  "Apply all pending migrations",
+				Action: migrateUp,
+			},
+		},
+	}
+}
+
+func migrateStatus(_ context.Context, cmd *cli.Command) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "COMPONENT\tVERSION\tNAME\tAPPLIED AT")
+	for _, target := range migrationTargets(cmd) {
+		statuses, err := target.set.Status(target.db)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05 MST")
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", s.Component, s.Version, s.Name, appliedAt)
+		}
+	}
+	return w.Flush()
+}
+
+func migrateUp(_ context.Context, cmd *cli.Command) error {
+	total := 0
+	for _, target := range migrationTargets(cmd) {
+		ran, err := target.set.Up(target.db)
+		for _, m := range ran {
+			fmt.Printf("applied %s migration %d: %s\n", target.set.Component, m.Version, m.Name)
+		}
+		total += len(ran)
+		if err != nil {
+			return err
+		}
+	}
+	if total == 0 {
+		fmt.Println("database is up to date")
+	}
+	return nil
+}
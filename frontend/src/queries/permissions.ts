@@ -13,3 +13,16 @@ export function listPermissions(authManager: AuthManager) {
     },
   });
 }
+
+export function listGroups(authManager: AuthManager) {
+  return queryOptions({
+    queryKey: ["groups"],
+    queryFn: async () => {
+      const response = await authManager?.fetch(
+        `/xrpc/com.habitat.listGroups`,
+      );
+      const json: Record<string, string[]> = await response?.json();
+      return json;
+    },
+  });
+}
@@ -1,13 +1,92 @@
-import { createFileRoute } from "@tanstack/react-router";
+import { listGroups } from "@/queries/permissions";
+import { useMutation } from "@tanstack/react-query";
+import { createFileRoute, useRouter } from "@tanstack/react-router";
+import { useForm } from "react-hook-form";
+
+interface Data {
+    group: string;
+    member?: string;
+}
 
 export const Route = createFileRoute('/_requireAuth/permissions/groups/')({
-    loader() {
-        // fetch groups
+    async loader({ context }) {
+        return context.queryClient.fetchQuery(listGroups(context.authManager));
     },
     component() {
+        const router = useRouter();
+        const { authManager } = Route.useRouteContext();
+        const groups = Route.useLoaderData();
+        const form = useForm<Data>({});
+        const { mutate: edit, isPending } = useMutation({
+            async mutationFn({ method, data }: { method: string; data: Data }) {
+                await authManager?.fetch(
+                    `/xrpc/com.habitat.${method}`,
+                    "POST",
+                    JSON.stringify(data),
+                );
+                form.reset();
+                router.invalidate();
+            },
+            onError(e) {
+                console.error(e);
+            },
+        });
+
         return <>
             <h2>Groups</h2>
+            <form onSubmit={form.handleSubmit((data) => edit({ method: "createGroup", data }))}>
+                <fieldset role="group">
+                    <input type="text" placeholder="Group name" {...form.register("group")} />
+                    <button type="submit" aria-busy={isPending}>
+                        Create
+                    </button>
+                </fieldset>
+            </form>
             <table>
+                <thead>
+                    <tr>
+                        <th>Group</th>
+                        <th>Members</th>
+                        <th />
+                    </tr>
+                </thead>
+                <tbody>
+                    {Object.keys(groups).map((group) => (
+                        <tr key={group}>
+                            <td>{group}</td>
+                            <td>
+                                {groups[group].map((member) => (
+                                    <div key={member}>
+                                        {member}
+                                        <button
+                                            type="button"
+                                            onClick={() => edit({ method: "removeGroupMember", data: { group, member } })}
+                                        >
+                                            🗑️
+                                        </button>
+                                    </div>
+                                ))}
+                                <form
+                                    onSubmit={(e) => {
+                                        e.preventDefault();
+                                        const member = new FormData(e.currentTarget).get("member");
+                                        edit({ method: "addGroupMember", data: { group, member: String(member) } });
+                                    }}
+                                >
+                                    <fieldset role="group">
+                                        <input type="text" name="member" placeholder="DID" />
+                                        <button type="submit">Add</button>
+                                    </fieldset>
+                                </form>
+                            </td>
+                            <td>
+                                <button type="button" onClick={() => edit({ method: "deleteGroup", data: { group } })}>
+                                    🗑️
+                                </button>
+                            </td>
+                        </tr>
+                    ))}
+                </tbody>
             </table>
         </>
     }
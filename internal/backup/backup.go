@@ -0,0 +1,256 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/eagraf/habitat-new/internal/utils"
+	"github.com/rs/zerolog/log"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Backups of a privi node's sqlite database.
+//
+// Snapshots are taken with VACUUM INTO, which produces a consistent copy of the database from a single
+// read transaction, so they are safe to take while privi is serving requests. Restoring replaces the
+// database file and must only be done while privi is stopped.
+
+const (
+	snapshotPrefix = "privi-"
+	snapshotSuffix = ".db"
+	// Sorts lexically in chronological order.
+	snapshotTimeFormat = "20060102T150405.000000000Z"
+)
+
+// Snapshot writes a consistent copy of the sqlite database to path, which must not already exist.
+func Snapshot(db *gorm.DB, path string) error {
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup destination %s already exists", path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	if err := db.Exec("VACUUM INTO ?", path).Error; err != nil {
+		return fmt.Errorf("snapshotting database: %w", err)
+	}
+	return nil
+}
+
+// Verify checks that the sqlite file at path is intact and is a privi database.
+func Verify(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := gorm.Open(sqlite.Open("file:"+path+"?mode=ro"), &gorm.Config{})
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer func() { _ = sqlDB.Close() }()
+
+	var results []string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&results).Error; err != nil {
+		return fmt.Errorf("checking integrity of %s: %w", path, err)
+	}
+	if len(results) != 1 || results[0] != "ok" {
+		return fmt.Errorf("integrity check of %s failed: %s", path, strings.Join(results, "; "))
+	}
+
+	if !db.Migrator().HasTable("schema_migrations") {
+		return fmt.Errorf("%s is not a privi database: no schema_migrations table", path)
+	}
+	return nil
+}
+
+// Restore verifies the backup at src and then swaps it in as the database at dst. Any existing
+// database at dst (along with its WAL and shared memory files) is kept alongside it with a
+// ".pre-restore-<time>" suffix. privi must not be running against dst.
+func Restore(src string, dst string) error {
+	if err := Verify(src); err != nil {
+		return err
+	}
+
+	tmp := dst + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// Guard against the copy itself being damaged.
+	if err := Verify(tmp); err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	if _, err := os.Stat(dst); err == nil {
+		old := fmt.Sprintf("%s.pre-restore-%s", dst, time.Now().UTC().Format(snapshotTimeFormat))
+		// The WAL must move with the database it belongs to; applying it to the restored file would corrupt it.
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			err := os.Rename(dst+suffix, old+suffix)
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("moving aside existing database: %w", err)
+			}
+		}
+		log.Info().Msgf("moved existing database to %s", old)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
+
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Manager takes snapshots into a local directory, keeping only the most recent ones.
+type Manager struct {
+	db     *gorm.DB
+	dir    string
+	retain int
+}
+
+// NewManager returns a Manager that snapshots db into dir and keeps the newest retain snapshots.
+// A retain of 0 keeps every snapshot.
+func NewManager(db *gorm.DB, dir string, retain int) (*Manager, error) {
+	if retain < 0 {
+		return nil, fmt.Errorf("retain must not be negative, got %d", retain)
+	}
+	return &Manager{db: db, dir: dir, retain: retain}, nil
+}
+
+// Snapshot takes a new snapshot, prunes old ones and returns the new snapshot's path.
+func (m *Manager) Snapshot() (string, error) {
+	if err := os.MkdirAll(m.dir, 0o700); err != nil {
+		return "", err
+	}
+	name := snapshotPrefix + time.Now().UTC().Format(snapshotTimeFormat) + snapshotSuffix
+	path := filepath.Join(m.dir, name)
+	if err := Snapshot(m.db, path); err != nil {
+		return "", err
+	}
+	if err := m.prune(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// List returns the paths of all snapshots in the directory, oldest first.
+func (m *Manager) List() ([]string, error) {
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, snapshotPrefix) &&
+			strings.HasSuffix(name, snapshotSuffix) {
+			paths = append(paths, filepath.Join(m.dir, name))
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (m *Manager) prune() error {
+	if m.retain == 0 {
+		return nil
+	}
+	paths, err := m.List()
+	if err != nil {
+		return err
+	}
+	for len(paths) > m.retain {
+		if err := os.Remove(paths[0]); err != nil {
+			return err
+		}
+		paths = paths[1:]
+	}
+	return nil
+}
+
+// Run takes a snapshot every interval until ctx is cancelled. Failures are logged and retried at the
+// next interval.
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			path, err := m.Snapshot()
+			if err != nil {
+				log.Error().Err(err).Msg("scheduled backup failed")
+				continue
+			}
+			log.Info().Msgf("wrote scheduled backup to %s", path)
+		}
+	}
+}
+
+// SnapshotInfo describes a snapshot taken through HandleBackup.
+type SnapshotInfo struct {
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// HandleBackup takes a snapshot on POST and responds with its SnapshotInfo. Callers are expected to
+// restrict access to node admins.
+func (m *Manager) HandleBackup(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path, err := m.Snapshot()
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "taking backup", http.StatusInternalServerError)
+		return
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "reading backup", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(&SnapshotInfo{
+		Path:      path,
+		Size:      stat.Size(),
+		CreatedAt: stat.ModTime().UTC(),
+	})
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "encoding response", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,129 @@
+package backup
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// testDB returns a file-backed database that looks like a privi database.
+func testDB(t *testing.T, path string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		require.NoError(t, sqlDB.Close())
+	})
+	require.NoError(t, db.Exec("CREATE TABLE schema_migrations (component text, version integer)").Error)
+	require.NoError(t, db.Exec("CREATE TABLE things (name text)").Error)
+	require.NoError(t, db.Exec("INSERT INTO things (name) VALUES ('before')").Error)
+	return db
+}
+
+func countThings(t *testing.T, path string) int64 {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	defer func() { require.NoError(t, sqlDB.Close()) }()
+
+	var count int64
+	require.NoError(t, db.Table("things").Count(&count).Error)
+	return count
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "repo.db")
+	db := testDB(t, dbPath)
+
+	snapshot := filepath.Join(dir, "snapshot.db")
+	require.NoError(t, Snapshot(db, snapshot))
+	require.NoError(t, Verify(snapshot))
+	require.Error(t, Snapshot(db, snapshot))
+
+	require.NoError(t, db.Exec("INSERT INTO things (name) VALUES ('after')").Error)
+	require.Equal(t, int64(2), countThings(t, dbPath))
+
+	restored := filepath.Join(dir, "restored.db")
+	require.NoError(t, Restore(snapshot, restored))
+	require.Equal(t, int64(1), countThings(t, restored))
+
+	// Restoring over an existing database keeps the old one around.
+	require.NoError(t, Restore(snapshot, restored))
+	matches, err := filepath.Glob(restored + ".pre-restore-*")
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+}
+
+func TestRestoreRejectsInvalidBackups(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "repo.db")
+	testDB(t, dst)
+
+	garbage := filepath.Join(dir, "garbage.db")
+	require.NoError(t, os.WriteFile(garbage, []byte("this is not a database"), 0o600))
+	require.Error(t, Restore(garbage, dst))
+
+	other := filepath.Join(dir, "other.db")
+	otherDB, err := gorm.Open(sqlite.Open(other), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, otherDB.Exec("CREATE TABLE unrelated (id integer)").Error)
+	require.ErrorContains(t, Restore(other, dst), "not a privi database")
+
+	require.Error(t, Restore(filepath.Join(dir, "missing.db"), dst))
+
+	// The existing database is untouched.
+	require.Equal(t, int64(1), countThings(t, dst))
+	matches, err := filepath.Glob(dst + ".*")
+	require.NoError(t, err)
+	require.Empty(t, matches)
+}
+
+func TestManagerRetention(t *testing.T) {
+	dir := t.TempDir()
+	db := testDB(t, filepath.Join(dir, "repo.db"))
+
+	manager, err := NewManager(db, filepath.Join(dir, "backups"), 2)
+	require.NoError(t, err)
+
+	var paths []string
+	for range 3 {
+		path, err := manager.Snapshot()
+		require.NoError(t, err)
+		require.NoError(t, Verify(path))
+		paths = append(paths, path)
+	}
+
+	kept, err := manager.List()
+	require.NoError(t, err)
+	require.Equal(t, paths[1:], kept)
+}
+
+func TestHandleBackup(t *testing.T) {
+	dir := t.TempDir()
+	db := testDB(t, filepath.Join(dir, "repo.db"))
+	manager, err := NewManager(db, filepath.Join(dir, "backups"), 0)
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	manager.HandleBackup(w, httptest.NewRequest(http.MethodGet, "/admin/backup", nil))
+	require.Equal(t, http.StatusMethodNotAllowed, w.Code)
+
+	w = httptest.NewRecorder()
+	manager.HandleBackup(w, httptest.NewRequest(http.MethodPost, "/admin/backup", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var info SnapshotInfo
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&info))
+	require.NoError(t, Verify(info.Path))
+	require.Positive(t, info.Size)
+}
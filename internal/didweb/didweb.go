@@ -0,0 +1,204 @@
+// Package didweb generates and serves the did:web documents for a Habitat node and the accounts it hosts.
+//
+// The node is identified by did:web:<domain>, served at /.well-known/did.json. Hosted accounts are identified
+// by did:web:<domain>:user:<name>, served at /user/<name>/did.json.
+package didweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/rs/zerolog/log"
+)
+
+// Service ids and types published in the documents.
+const (
+	HabitatServiceID   = "habitat"
+	HabitatServiceType = "HabitatServer"
+	PDSServiceID       = "atproto_pds"
+	PDSServiceType     = "AtprotoPersonalDataServer"
+	// AtprotoKeyID is the id of the key atproto services verify signatures against.
+	AtprotoKeyID = "atproto"
+)
+
+var documentContext = []string{
+	"https://www.w3.org/ns/did/v1",
+	"https://w3id.org/security/multikey/v1",
+	"https://w3id.org/security/suites/secp256k1-2019/v1",
+}
+
+// Document is a DID document. identity.DIDDocument leaves out the JSON-LD context, which resolvers expect.
+type Document struct {
+	Context []string `json:"@context"`
+	identity.DIDDocument
+}
+
+// Key is a public key published as a Multikey verification method.
+type Key struct {
+	// The fragment identifying the key within the document, e.g. "atproto".
+	ID        string
+	PublicKey atcrypto.PublicKey
+}
+
+// Account is a user whose did:web is hosted on the node's domain.
+type Account struct {
+	// Identifies the account as did:web:<domain>:user:<name>.
+	Name string `json:"name"`
+	// Published as at://<handle> in alsoKnownAs, if set.
+	Handle string `json:"handle,omitempty"`
+	// The multibase-encoded public key the account signs its repo with.
+	SigningKey string `json:"signingKey"`
+	// The account's PDS, if it has one.
+	PDS string `json:"pds,omitempty"`
+}
+
+var accountNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]+$`)
+
+// Config describes the node the documents are generated for.
+type Config struct {
+	// The node's public domain, optionally with a port.
+	Domain string
+	// The node's keys.
+	Keys []Key
+	// Accounts whose documents are also served.
+	Accounts []Account
+}
+
+// DID returns the did:web for a domain. Ports are percent-encoded.
+func DID(domain string) syntax.DID {
+	return syntax.DID("did:web:" + strings.ReplaceAll(domain, ":", "%3A"))
+}
+
+// AccountDID returns the did:web for an account hosted on the domain.
+func AccountDID(domain string, name string) syntax.DID {
+	return syntax.DID(DID(domain).String() + ":user:" + name)
+}
+
+// Validate checks that the accounts are well formed and can be published.
+func (c *Config) Validate() error {
+	names := map[string]bool{}
+	for _, account := range c.Accounts {
+		if !accountNameRegex.MatchString(account.Name) {
+			return fmt.Errorf("invalid account name %q", account.Name)
+		} else if names[account.Name] {
+			return fmt.Errorf("duplicate account name %q", account.Name)
+		}
+		names[account.Name] = true
+		if _, err := atcrypto.ParsePublicMultibase(account.SigningKey); err != nil {
+			return fmt.Errorf("parsing signing key for %q: %w", account.Name, err)
+		}
+		if account.Handle != "" {
+			if _, err := syntax.ParseHandle(account.Handle); err != nil {
+				return fmt.Errorf("parsing handle for %q: %w", account.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Config) habitatService() identity.DocService {
+	return identity.DocService{
+		ID:              "#" + HabitatServiceID,
+		Type:            HabitatServiceType,
+		ServiceEndpoint: "https://" + c.Domain,
+	}
+}
+
+// NodeDocument returns the node's DID document.
+func (c *Config) NodeDocument() *Document {
+	did := DID(c.Domain)
+	doc := &Document{
+		Context: documentContext,
+		DIDDocument: identity.DIDDocument{
+			DID:                did,
+			VerificationMethod: []identity.DocVerificationMethod{},
+			Service:            []identity.DocService{c.habitatService()},
+		},
+	}
+	for _, key := range c.Keys {
+		doc.VerificationMethod = append(doc.VerificationMethod, identity.DocVerificationMethod{
+			ID:                 did.String() + "#" + key.ID,
+			Type:               "Multikey",
+			Controller:         did.String(),
+			PublicKeyMultibase: key.PublicKey.Multibase(),
+		})
+	}
+	return doc
+}
+
+// AccountDocument returns the DID document for the named account, if it is hosted on the node.
+func (c *Config) AccountDocument(name string) (*Document, bool) {
+	for _, account := range c.Accounts {
+		if account.Name != name {
+			continue
+		}
+		did := AccountDID(c.Domain, name)
+		doc := &Document{
+			Context: documentContext,
+			DIDDocument: identity.DIDDocument{
+				DID: did,
+				VerificationMethod: []identity.DocVerificationMethod{{
+					ID:                 did.String() + "#" + AtprotoKeyID,
+					Type:               "Multikey",
+					Controller:         did.String(),
+					PublicKeyMultibase: account.SigningKey,
+				}},
+				Service: []identity.DocService{c.habitatService()},
+			},
+		}
+		if account.Handle != "" {
+			doc.AlsoKnownAs = []string{"at://" + account.Handle}
+		}
+		if account.PDS != "" {
+			doc.Service = append(doc.Service, identity.DocService{
+				ID:              "#" + PDSServiceID,
+				Type:            PDSServiceType,
+				ServiceEndpoint: account.PDS,
+			})
+		}
+		return doc, true
+	}
+	return nil, false
+}
+
+// LoadAccounts reads a JSON array of accounts from a file.
+func LoadAccounts(path string) ([]Account, error) {
+	bytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []Account
+	if err := json.Unmarshal(bytes, &accounts); err != nil {
+		return nil, fmt.Errorf("parsing accounts: %w", err)
+	}
+	return accounts, nil
+}
+
+// Register adds the handlers serving the documents to mux.
+func (c *Config) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /.well-known/did.json", func(w http.ResponseWriter, r *http.Request) {
+		writeDocument(w, c.NodeDocument())
+	})
+	mux.HandleFunc("GET /user/{name}/did.json", func(w http.ResponseWriter, r *http.Request) {
+		doc, ok := c.AccountDocument(r.PathValue("name"))
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeDocument(w, doc)
+	})
+}
+
+func writeDocument(w http.ResponseWriter, doc *Document) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(doc); err != nil {
+		log.Error().Err(err).Msg("writing did document")
+	}
+}
@@ -0,0 +1,115 @@
+package didweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/stretchr/testify/require"
+)
+
+func testConfig(t *testing.T) (*Config, atcrypto.PublicKey, atcrypto.PublicKey) {
+	nodeKey, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	nodePub, err := nodeKey.PublicKey()
+	require.NoError(t, err)
+	aliceKey, err := atcrypto.GeneratePrivateKeyP256()
+	require.NoError(t, err)
+	alicePub, err := aliceKey.PublicKey()
+	require.NoError(t, err)
+
+	return &Config{
+		Domain: "habitat.example",
+		Keys:   []Key{{ID: AtprotoKeyID, PublicKey: nodePub}},
+		Accounts: []Account{{
+			Name:       "alice",
+			Handle:     "alice.habitat.example",
+			SigningKey: alicePub.Multibase(),
+			PDS:        "https://pds.example",
+		}},
+	}, nodePub, alicePub
+}
+
+// fetch serves path from the config's handlers and parses the result the way a resolver would.
+func fetch(t *testing.T, c *Config, path string) (*httptest.ResponseRecorder, *identity.Identity) {
+	mux := http.NewServeMux()
+	c.Register(mux)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	if w.Code != http.StatusOK {
+		return w, nil
+	}
+	require.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var raw map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+	require.Contains(t, raw, "@context")
+
+	var doc identity.DIDDocument
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &doc))
+	id := identity.ParseIdentity(&doc)
+	return w, &id
+}
+
+func TestNodeDocument(t *testing.T) {
+	c, nodePub, _ := testConfig(t)
+
+	_, id := fetch(t, c, "/.well-known/did.json")
+	require.Equal(t, syntax.DID("did:web:habitat.example"), id.DID)
+	require.Equal(t, "https://habitat.example", id.GetServiceEndpoint(HabitatServiceID))
+	key, err := id.PublicKey()
+	require.NoError(t, err)
+	require.True(t, nodePub.Equal(key))
+}
+
+func TestAccountDocument(t *testing.T) {
+	c, _, alicePub := testConfig(t)
+	require.NoError(t, c.Validate())
+
+	_, id := fetch(t, c, "/user/alice/did.json")
+	require.Equal(t, syntax.DID("did:web:habitat.example:user:alice"), id.DID)
+	require.Equal(t, "https://pds.example", id.PDSEndpoint())
+	require.Equal(t, "https://habitat.example", id.GetServiceEndpoint(HabitatServiceID))
+	key, err := id.PublicKey()
+	require.NoError(t, err)
+	require.True(t, alicePub.Equal(key))
+	handle, err := id.DeclaredHandle()
+	require.NoError(t, err)
+	require.Equal(t, syntax.Handle("alice.habitat.example"), handle)
+
+	w, _ := fetch(t, c, "/user/bob/did.json")
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestDIDEncodesPort(t *testing.T) {
+	require.Equal(t, syntax.DID("did:web:localhost%3A8443"), DID("localhost:8443"))
+	require.Equal(t, syntax.DID("did:web:localhost%3A8443:user:alice"), AccountDID("localhost:8443", "alice"))
+}
+
+func TestValidate(t *testing.T) {
+	c, _, alicePub := testConfig(t)
+
+	c.Accounts = append(c.Accounts, Account{Name: "alice", SigningKey: alicePub.Multibase()})
+	require.ErrorContains(t, c.Validate(), "duplicate")
+
+	c.Accounts = []Account{{Name: "../alice", SigningKey: alicePub.Multibase()}}
+	require.ErrorContains(t, c.Validate(), "invalid account name")
+
+	c.Accounts = []Account{{Name: "alice", SigningKey: "not-a-key"}}
+	require.ErrorContains(t, c.Validate(), "signing key")
+}
+
+func TestLoadAccounts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "accounts.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name": "alice", "signingKey": "zkey", "pds": "https://pds.example"}]`), 0o600))
+
+	accounts, err := LoadAccounts(path)
+	require.NoError(t, err)
+	require.Equal(t, []Account{{Name: "alice", SigningKey: "zkey", PDS: "https://pds.example"}}, accounts)
+}
@@ -0,0 +1,153 @@
+// Package migrations implements a versioned schema migration runner on top of gorm.
+//
+// Each component that owns tables in a database (e.g. privi's records and blobs, or the permissions
+// store) declares an ordered Set of migrations. Applied migrations are recorded per component in the
+// schema_migrations table, and each migration runs in its own transaction along with the row that
+// records it, so a failed migration leaves the database at the previous version.
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Migration is a single versioned change to a database's schema or data.
+type Migration struct {
+	// Version must be unique within a Set and increase with every new migration.
+	Version int
+	Name    string
+	// Up applies the migration. It is run inside a transaction.
+	Up func(tx *gorm.DB) error
+}
+
+// Set is the ordered list of migrations owned by a single component.
+type Set struct {
+	Component  string
+	Migrations []Migration
+}
+
+// SchemaMigration is a row in the schema_migrations table, recording that a migration was applied.
+type SchemaMigration struct {
+	Component string    `gorm:"primaryKey"`
+	Version   int       `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// Status describes a known migration and whether it has been applied.
+type Status struct {
+	Component string
+	Version   int
+	Name      string
+	// AppliedAt is nil if the migration is pending.
+	AppliedAt *time.Time
+}
+
+func (s Set) validate() error {
+	for i, m := range s.Migrations {
+		if m.Up == nil {
+			return fmt.Errorf("%s migration %d has no Up function", s.Component, m.Version)
+		}
+		if i > 0 && m.Version <= s.Migrations[i-1].Version {
+			return fmt.Errorf(
+				"%s migrations must have strictly increasing versions: %d follows %d",
+				s.Component,
+				m.Version,
+				s.Migrations[i-1].Version,
+			)
+		}
+	}
+	return nil
+}
+
+// applied returns the applied migrations for this component, keyed by version.
+func (s Set) applied(db *gorm.DB) (map[int]SchemaMigration, error) {
+	if err := db.AutoMigrate(&SchemaMigration{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate schema_migrations table: %w", err)
+	}
+
+	var rows []SchemaMigration
+	if err := db.Where("component = ?", s.Component).Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to query schema_migrations: %w", err)
+	}
+
+	applied := make(map[int]SchemaMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+	return applied, nil
+}
+
+// Status returns every migration in the set, in order, along with when it was applied.
+func (s Set) Status(db *gorm.DB) ([]Status, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	applied, err := s.applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(s.Migrations))
+	for _, m := range s.Migrations {
+		status := Status{Component: s.Component, Version: m.Version, Name: m.Name}
+		if row, ok := applied[m.Version]; ok {
+			status.AppliedAt = &row.AppliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Up applies all pending migrations in order and returns the ones that were applied.
+//
+// It refuses to run against a database that has migrations applied which this set does not know
+// about, since that database was migrated by a newer version of the code.
+func (s Set) Up(db *gorm.DB) ([]Migration, error) {
+	if err := s.validate(); err != nil {
+		return nil, err
+	}
+	applied, err := s.applied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]bool, len(s.Migrations))
+	for _, m := range s.Migrations {
+		known[m.Version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			return nil, fmt.Errorf(
+				"database has unknown %s migration %d applied; it was migrated by a newer version",
+				s.Component,
+				version,
+			)
+		}
+	}
+
+	var ran []Migration
+	for _, m := range s.Migrations {
+		if _, ok := applied[m.Version]; ok {
+			continue
+		}
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := m.Up(tx); err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{
+				Component: s.Component,
+				Version:   m.Version,
+				Name:      m.Name,
+				AppliedAt: time.Now().UTC(),
+			}).Error
+		})
+		if err != nil {
+			return ran, fmt.Errorf("%s migration %d (%s) failed: %w", s.Component, m.Version, m.Name, err)
+		}
+		ran = append(ran, m)
+	}
+	return ran, nil
+}
@@ -0,0 +1,115 @@
+package migrations
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	return db
+}
+
+func exec(sql string) func(tx *gorm.DB) error {
+	return func(tx *gorm.DB) error { return tx.Exec(sql).Error }
+}
+
+var testSet = Set{
+	Component: "test",
+	Migrations: []Migration{
+		{Version: 1, Name: "create_things", Up: exec("CREATE TABLE things (id integer PRIMARY KEY)")},
+		{Version: 2, Name: "add_name", Up: exec("ALTER TABLE things ADD COLUMN name text")},
+	},
+}
+
+func TestUpAppliesPendingMigrationsOnce(t *testing.T) {
+	db := testDB(t)
+
+	statuses, err := testSet.Status(db)
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	require.Nil(t, statuses[0].AppliedAt)
+	require.Nil(t, statuses[1].AppliedAt)
+
+	ran, err := testSet.Up(db)
+	require.NoError(t, err)
+	require.Len(t, ran, 2)
+	require.True(t, db.Migrator().HasColumn("things", "name"))
+
+	statuses, err = testSet.Status(db)
+	require.NoError(t, err)
+	require.NotNil(t, statuses[0].AppliedAt)
+	require.NotNil(t, statuses[1].AppliedAt)
+
+	ran, err = testSet.Up(db)
+	require.NoError(t, err)
+	require.Empty(t, ran)
+}
+
+func TestUpRollsBackFailedMigration(t *testing.T) {
+	db := testDB(t)
+	set := Set{
+		Component: "test",
+		Migrations: append(testSet.Migrations, Migration{
+			Version: 3,
+			Name:    "broken",
+			Up: func(tx *gorm.DB) error {
+				if err := tx.Exec("ALTER TABLE things ADD COLUMN other text").Error; err != nil {
+					return err
+				}
+				return fmt.Errorf("oops")
+			},
+		}),
+	}
+
+	ran, err := set.Up(db)
+	require.ErrorContains(t, err, "oops")
+	require.Len(t, ran, 2)
+	require.False(t, db.Migrator().HasColumn("things", "other"))
+
+	statuses, err := set.Status(db)
+	require.NoError(t, err)
+	require.Nil(t, statuses[2].AppliedAt)
+}
+
+func TestComponentsAreTrackedSeparately(t *testing.T) {
+	db := testDB(t)
+	_, err := testSet.Up(db)
+	require.NoError(t, err)
+
+	other := Set{
+		Component:  "other",
+		Migrations: []Migration{{Version: 1, Name: "create_others", Up: exec("CREATE TABLE others (id integer)")}},
+	}
+	ran, err := other.Up(db)
+	require.NoError(t, err)
+	require.Len(t, ran, 1)
+}
+
+func TestUpRejectsUnknownAppliedMigrations(t *testing.T) {
+	db := testDB(t)
+	_, err := testSet.Up(db)
+	require.NoError(t, err)
+
+	older := Set{Component: "test", Migrations: testSet.Migrations[:1]}
+	_, err = older.Up(db)
+	require.ErrorContains(t, err, "newer version")
+}
+
+func TestSetValidation(t *testing.T) {
+	outOfOrder := Set{
+		Component:  "test",
+		Migrations: []Migration{testSet.Migrations[1], testSet.Migrations[0]},
+	}
+	_, err := outOfOrder.Up(testDB(t))
+	require.ErrorContains(t, err, "strictly increasing")
+
+	missingUp := Set{Component: "test", Migrations: []Migration{{Version: 1, Name: "nothing"}}}
+	_, err = missingUp.Status(testDB(t))
+	require.ErrorContains(t, err, "no Up function")
+}
@@ -0,0 +1,134 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCapabilityNotFound = errors.New("capability not found")
+	ErrCapabilityExpired  = errors.New("capability has expired")
+	ErrCapabilityUsedUp   = errors.New("capability has no uses left")
+	ErrInvalidMaxUses     = errors.New("capabilities must allow at least one use")
+)
+
+// Capability lets whoever holds it read one of the owner's collections, or a single record in it if Rkey is set,
+// without the owner knowing who they are. Capabilities are handed out as signed tokens carrying their ID, so
+// revoking one is deleting it.
+type Capability struct {
+	ID         string    `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Owner      string    `json:"owner" gorm:"not null;index"`
+	Collection string    `json:"collection" gorm:"not null"`
+	Rkey       string    `json:"rkey,omitempty" gorm:"not null;default:''"`
+	// If set, the capability stops working at ExpiresAt, or once it has been used MaxUses times.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	MaxUses   *int       `json:"maxUses,omitempty"`
+	Uses      int        `json:"uses" gorm:"not null;default:0"`
+}
+
+// rkeyRegex matches the characters allowed in record keys.
+var rkeyRegex = regexp.MustCompile(`^[a-zA-Z0-9._:~-]{1,512}$`)
+
+// CreateCapability creates a capability on one of the owner's collections, or on a single record in it if rkey
+// is set.
+func (s *sqliteStore) CreateCapability(
+	owner string,
+	collection string,
+	rkey string,
+	expiresAt *time.Time,
+	maxUses *int,
+) (*Capability, error) {
+	if !objectRegex.MatchString(collection) || strings.HasSuffix(collection, ".*") || isRecordObject(collection) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidObject, collection)
+	}
+	if rkey != "" && (!rkeyRegex.MatchString(rkey) || rkey == "." || rkey == "..") {
+		return nil, fmt.Errorf("%w: record key %q", ErrInvalidObject, rkey)
+	}
+	if maxUses != nil && *maxUses < 1 {
+		return nil, ErrInvalidMaxUses
+	}
+
+	capability := &Capability{
+		ID:         uuid.NewString(),
+		Owner:      owner,
+		Collection: collection,
+		Rkey:       rkey,
+		ExpiresAt:  utc(expiresAt),
+		MaxUses:    maxUses,
+	}
+	if err := s.db.Create(capability).Error; err != nil {
+		return nil, fmt.Errorf("failed to create capability: %w", err)
+	}
+	return capability, nil
+}
+
+// GetCapability returns the capability with the given ID, whether or not it can still be used.
+func (s *sqliteStore) GetCapability(id string) (*Capability, error) {
+	var capability Capability
+	err := s.db.Where("id = ?", id).First(&capability).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCapabilityNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query capability: %w", err)
+	}
+	capability.ExpiresAt = utc(capability.ExpiresAt)
+	return &capability, nil
+}
+
+// UseCapability counts a use of the capability, failing if it has expired or been used up.
+func (s *sqliteStore) UseCapability(id string, now time.Time) error {
+	now = now.UTC()
+	// Checking and counting in one statement keeps concurrent uses from going over the limit.
+	result := s.db.Model(&Capability{}).
+		Where("id = ?", id).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Where("max_uses IS NULL OR uses < max_uses").
+		Update("uses", gorm.Expr("uses + 1"))
+	if result.Error != nil {
+		return fmt.Errorf("failed to use capability: %w", result.Error)
+	}
+	if result.RowsAffected == 1 {
+		return nil
+	}
+
+	capability, err := s.GetCapability(id)
+	if err != nil {
+		return err
+	}
+	if capability.ExpiresAt != nil && !capability.ExpiresAt.After(now) {
+		return ErrCapabilityExpired
+	}
+	return ErrCapabilityUsedUp
+}
+
+// ListCapabilities returns all of the owner's capabilities, newest first.
+func (s *sqliteStore) ListCapabilities(owner string) ([]Capability, error) {
+	var capabilities []Capability
+	err := s.db.Where("owner = ?", owner).Order("created_at DESC, id").Find(&capabilities).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query capabilities: %w", err)
+	}
+	for i := range capabilities {
+		capabilities[i].ExpiresAt = utc(capabilities[i].ExpiresAt)
+	}
+	return capabilities, nil
+}
+
+// RevokeCapability deletes one of the owner's capabilities, so that tokens carrying it stop working.
+func (s *sqliteStore) RevokeCapability(owner string, id string) error {
+	result := s.db.Where("owner = ? AND id = ?", owner, id).Delete(&Capability{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke capability: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrCapabilityNotFound
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package permissions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eagraf/habitat-new/internal/migrations"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestCapabilityUses(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	now := time.Now()
+	inAnHour := now.Add(time.Hour)
+	maxUses := 2
+	capability, err := store.CreateCapability("alice", "com.habitat.photos", "beach", &inAnHour, &maxUses)
+	require.NoError(t, err)
+
+	require.NoError(t, store.UseCapability(capability.ID, now))
+	require.NoError(t, store.UseCapability(capability.ID, now))
+	require.ErrorIs(t, store.UseCapability(capability.ID, now), ErrCapabilityUsedUp)
+
+	got, err := store.GetCapability(capability.ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, got.Uses)
+	require.True(t, inAnHour.Equal(*got.ExpiresAt))
+
+	unlimited, err := store.CreateCapability("alice", "com.habitat.photos", "", &inAnHour, nil)
+	require.NoError(t, err)
+	require.NoError(t, store.UseCapability(unlimited.ID, now))
+	require.ErrorIs(t, store.UseCapability(unlimited.ID, inAnHour), ErrCapabilityExpired)
+
+	require.ErrorIs(t, store.UseCapability("unknown", now), ErrCapabilityNotFound)
+}
+
+func TestCapabilityManagement(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	capability, err := store.CreateCapability("alice", "com.habitat.posts", "", nil, nil)
+	require.NoError(t, err)
+
+	capabilities, err := store.ListCapabilities("alice")
+	require.NoError(t, err)
+	require.Len(t, capabilities, 1)
+	require.Equal(t, capability.ID, capabilities[0].ID)
+	require.Equal(t, "com.habitat.posts", capabilities[0].Collection)
+	require.Empty(t, capabilities[0].Rkey)
+
+	capabilities, err = store.ListCapabilities("bob")
+	require.NoError(t, err)
+	require.Empty(t, capabilities)
+
+	// Only the owner can revoke a capability.
+	require.ErrorIs(t, store.RevokeCapability("bob", capability.ID), ErrCapabilityNotFound)
+	require.NoError(t, store.RevokeCapability("alice", capability.ID))
+	require.ErrorIs(t, store.UseCapability(capability.ID, time.Now()), ErrCapabilityNotFound)
+	require.ErrorIs(t, store.RevokeCapability("alice", capability.ID), ErrCapabilityNotFound)
+
+	_, err = store.CreateCapability("alice", "com.habitat.%", "", nil, nil)
+	require.ErrorIs(t, err, ErrInvalidObject)
+	_, err = store.CreateCapability("alice", "com.habitat.*", "", nil, nil)
+	require.ErrorIs(t, err, ErrInvalidObject)
+	_, err = store.CreateCapability("alice", "com.habitat.posts", "a/b", nil, nil)
+	require.ErrorIs(t, err, ErrInvalidObject)
+	zero := 0
+	_, err = store.CreateCapability("alice", "com.habitat.posts", "", nil, &zero)
+	require.ErrorIs(t, err, ErrInvalidMaxUses)
+}
+
+func TestMigrateCapabilityObjects(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	legacy := migrations.Set{Component: Migrations.Component, Migrations: Migrations.Migrations[:8]}
+	_, err = legacy.Up(db)
+	require.NoError(t, err)
+	for id, object := range map[string]string{
+		"1": "com.habitat.posts.a",
+		"2": "com.habitat.posts",
+		"3": "com.habitat.posts.a_b.c",
+	} {
+		require.NoError(t, db.Exec(
+			"INSERT INTO capabilities (id, owner, object, uses) VALUES (?, 'alice', ?, 0)",
+			id,
+			object,
+		).Error)
+	}
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+	for id, want := range map[string]Capability{
+		"1": {Collection: "com.habitat.posts", Rkey: "a"},
+		"2": {Collection: "com.habitat.posts"},
+		"3": {Collection: "com.habitat.posts", Rkey: "a_b.c"},
+	} {
+		capability, err := store.GetCapability(id)
+		require.NoError(t, err)
+		require.Equal(t, want.Collection, capability.Collection)
+		require.Equal(t, want.Rkey, capability.Rkey)
+	}
+}
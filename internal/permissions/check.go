@@ -0,0 +1,231 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxCheckDepth bounds how many relations a check or expand may follow, which is far more than objects have
+// segments or groups are nested.
+const maxCheckDepth = 64
+
+var ErrCheckTooDeep = errors.New("permission check followed too many relations")
+
+// Check reports whether subject has the relation or permission to object, following the schema (see HabitatSchema).
+// Tuples outside their time window are ignored.
+func (s *sqliteStore) Check(subject Subject, relation string, object ObjectRef) (bool, error) {
+	return s.checker(time.Now()).check(object, relation, subject, 0)
+}
+
+// ExpandTree is every subject with a relation or permission to an object, as a tree following the schema (see
+// Expand).
+type ExpandTree struct {
+	// "union", "intersection" or "exclusion" for permissions combining others, "arrow" for following a relation
+	// to other objects, and "leaf" for stored relations.
+	Operation string `json:"operation"`
+	// The object and relation or permission that the node expands. Nodes for parts of a permission's expression
+	// leave these empty, except for arrows, whose relation is the expression ("parent->read").
+	Object   string `json:"object,omitempty"`
+	Relation string `json:"relation,omitempty"`
+	// The subjects stored in a leaf's relation. Subjects that are themselves sets ("group:alice/family#member")
+	// are also expanded as children.
+	Subjects []string      `json:"subjects,omitempty"`
+	Children []*ExpandTree `json:"children,omitempty"`
+}
+
+// Expand returns the tree of subjects with the relation or permission to object. Tuples outside their time window
+// are ignored.
+func (s *sqliteStore) Expand(relation string, object ObjectRef) (*ExpandTree, error) {
+	return s.checker(time.Now()).expand(object, relation, 0)
+}
+
+func (s *sqliteStore) checker(now time.Time) *checker {
+	return &checker{
+		schema: HabitatSchema,
+		read: func(object ObjectRef, relation string) ([]Tuple, error) {
+			return s.readTuples(object, relation, now)
+		},
+		visiting: map[string]bool{},
+	}
+}
+
+// checker evaluates relations and permissions over the tuples returned by read.
+type checker struct {
+	schema *Schema
+	read   func(object ObjectRef, relation string) ([]Tuple, error)
+	// The relations being evaluated further up, so that cycles, such as groups that are members of each other,
+	// end instead of recursing forever.
+	visiting map[string]bool
+}
+
+// enter marks the relation on object as being evaluated, and returns its definition. It returns false if the
+// relation is already being evaluated.
+func (c *checker) enter(object ObjectRef, relation string, depth int) (*Definition, bool, error) {
+	if depth > maxCheckDepth {
+		return nil, false, fmt.Errorf("%w: at %s#%s", ErrCheckTooDeep, object, relation)
+	}
+	def, ok := c.schema.Definitions[object.Type]
+	if !ok {
+		return nil, false, fmt.Errorf("%w: undefined type %q", ErrInvalidTuple, object.Type)
+	}
+	if _, ok := def.Relations[relation]; !ok {
+		if _, ok := def.Permissions[relation]; !ok {
+			return nil, false, fmt.Errorf("%w: %s has no relation %q", ErrInvalidTuple, object.Type, relation)
+		}
+	}
+	key := object.String() + "#" + relation
+	if c.visiting[key] {
+		return nil, false, nil
+	}
+	c.visiting[key] = true
+	return def, true, nil
+}
+
+func (c *checker) leave(object ObjectRef, relation string) {
+	delete(c.visiting, object.String()+"#"+relation)
+}
+
+func (c *checker) check(object ObjectRef, relation string, subject Subject, depth int) (bool, error) {
+	def, ok, err := c.enter(object, relation, depth)
+	if err != nil || !ok {
+		return false, err
+	}
+	defer c.leave(object, relation)
+
+	if e, ok := def.Permissions[relation]; ok {
+		return c.eval(object, e, subject, depth)
+	}
+	tuples, err := c.read(object, relation)
+	if err != nil {
+		return false, err
+	}
+	for _, t := range tuples {
+		if t.Subject == subject {
+			return true, nil
+		}
+	}
+	for _, t := range tuples {
+		if t.Subject.Relation == "" {
+			continue
+		}
+		if ok, err := c.check(t.Subject.Object, t.Subject.Relation, subject, depth+1); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+func (c *checker) eval(object ObjectRef, e expr, subject Subject, depth int) (bool, error) {
+	switch e := e.(type) {
+	case *relationRef:
+		return c.check(object, e.name, subject, depth+1)
+	case *arrow:
+		tuples, err := c.read(object, e.tupleset)
+		if err != nil {
+			return false, err
+		}
+		for _, t := range tuples {
+			if ok, err := c.check(t.Subject.Object, e.relation, subject, depth+1); err != nil || ok {
+				return ok, err
+			}
+		}
+		return false, nil
+	case *setOp:
+		left, err := c.eval(object, e.left, subject, depth)
+		if err != nil {
+			return false, err
+		}
+		// Skip the right side when the left decides.
+		switch {
+		case e.op == '+' && left:
+			return true, nil
+		case e.op != '+' && !left:
+			return false, nil
+		}
+		right, err := c.eval(object, e.right, subject, depth)
+		if err != nil {
+			return false, err
+		}
+		if e.op == '-' {
+			return !right, nil
+		}
+		return right, nil
+	default:
+		return false, fmt.Errorf("unknown expression %T", e)
+	}
+}
+
+var setOpNames = map[byte]string{'+': "union", '&': "intersection", '-': "exclusion"}
+
+func (c *checker) expand(object ObjectRef, relation string, depth int) (*ExpandTree, error) {
+	def, ok, err := c.enter(object, relation, depth)
+	if err != nil {
+		return nil, err
+	} else if !ok {
+		// Everything under a cycle has already been expanded further up.
+		return &ExpandTree{Operation: "leaf", Object: object.String(), Relation: relation}, nil
+	}
+	defer c.leave(object, relation)
+
+	if e, ok := def.Permissions[relation]; ok {
+		tree, err := c.expandExpr(object, e, depth)
+		if err != nil {
+			return nil, err
+		}
+		// Permissions that are just another relation or an arrow keep its labels.
+		if tree.Relation == "" {
+			tree.Object, tree.Relation = object.String(), relation
+		}
+		return tree, nil
+	}
+	tuples, err := c.read(object, relation)
+	if err != nil {
+		return nil, err
+	}
+	tree := &ExpandTree{Operation: "leaf", Object: object.String(), Relation: relation}
+	for _, t := range tuples {
+		tree.Subjects = append(tree.Subjects, t.Subject.String())
+		if t.Subject.Relation != "" {
+			child, err := c.expand(t.Subject.Object, t.Subject.Relation, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, child)
+		}
+	}
+	return tree, nil
+}
+
+func (c *checker) expandExpr(object ObjectRef, e expr, depth int) (*ExpandTree, error) {
+	switch e := e.(type) {
+	case *relationRef:
+		return c.expand(object, e.name, depth+1)
+	case *arrow:
+		tuples, err := c.read(object, e.tupleset)
+		if err != nil {
+			return nil, err
+		}
+		tree := &ExpandTree{Operation: "arrow", Object: object.String(), Relation: e.String()}
+		for _, t := range tuples {
+			child, err := c.expand(t.Subject.Object, e.relation, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			tree.Children = append(tree.Children, child)
+		}
+		return tree, nil
+	case *setOp:
+		left, err := c.expandExpr(object, e.left, depth)
+		if err != nil {
+			return nil, err
+		}
+		right, err := c.expandExpr(object, e.right, depth)
+		if err != nil {
+			return nil, err
+		}
+		return &ExpandTree{Operation: setOpNames[e.op], Children: []*ExpandTree{left, right}}, nil
+	default:
+		return nil, fmt.Errorf("unknown expression %T", e)
+	}
+}
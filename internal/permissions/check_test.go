@@ -0,0 +1,207 @@
+package permissions
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestCheckNestedGroups(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	// bob is in family, which is in friends, which is in family again.
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.CreateGroup("alice", "friends"))
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	require.NoError(t, store.AddGroupMember("alice", "friends", GroupGrantee("family")))
+	require.NoError(t, store.AddGroupMember("alice", "family", GroupGrantee("friends")))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("friends"), "alice", "com.habitat.posts"))
+	require.ErrorIs(t, store.AddGroupMember("alice", "family", GroupGrantee("family")), ErrGroupInItself)
+	require.ErrorIs(t, store.AddGroupMember("alice", "family", GroupGrantee("nobody")), ErrGroupNotFound)
+
+	posts := ObjectRef{Type: typeObject, ID: ObjectID("alice", "com.habitat.posts")}
+	record := ObjectRef{Type: typeObject, ID: ObjectID("alice", RecordObject("com.habitat.posts", "post"))}
+	for _, check := range []struct {
+		subject  Subject
+		relation string
+		object   ObjectRef
+		expected bool
+	}{
+		{UserSubject("bob"), "member", groupSubject("alice", "friends").Object, true},
+		{UserSubject("carol"), "member", groupSubject("alice", "friends").Object, false},
+		{UserSubject("bob"), "read", record, true},
+		{UserSubject("bob"), "read_allow", record, false},
+		{UserSubject("bob"), "update", record, false},
+		{groupSubject("alice", "friends"), "read_allow", posts, true},
+		{UserSubject("carol"), "read", record, false},
+	} {
+		allowed, err := store.Check(check.subject, check.relation, check.object)
+		require.NoError(t, err)
+		require.Equal(t, check.expected, allowed, "%s#%s@%s", check.object, check.relation, check.subject)
+	}
+
+	// A deny on the record beats the inherited allow.
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: "bob",
+		Object:  RecordObject("com.habitat.posts", "post"),
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+	allowed, err := store.Check(UserSubject("bob"), "read", record)
+	require.NoError(t, err)
+	require.False(t, allowed)
+
+	groups, err := store.ListGroups("alice")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"family":  {"bob", GroupGrantee("friends")},
+		"friends": {GroupGrantee("family")},
+	}, groups)
+
+	// Deleting a group removes it from the groups it was in.
+	require.NoError(t, store.DeleteGroup("alice", "family"))
+	groups, err = store.ListGroups("alice")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"friends": {}}, groups)
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "other", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+
+	_, err = store.Check(UserSubject("bob"), "delete", record)
+	require.ErrorIs(t, err, ErrInvalidTuple)
+}
+
+func TestExpand(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "alice", "com.habitat"))
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: "carol",
+		Object:  "com.habitat.posts",
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+
+	tree, err := store.Expand("read", ObjectRef{Type: typeObject, ID: ObjectID("alice", "com.habitat.posts")})
+	require.NoError(t, err)
+
+	com := readTree("alice/com", &ExpandTree{}, &ExpandTree{}, nil)
+	habitat := readTree("alice/com.habitat", &ExpandTree{
+		Subjects: []string{"group:alice/family#member"},
+		Children: []*ExpandTree{{
+			Operation: "leaf",
+			Object:    "group:alice/family",
+			Relation:  "member",
+			Subjects:  []string{"user:bob"},
+		}},
+	}, &ExpandTree{}, com)
+	posts := readTree("alice/com.habitat.posts", &ExpandTree{}, &ExpandTree{Subjects: []string{"user:carol"}}, habitat)
+	require.Equal(t, posts, tree)
+}
+
+// readTree returns the expansion of read on an object, given its read_allow and read_deny leaves and the expansion
+// of its parent.
+func readTree(id string, allow *ExpandTree, deny *ExpandTree, parent *ExpandTree) *ExpandTree {
+	object := "object:" + id
+	allow.Operation, allow.Object, allow.Relation = "leaf", object, "read_allow"
+	deny.Operation, deny.Object, deny.Relation = "leaf", object, "read_deny"
+	arrow := &ExpandTree{Operation: "arrow", Object: object, Relation: "parent->read"}
+	if parent != nil {
+		arrow.Children = []*ExpandTree{parent}
+	}
+	return &ExpandTree{
+		Operation: "exclusion",
+		Object:    object,
+		Relation:  "read",
+		Children: []*ExpandTree{
+			{Operation: "union", Children: []*ExpandTree{allow, arrow}},
+			deny,
+		},
+	}
+}
+
+// TestCheckAgreesWithPolicy checks that Check, Policy and Explain decide the same way, over random grants to
+// random nested groups.
+func TestCheckAgreesWithPolicy(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	objects := []string{
+		"com",
+		"com.habitat",
+		"com.habitat.posts",
+		"com.habitat.posts:a",
+		"com.habitat.posts:b",
+		"com.habitat.posts.a",
+		"com.habitat.notes",
+		"com.habitat.notes:a",
+	}
+	records := map[string][]string{
+		"com.habitat.posts":   {"a", "a.b", "b", "c"},
+		"com.habitat.posts.a": {"b"},
+		"com.habitat.notes":   {"a", "b"},
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range 30 {
+		owner := fmt.Sprintf("owner%d", i)
+		groups := []string{"g0", "g1", "g2"}
+		for _, group := range groups {
+			require.NoError(t, store.CreateGroup(owner, group))
+		}
+		grantees := []string{"reader", "other"}
+		for _, group := range groups {
+			grantees = append(grantees, GroupGrantee(group))
+			if r.IntN(2) == 0 {
+				require.NoError(t, store.AddGroupMember(owner, group, "reader"))
+			}
+			// Groups may contain each other, including in cycles.
+			if other := groups[r.IntN(len(groups))]; other != group && r.IntN(2) == 0 {
+				require.NoError(t, store.AddGroupMember(owner, group, GroupGrantee(other)))
+			}
+		}
+		for range r.IntN(6) {
+			grant := Grant{
+				Grantee: grantees[r.IntN(len(grantees))],
+				Object:  objects[r.IntN(len(objects))],
+				Effect:  EffectAllow,
+				Action:  ActionRead,
+			}
+			if r.IntN(3) == 0 {
+				grant.Effect = EffectDeny
+			}
+			require.NoError(t, store.AddGrant(owner, grant))
+		}
+
+		for nsid, rkeys := range records {
+			policy, err := store.Policy(owner, "reader", nsid, ActionRead)
+			require.NoError(t, err)
+			for _, rkey := range rkeys {
+				hasPermission, err := store.HasPermission("reader", owner, nsid, rkey, ActionRead)
+				require.NoError(t, err)
+				require.Equal(t, policy.Allows(RecordObject(nsid, rkey)), hasPermission, owner, nsid, rkey)
+
+				explanation, err := store.Explain("reader", owner, RecordObject(nsid, rkey), ActionRead)
+				require.NoError(t, err)
+				require.Equal(t, hasPermission, explanation.Allowed, owner, nsid, rkey)
+			}
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package permissions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EventKind is what happened to a grant.
+type EventKind string
+
+const (
+	// EventGranted means a grant was added, or an existing one's effect or window was replaced.
+	EventGranted EventKind = "granted"
+	// EventRevoked means a grant was removed, directly or by deleting the group it was made to.
+	EventRevoked EventKind = "revoked"
+	// EventExpired means an expired grant was swept (see DeleteExpiredGrants).
+	EventExpired EventKind = "expired"
+	// EventShared means an existing grant to a group now also applies to Grantee, which was added to the group.
+	EventShared EventKind = "shared"
+	// EventMemberAdded and EventMemberRemoved mean Grantee was added to or removed from a group, which is given as
+	// the Object (see GroupGrantee). These events have no action or effect.
+	EventMemberAdded   EventKind = "member_added"
+	EventMemberRemoved EventKind = "member_removed"
+)
+
+// PermissionEvent records a change to one of an owner's grants. Events are only ever appended, so together they
+// are the history of who was granted what, and when.
+type PermissionEvent struct {
+	ID        int64     `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"createdAt"`
+	Owner     string    `gorm:"not null" json:"owner"`
+	Kind      EventKind `gorm:"not null" json:"kind"`
+	Grantee   string    `gorm:"not null" json:"grantee"`
+	Object    string    `gorm:"not null" json:"object"`
+	Action    Action    `gorm:"not null" json:"action,omitempty"`
+	Effect    Effect    `gorm:"not null" json:"effect,omitempty"`
+	// Who made the change. This is the owner, since only owners can change their grants, or empty for changes
+	// the node makes itself, such as sweeping expired grants.
+	Actor string `gorm:"not null;default:''" json:"actor,omitempty"`
+}
+
+// recordGrantEvent appends an event for a change to one of owner's grants.
+func recordGrantEvent(db *gorm.DB, kind EventKind, owner string, grant Grant, actor string) error {
+	return db.Create(&PermissionEvent{
+		CreatedAt: time.Now().UTC(),
+		Owner:     owner,
+		Kind:      kind,
+		Grantee:   grant.Grantee,
+		Object:    grant.Object,
+		Action:    grant.Action,
+		Effect:    grant.Effect,
+		Actor:     actor,
+	}).Error
+}
+
+// recordMemberEvent appends an event for a change to the members of one of owner's groups.
+func recordMemberEvent(db *gorm.DB, kind EventKind, owner string, name string, member string, actor string) error {
+	return db.Create(&PermissionEvent{
+		CreatedAt: time.Now().UTC(),
+		Owner:     owner,
+		Kind:      kind,
+		Grantee:   member,
+		Object:    GroupGrantee(name),
+		Actor:     actor,
+	}).Error
+}
+
+// recordShareEvents appends an EventShared for each unexpired allow grant that member gains by being added to one
+// of owner's groups: those to the group, and to the groups that it is a member of.
+func recordShareEvents(db *gorm.DB, owner string, name string, member string, actor string) error {
+	groups, err := subjectsIncluding(db, groupSubject(owner, name), owner)
+	if err != nil {
+		return err
+	}
+	relations := []string{}
+	for _, action := range []Action{ActionRead, ActionCreate, ActionUpdate} {
+		relations = append(relations, grantRelation(action, EffectAllow))
+	}
+	var rows []RelationTuple
+	err = db.Scopes(ownedBy(typeObject, owner), subjectIn(db, groups)).
+		Where("relation IN ?", relations).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now().UTC()).
+		Order("id").
+		Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	// The same object may be granted to more than one of the groups.
+	seen := map[string]bool{}
+	for _, row := range rows {
+		key := row.ObjectID + "#" + row.Relation
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		grant := grantFromTuple(owner, row.tuple())
+		grant.Grantee = member
+		if err := recordGrantEvent(db, EventShared, owner, grant, actor); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteGrants deletes the grant tuples matched by scope, recording an event of kind for each.
+func deleteGrants(db *gorm.DB, kind EventKind, actor string, scope func(*gorm.DB) *gorm.DB) (int64, error) {
+	var rows []RelationTuple
+	if err := db.Scopes(scope).Where("object_type = ?", typeObject).Find(&rows).Error; err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	ids := make([]uint, 0, len(rows))
+	for _, row := range rows {
+		t := row.tuple()
+		owner := t.Object.Owner()
+		if err := recordGrantEvent(db, kind, owner, grantFromTuple(owner, t), actor); err != nil {
+			return 0, err
+		}
+		ids = append(ids, row.ID)
+	}
+	result := db.Where("id IN ?", ids).Delete(&RelationTuple{})
+	return result.RowsAffected, result.Error
+}
+
+// ListPermissionEvents returns the changes to owner's grants and groups, newest first. If before is set, only events older
+// than the event with that ID are returned. At most limit events are returned, or all of them if it is zero.
+func (s *sqliteStore) ListPermissionEvents(owner string, before int64, limit int) ([]PermissionEvent, error) {
+	query := s.db.Where("owner = ?", owner).Order("id DESC")
+	if before != 0 {
+		query = query.Where("id < ?", before)
+	}
+	if limit != 0 {
+		query = query.Limit(limit)
+	}
+	events := []PermissionEvent{}
+	if err := query.Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to query permission events: %w", err)
+	}
+	return events, nil
+}
+
+// ListShareEvents returns the events where other owners allowed grantee something, directly or through the groups
+// it is currently a member of, oldest first. This includes the grants a group already had when grantee, or a group
+// containing it, was added to it. Only events after the event with ID after are returned, so that grantees can
+// poll for what has newly been shared with them. At most limit events are returned, or all of them if it is zero.
+func (s *sqliteStore) ListShareEvents(grantee string, after int64, limit int) ([]PermissionEvent, error) {
+	subjects, err := s.subjectsOf(grantee, "")
+	if err != nil {
+		return nil, err
+	}
+	// Events refer to groups by their owner and grantee, rather than by subject.
+	granted := s.db.Where("grantee = ?", grantee)
+	for _, subject := range subjects {
+		if subject.Object.Type != typeGroup {
+			continue
+		}
+		owner, name, _ := strings.Cut(subject.Object.ID, "/")
+		granted = granted.Or("owner = ? AND grantee = ?", owner, GroupGrantee(name))
+	}
+
+	query := s.db.Where(granted).
+		Where("owner != ? AND kind IN ? AND effect = ? AND id > ?", grantee, []EventKind{EventGranted, EventShared}, EffectAllow, after).
+		Order("id")
+	if limit != 0 {
+		query = query.Limit(limit)
+	}
+	events := []PermissionEvent{}
+	if err := query.Find(&events).Error; err != nil {
+		return nil, fmt.Errorf("failed to query permission events: %w", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,210 @@
+package permissions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// eventSummary is the part of an event that tests compare.
+type eventSummary struct {
+	Kind    EventKind
+	Grantee string
+	Object  string
+	Effect  Effect
+	Actor   string
+}
+
+func summarize(events []PermissionEvent) []eventSummary {
+	summaries := []eventSummary{}
+	for _, event := range events {
+		summaries = append(summaries, eventSummary{event.Kind, event.Grantee, event.Object, event.Effect, event.Actor})
+	}
+	return summaries
+}
+
+func TestPermissionEvents(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	hourAgo := time.Now().Add(-time.Hour)
+	require.NoError(t, store.AddLexiconReadPermission("bob", "alice", "com.habitat.posts.*"))
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: "bob",
+		Object:  "com.habitat.posts",
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+	require.NoError(t, store.RemoveLexiconReadPermission("bob", "alice", "com.habitat.posts"))
+	// Removing a grant that doesn't exist changes nothing.
+	require.NoError(t, store.RemoveLexiconReadPermission("bob", "alice", "com.habitat.posts"))
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "alice", "com.habitat.photos"))
+	require.NoError(t, store.DeleteGroup("alice", "family"))
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee:   "carol",
+		Object:    "com.habitat.notes",
+		Effect:    EffectAllow,
+		Action:    ActionRead,
+		ExpiresAt: &hourAgo,
+	}))
+	deleted, err := store.DeleteExpiredGrants(time.Now())
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	// Other owners' events are separate.
+	require.NoError(t, store.AddLexiconReadPermission("bob", "carol", "com.habitat.posts"))
+
+	events, err := store.ListPermissionEvents("alice", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []eventSummary{
+		{EventExpired, "carol", "com.habitat.notes", EffectAllow, ""},
+		{EventGranted, "carol", "com.habitat.notes", EffectAllow, "alice"},
+		{EventRevoked, GroupGrantee("family"), "com.habitat.photos", EffectAllow, "alice"},
+		{EventGranted, GroupGrantee("family"), "com.habitat.photos", EffectAllow, "alice"},
+		{EventRevoked, "bob", "com.habitat.posts", EffectDeny, "alice"},
+		{EventGranted, "bob", "com.habitat.posts", EffectDeny, "alice"},
+		{EventGranted, "bob", "com.habitat.posts", EffectAllow, "alice"},
+	}, summarize(events))
+	require.Equal(t, "alice", events[0].Owner)
+	require.Equal(t, ActionRead, events[0].Action)
+	require.WithinDuration(t, time.Now(), events[0].CreatedAt, time.Minute)
+
+	// Events are paged by ID.
+	page, err := store.ListPermissionEvents("alice", events[1].ID, 2)
+	require.NoError(t, err)
+	require.Equal(t, events[2:4], page)
+
+	// The log can't be rewritten.
+	require.Error(t, db.Model(&PermissionEvent{}).Where("id = ?", events[0].ID).Update("actor", "mallory").Error)
+	require.Error(t, db.Delete(&PermissionEvent{}, events[0].ID).Error)
+	unchanged, err := store.ListPermissionEvents("alice", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, events, unchanged)
+}
+
+func TestListShareEvents(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.CreateGroup("alice", "relatives"))
+	require.NoError(t, store.AddGroupMember("alice", "relatives", "bob"))
+	require.NoError(t, store.AddGroupMember("alice", "family", GroupGrantee("relatives")))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "alice", "com.habitat.photos"))
+	require.NoError(t, store.AddLexiconReadPermission("bob", "carol", "com.habitat.posts"))
+	// Neither denies, other grantees, other owners' groups nor bob's own grants are news to bob.
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: "bob",
+		Object:  "com.habitat.notes",
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+	require.NoError(t, store.AddLexiconReadPermission("dave", "alice", "com.habitat.notes"))
+	require.NoError(t, store.CreateGroup("carol", "family"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "carol", "com.habitat.notes"))
+	require.NoError(t, store.AddLexiconReadPermission("alice", "bob", "com.habitat.notes"))
+
+	events, err := store.ListShareEvents("bob", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []eventSummary{
+		{EventGranted, GroupGrantee("family"), "com.habitat.photos", EffectAllow, "alice"},
+		{EventGranted, "bob", "com.habitat.posts", EffectAllow, "carol"},
+	}, summarize(events))
+	require.Equal(t, "alice", events[0].Owner)
+
+	// Polling from the last event only returns new shares.
+	require.NoError(t, store.AddLexiconPermission("bob", "alice", "com.habitat.photos", ActionCreate))
+	newer, err := store.ListShareEvents("bob", events[1].ID, 0)
+	require.NoError(t, err)
+	require.Len(t, newer, 1)
+	require.Equal(t, ActionCreate, newer[0].Action)
+
+	limited, err := store.ListShareEvents("bob", 0, 1)
+	require.NoError(t, err)
+	require.Equal(t, events[:1], limited)
+}
+
+func TestGroupMemberEvents(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.CreateGroup("alice", "relatives"))
+	require.NoError(t, store.CreateGroup("alice", "cousins"))
+	require.NoError(t, store.AddGroupMember("alice", "relatives", GroupGrantee("family")))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "alice", "com.habitat.photos"))
+	require.NoError(t, store.AddLexiconPermission(GroupGrantee("family"), "alice", "com.habitat.photos", ActionCreate))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("relatives"), "alice", "com.habitat.posts"))
+	// Neither denied nor expired grants are shared.
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: GroupGrantee("family"),
+		Object:  "com.habitat.notes",
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+	hourAgo := time.Now().Add(-time.Hour)
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee:   GroupGrantee("family"),
+		Object:    "com.habitat.events",
+		Effect:    EffectAllow,
+		Action:    ActionRead,
+		ExpiresAt: &hourAgo,
+	}))
+
+	// bob starts polling before joining, so only events from now on are new to him.
+	before, err := store.ListPermissionEvents("alice", 0, 1)
+	require.NoError(t, err)
+	cursor := before[0].ID
+
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	// Adding an existing member changes nothing.
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	require.NoError(t, store.AddGroupMember("alice", "cousins", "carol"))
+	require.NoError(t, store.RemoveGroupMember("alice", "cousins", "carol"))
+	require.NoError(t, store.RemoveGroupMember("alice", "cousins", "carol"))
+	require.NoError(t, store.AddGroupMember("alice", "cousins", "carol"))
+	require.NoError(t, store.AddGroupMember("alice", "family", GroupGrantee("cousins")))
+	require.NoError(t, store.DeleteGroup("alice", "cousins"))
+
+	events, err := store.ListPermissionEvents("alice", 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []eventSummary{
+		// Deleting a group removes its members and its membership of other groups.
+		{EventMemberRemoved, GroupGrantee("cousins"), GroupGrantee("family"), "", "alice"},
+		{EventMemberRemoved, "carol", GroupGrantee("cousins"), "", "alice"},
+		// Groups are notified of what they gain too, including through groups containing their new group.
+		{EventShared, GroupGrantee("cousins"), "com.habitat.posts", EffectAllow, "alice"},
+		{EventShared, GroupGrantee("cousins"), "com.habitat.photos", EffectAllow, "alice"},
+		{EventShared, GroupGrantee("cousins"), "com.habitat.photos", EffectAllow, "alice"},
+		{EventMemberAdded, GroupGrantee("cousins"), GroupGrantee("family"), "", "alice"},
+		{EventMemberAdded, "carol", GroupGrantee("cousins"), "", "alice"},
+		{EventMemberRemoved, "carol", GroupGrantee("cousins"), "", "alice"},
+		{EventMemberAdded, "carol", GroupGrantee("cousins"), "", "alice"},
+		{EventShared, "bob", "com.habitat.posts", EffectAllow, "alice"},
+		{EventShared, "bob", "com.habitat.photos", EffectAllow, "alice"},
+		{EventShared, "bob", "com.habitat.photos", EffectAllow, "alice"},
+		{EventMemberAdded, "bob", GroupGrantee("family"), "", "alice"},
+	}, summarize(events[:13]))
+	require.Empty(t, events[12].Action)
+
+	shared, err := store.ListShareEvents("bob", cursor, 0)
+	require.NoError(t, err)
+	require.Equal(t, []eventSummary{
+		{EventShared, "bob", "com.habitat.photos", EffectAllow, "alice"},
+		{EventShared, "bob", "com.habitat.photos", EffectAllow, "alice"},
+		{EventShared, "bob", "com.habitat.posts", EffectAllow, "alice"},
+	}, summarize(shared))
+	require.Equal(t, []Action{ActionRead, ActionCreate}, []Action{shared[0].Action, shared[1].Action})
+}
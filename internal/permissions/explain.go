@@ -0,0 +1,71 @@
+package permissions
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reason is why a permission check was decided the way it was.
+type Reason string
+
+const (
+	// ReasonOwner means the requester owns the object, which always allows.
+	ReasonOwner Reason = "owner"
+	// ReasonGrant means the winning grant decided.
+	ReasonGrant Reason = "grant"
+	// ReasonNoGrant means no grant currently applies, which denies.
+	ReasonNoGrant Reason = "no matching grant"
+)
+
+// Explanation is how a permission check was decided (see Explain).
+type Explanation struct {
+	Allowed bool   `json:"allowed"`
+	Reason  Reason `json:"reason"`
+	// Every grant on the object or one of its prefixes to the requester or their groups, in the order they are
+	// considered: most specific first, then deny before allow.
+	Grants []ExplainedGrant `json:"grants"`
+}
+
+// ExplainedGrant is a grant that matched a permission check.
+type ExplainedGrant struct {
+	Grant
+	// Whether the grant's window includes the time of the check. Grants that aren't active are skipped.
+	Active bool `json:"active"`
+	// Whether this is the first active grant, which decided the check.
+	Winner bool `json:"winner"`
+}
+
+// Explain reports how HasPermission decides whether requester may perform action on one of owner's objects,
+// which is an NSID or a single record (see RecordObject).
+func (s *sqliteStore) Explain(requester string, owner string, object string, action Action) (*Explanation, error) {
+	if !objectRegex.MatchString(object) {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidObject, object)
+	}
+	object = normalizeObject(object)
+	if requester == owner {
+		return &Explanation{Allowed: true, Reason: ReasonOwner, Grants: []ExplainedGrant{}}, nil
+	}
+
+	// The same grants that HasPermission considers, except that those outside their window are included.
+	grants, err := s.matchingGrants(s.db, owner, requester, object, action, false)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	explanation := &Explanation{Reason: ReasonNoGrant, Grants: []ExplainedGrant{}}
+	for _, grant := range grants {
+		explained := ExplainedGrant{
+			Grant: grant,
+			Active: (grant.NotBefore == nil || !grant.NotBefore.After(now)) &&
+				(grant.ExpiresAt == nil || grant.ExpiresAt.After(now)),
+		}
+		if explained.Active && explanation.Reason == ReasonNoGrant {
+			explained.Winner = true
+			explanation.Reason = ReasonGrant
+			explanation.Allowed = explained.Effect == EffectAllow
+		}
+		explanation.Grants = append(explanation.Grants, explained)
+	}
+	return explanation, nil
+}
@@ -0,0 +1,74 @@
+package permissions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestExplain(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	explanation, err := store.Explain("alice", "alice", "com.habitat.posts", ActionRead)
+	require.NoError(t, err)
+	require.Equal(t, &Explanation{Allowed: true, Reason: ReasonOwner, Grants: []ExplainedGrant{}}, explanation)
+
+	explanation, err = store.Explain("bob", "alice", "com.habitat.posts", ActionRead)
+	require.NoError(t, err)
+	require.Equal(t, &Explanation{Reason: ReasonNoGrant, Grants: []ExplainedGrant{}}, explanation)
+
+	hourAgo := time.Now().Add(-time.Hour).UTC()
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	grants := []Grant{
+		// An expired allow on the record, which is skipped.
+		{
+			Grantee:   "bob",
+			Object:    RecordObject("com.habitat.posts", "post"),
+			Effect:    EffectAllow,
+			Action:    ActionRead,
+			ExpiresAt: &hourAgo,
+		},
+		// Deny and allow on the collection, where deny wins.
+		{Grantee: "bob", Object: "com.habitat.posts", Effect: EffectAllow, Action: ActionRead},
+		{Grantee: GroupGrantee("family"), Object: "com.habitat.posts", Effect: EffectDeny, Action: ActionRead},
+		// A less specific allow, which loses.
+		{Grantee: "bob", Object: "com.habitat", Effect: EffectAllow, Action: ActionRead},
+	}
+	for _, grant := range grants {
+		require.NoError(t, store.AddGrant("alice", grant))
+	}
+	// Neither other grantees, actions nor objects match.
+	require.NoError(t, store.AddLexiconReadPermission("carol", "alice", "com.habitat.posts"))
+	require.NoError(t, store.AddLexiconPermission("bob", "alice", "com.habitat.posts", ActionCreate))
+	require.NoError(t, store.AddLexiconReadPermission("bob", "alice", "com.habitat.postscards"))
+
+	object := RecordObject("com.habitat.posts", "post")
+	explanation, err = store.Explain("bob", "alice", object, ActionRead)
+	require.NoError(t, err)
+	require.Equal(t, &Explanation{
+		Allowed: false,
+		Reason:  ReasonGrant,
+		Grants: []ExplainedGrant{
+			{Grant: grants[0], Active: false},
+			{Grant: grants[2], Active: true, Winner: true},
+			{Grant: grants[1], Active: true},
+			{Grant: grants[3], Active: true},
+		},
+	}, explanation)
+
+	// Explanations agree with HasPermission.
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "post", ActionRead)
+	require.NoError(t, err)
+	require.Equal(t, explanation.Allowed, hasPermission)
+
+	_, err = store.Explain("bob", "alice", "com.habitat.%", ActionRead)
+	require.ErrorIs(t, err, ErrInvalidObject)
+}
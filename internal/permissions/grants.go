@@ -0,0 +1,260 @@
+package permissions
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// Effect is whether a grant allows or denies access. When several grants match an object, the most specific one
+// wins, and deny wins over allow at the same specificity.
+type Effect string
+
+const (
+	EffectAllow Effect = "allow"
+	EffectDeny  Effect = "deny"
+)
+
+var (
+	ErrInvalidEffect = fmt.Errorf("effect must be %q or %q", EffectAllow, EffectDeny)
+	ErrInvalidObject = errors.New("invalid permission object")
+	ErrInvalidWindow = errors.New("grants must expire after they start")
+)
+
+// Grant allows or denies a grantee an action on one of an owner's objects. Objects are one of:
+//   - An NSID prefix, covering every collection under it: "com.habitat"
+//   - An NSID, covering every record in the collection: "com.habitat.posts"
+//   - A single record, covering just that record: "com.habitat.posts:recordKey" (see RecordObject)
+type Grant struct {
+	// A DID, or one of the owner's groups (see GroupGrantee).
+	Grantee string `json:"grantee"`
+	Object  string `json:"object"`
+	Effect  Effect `json:"effect"`
+	Action  Action `json:"action"`
+	// If set, the grant only applies from NotBefore, and until ExpiresAt. Expired grants are eventually
+	// removed by the sweeper (see RunSweeper).
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// Objects are dot-separated NSID segments, optionally followed by a record key. A trailing "*" segment is accepted
+// for grants made before prefixes were matched implicitly, and dropped (see normalizeObject).
+var objectRegex = regexp.MustCompile(`^[a-zA-Z0-9-]+(\.[a-zA-Z0-9-]+)*(\.\*|:[a-zA-Z0-9._:~-]+)?$`)
+
+// recordSeparator separates a record's collection from its key in record objects. It can't appear in NSIDs, so
+// record objects can't be confused with collections.
+const recordSeparator = ":"
+
+// normalizeObject drops the trailing "*" segment from objects, which grants on prefixes don't need.
+func normalizeObject(object string) string {
+	return strings.TrimSuffix(object, ".*")
+}
+
+// RecordObject returns the object identifying a single record.
+func RecordObject(nsid string, rkey string) string {
+	return nsid + recordSeparator + rkey
+}
+
+// isRecordObject reports whether the object identifies a single record.
+func isRecordObject(object string) bool {
+	return strings.Contains(object, recordSeparator)
+}
+
+// objectParent returns the object one level above an object: a record's collection, or the NSID prefix one
+// segment up.
+func objectParent(object string) (string, bool) {
+	if collection, _, ok := strings.Cut(object, recordSeparator); ok {
+		return collection, true
+	}
+	i := strings.LastIndex(object, ".")
+	if i < 0 {
+		return "", false
+	}
+	return object[:i], true
+}
+
+func (g *Grant) validate() error {
+	if !objectRegex.MatchString(g.Object) {
+		return fmt.Errorf("%w: %q", ErrInvalidObject, g.Object)
+	}
+	switch g.Effect {
+	case EffectAllow, EffectDeny:
+	default:
+		return ErrInvalidEffect
+	}
+	if _, err := ParseAction(string(g.Action)); err != nil || g.Action == "" {
+		return ErrInvalidAction
+	}
+	if g.NotBefore != nil && g.ExpiresAt != nil && !g.ExpiresAt.After(*g.NotBefore) {
+		return ErrInvalidWindow
+	}
+	return nil
+}
+
+func utc(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+// grantRelation returns the relation that grants of effect for action are stored in (see HabitatSchema).
+func grantRelation(action Action, effect Effect) string {
+	return string(action) + "_" + string(effect)
+}
+
+// grantRelations returns the relations that grants for action are stored in.
+func grantRelations(action Action) []string {
+	return []string{grantRelation(action, EffectAllow), grantRelation(action, EffectDeny)}
+}
+
+// granteeSubject returns the subject that grants to grantee on owner's data are stored for.
+func granteeSubject(owner string, grantee string) Subject {
+	if isGroupGrantee(grantee) {
+		return groupSubject(owner, strings.TrimPrefix(grantee, GroupGranteePrefix))
+	}
+	return UserSubject(grantee)
+}
+
+// subjectGrantee is the reverse of granteeSubject.
+func subjectGrantee(owner string, subject Subject) string {
+	if subject.Object.Type == typeGroup {
+		return GroupGrantee(strings.TrimPrefix(subject.Object.ID, owner+"/"))
+	}
+	return subject.Object.ID
+}
+
+// grantFromTuple returns the grant that a tuple on one of owner's objects is stored for.
+func grantFromTuple(owner string, t Tuple) Grant {
+	action, effect, _ := strings.Cut(t.Relation, "_")
+	return Grant{
+		Grantee:   subjectGrantee(owner, t.Subject),
+		Object:    strings.TrimPrefix(t.Object.ID, owner+"/"),
+		Effect:    Effect(effect),
+		Action:    Action(action),
+		NotBefore: t.NotBefore,
+		ExpiresAt: t.ExpiresAt,
+	}
+}
+
+// AddGrant adds a grant on the owner's data, replacing the effect and window of any existing grant to the same
+// grantee for the same object and action. Group grantees must already exist.
+func (s *sqliteStore) AddGrant(owner string, grant Grant) error {
+	if err := grant.validate(); err != nil {
+		return err
+	}
+	grant.Object = normalizeObject(grant.Object)
+	if isGroupGrantee(grant.Grantee) {
+		if _, err := findGroup(s.db, owner, strings.TrimPrefix(grant.Grantee, GroupGranteePrefix)); err != nil {
+			return err
+		}
+	}
+
+	object := ObjectRef{Type: typeObject, ID: ObjectID(owner, grant.Object)}
+	subject := granteeSubject(owner, grant.Grantee)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// A grant is either an allow or a deny, so the other one goes.
+		if err := deleteTuples(tx, object, subject, grantRelations(grant.Action)...); err != nil {
+			return err
+		}
+		err := writeTuple(tx, Tuple{
+			Object:    object,
+			Relation:  grantRelation(grant.Action, grant.Effect),
+			Subject:   subject,
+			NotBefore: grant.NotBefore,
+			ExpiresAt: grant.ExpiresAt,
+		})
+		if err != nil {
+			return err
+		}
+		return recordGrantEvent(tx, EventGranted, owner, grant, owner)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add grant: %w", err)
+	}
+	return nil
+}
+
+// RemoveGrant removes the grant to grantee for the object and action, whatever its effect.
+func (s *sqliteStore) RemoveGrant(owner string, grantee string, object string, action Action) error {
+	ref := ObjectRef{Type: typeObject, ID: ObjectID(owner, normalizeObject(object))}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		_, err := deleteGrants(tx, EventRevoked, owner, func(db *gorm.DB) *gorm.DB {
+			return db.Scopes(objectIs(ref), subjectIn(tx, []Subject{granteeSubject(owner, grantee)})).
+				Where("relation IN ?", grantRelations(action))
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove grant: %w", err)
+	}
+	return nil
+}
+
+// ListGrants returns all of the owner's grants, ordered by object and grantee. This includes grants that haven't
+// started yet, and expired grants that haven't been swept yet.
+func (s *sqliteStore) ListGrants(owner string) ([]Grant, error) {
+	var rows []RelationTuple
+	err := s.db.Scopes(ownedBy(typeObject, owner)).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %w", err)
+	}
+
+	grants := make([]Grant, 0, len(rows))
+	for _, row := range rows {
+		grants = append(grants, grantFromTuple(owner, row.tuple()))
+	}
+	// Sorted here rather than in the query, since stored objects and grantees have prefixes.
+	slices.SortFunc(grants, func(a Grant, b Grant) int {
+		return cmp.Or(cmp.Compare(a.Object, b.Object), cmp.Compare(a.Grantee, b.Grantee), cmp.Compare(a.Action, b.Action))
+	})
+	return grants, nil
+}
+
+// DeleteExpiredGrants removes grants that expired at or before now, and returns how many were removed.
+func (s *sqliteStore) DeleteExpiredGrants(now time.Time) (int64, error) {
+	var deleted int64
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		deleted, err = deleteGrants(tx, EventExpired, "", func(db *gorm.DB) *gorm.DB {
+			return db.Where("expires_at <= ?", now.UTC())
+		})
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired grants: %w", err)
+	}
+	return deleted, nil
+}
+
+// RunSweeper deletes expired grants every interval until ctx is cancelled. Expired grants are already ignored
+// by permission checks, so this only keeps them from piling up. Failures are logged and retried at the next
+// interval.
+func RunSweeper(ctx context.Context, store Store, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			deleted, err := store.DeleteExpiredGrants(now)
+			if err != nil {
+				log.Error().Err(err).Msg("sweeping expired grants failed")
+				continue
+			}
+			if deleted > 0 {
+				log.Info().Msgf("swept %d expired grants", deleted)
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package permissions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/eagraf/habitat-new/internal/migrations"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestGrantRecordAndDeny(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	// Share a single photo.
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: "bob",
+		Object:  RecordObject("com.habitat.photos", "beach"),
+		Effect:  EffectAllow,
+		Action:  ActionRead,
+	}))
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.photos", "beach", ActionRead)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.photos", "party", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+
+	// Share everything under com.habitat except one photo.
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: "carol",
+		Object:  "com.habitat",
+		Effect:  EffectAllow,
+		Action:  ActionRead,
+	}))
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: "carol",
+		Object:  RecordObject("com.habitat.photos", "party"),
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+	hasPermission, err = store.HasPermission("carol", "alice", "com.habitat.photos", "beach", ActionRead)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+	hasPermission, err = store.HasPermission("carol", "alice", "com.habitat.photos", "party", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+
+	policy, err := store.Policy("alice", "carol", "com.habitat.photos", ActionRead)
+	require.NoError(t, err)
+	require.True(t, policy.Allows(RecordObject("com.habitat.photos", "beach")))
+	require.False(t, policy.Allows(RecordObject("com.habitat.photos", "party")))
+
+	// Deny wins over allow for the same object.
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: "dave",
+		Object:  "com.habitat.photos",
+		Effect:  EffectAllow,
+		Action:  ActionRead,
+	}))
+	require.NoError(t, store.CreateGroup("alice", "exes"))
+	require.NoError(t, store.AddGroupMember("alice", "exes", "dave"))
+	require.NoError(t, store.AddGrant("alice", Grant{
+		Grantee: GroupGrantee("exes"),
+		Object:  "com.habitat.photos",
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+	hasPermission, err = store.HasPermission("dave", "alice", "com.habitat.photos", "beach", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+}
+
+func TestGrantReplaceAndRemove(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	grant := Grant{Grantee: "bob", Object: "com.habitat.posts", Effect: EffectAllow, Action: ActionRead}
+	require.NoError(t, store.AddGrant("alice", grant))
+
+	// Adding the same grant with another effect replaces it.
+	grant.Effect = EffectDeny
+	require.NoError(t, store.AddGrant("alice", grant))
+	grants, err := store.ListGrants("alice")
+	require.NoError(t, err)
+	require.Equal(t, []Grant{grant}, grants)
+
+	require.NoError(t, store.RemoveGrant("alice", "bob", "com.habitat.posts", ActionRead))
+	grants, err = store.ListGrants("alice")
+	require.NoError(t, err)
+	require.Empty(t, grants)
+
+	// Permissions can be granted again after being revoked.
+	require.NoError(t, store.AddLexiconReadPermission("bob", "alice", "com.habitat.posts"))
+	require.NoError(t, store.RemoveLexiconReadPermission("bob", "alice", "com.habitat.posts"))
+	require.NoError(t, store.AddLexiconReadPermission("bob", "alice", "com.habitat.posts"))
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+}
+
+func TestGrantValidation(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	valid := Grant{Grantee: "bob", Object: "com.habitat.posts", Effect: EffectAllow, Action: ActionRead}
+	for _, object := range []string{"", "com..habitat", "com.habitat.", "com.habitat.%", "com.*.posts"} {
+		grant := valid
+		grant.Object = object
+		require.ErrorIs(t, store.AddGrant("alice", grant), ErrInvalidObject, object)
+	}
+
+	grant := valid
+	grant.Effect = "maybe"
+	require.ErrorIs(t, store.AddGrant("alice", grant), ErrInvalidEffect)
+
+	grant = valid
+	grant.Action = ""
+	require.ErrorIs(t, store.AddGrant("alice", grant), ErrInvalidAction)
+
+	grant = valid
+	grant.Grantee = GroupGrantee("nobody")
+	require.ErrorIs(t, store.AddGrant("alice", grant), ErrGroupNotFound)
+}
+
+func TestGrantWindow(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	now := time.Now()
+	hourAgo, inAnHour := now.Add(-time.Hour), now.Add(time.Hour)
+	for grantee, grant := range map[string]Grant{
+		"current": {NotBefore: &hourAgo, ExpiresAt: &inAnHour},
+		"expired": {ExpiresAt: &hourAgo},
+		"future":  {NotBefore: &inAnHour},
+	} {
+		grant.Grantee, grant.Object, grant.Effect, grant.Action = grantee, "com.habitat.posts", EffectAllow, ActionRead
+		require.NoError(t, store.AddGrant("alice", grant))
+	}
+
+	for grantee, expected := range map[string]bool{"current": true, "expired": false, "future": false} {
+		hasPermission, err := store.HasPermission(grantee, "alice", "com.habitat.posts", "record1", ActionRead)
+		require.NoError(t, err)
+		require.Equal(t, expected, hasPermission, grantee)
+
+		policy, err := store.Policy("alice", grantee, "com.habitat.posts", ActionRead)
+		require.NoError(t, err)
+		require.Equal(t, expected, policy.Allows(RecordObject("com.habitat.posts", "record1")), grantee)
+	}
+
+	permissions, err := store.ListReadPermissionsByLexicon("alice")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"com.habitat.posts": {"current"}}, permissions)
+
+	grants, err := store.ListGrants("alice")
+	require.NoError(t, err)
+	require.Len(t, grants, 3)
+	require.Equal(t, "current", grants[0].Grantee)
+	require.True(t, hourAgo.Equal(*grants[0].NotBefore))
+	require.True(t, inAnHour.Equal(*grants[0].ExpiresAt))
+
+	// Only expired grants are swept.
+	deleted, err := store.DeleteExpiredGrants(now)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), deleted)
+	grants, err = store.ListGrants("alice")
+	require.NoError(t, err)
+	require.Len(t, grants, 2)
+	require.NotContains(t, []string{grants[0].Grantee, grants[1].Grantee}, "expired")
+
+	// Re-adding a grant without a window makes it permanent.
+	require.NoError(t, store.AddLexiconReadPermission("future", "alice", "com.habitat.posts"))
+	hasPermission, err := store.HasPermission("future", "alice", "com.habitat.posts", "record1", ActionRead)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+
+	require.ErrorIs(t, store.AddGrant("alice", Grant{
+		Grantee:   "bob",
+		Object:    "com.habitat.posts",
+		Effect:    EffectAllow,
+		Action:    ActionRead,
+		NotBefore: &inAnHour,
+		ExpiresAt: &hourAgo,
+	}), ErrInvalidWindow)
+}
+
+func TestNormalizeLegacyObjects(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	// Grants made before "nsid.*" and "nsid" were the same object.
+	legacy := migrations.Set{Component: Migrations.Component, Migrations: Migrations.Migrations[:5]}
+	_, err = legacy.Up(db)
+	require.NoError(t, err)
+	for _, row := range [][]string{
+		{"bob", "com.habitat.posts.*", "allow"},
+		{"carol", "com.habitat.posts.*", "deny"},
+		{"carol", "com.habitat.posts", "allow"},
+		{"dave", "com.habitat.posts.*", "allow"},
+		{"dave", "com.habitat.posts", "allow"},
+	} {
+		require.NoError(t, db.Exec(
+			"INSERT INTO permissions (grantee, owner, object, effect) VALUES (?, 'alice', ?, ?)",
+			row[0], row[1], row[2],
+		).Error)
+	}
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+	grants, err := store.ListGrants("alice")
+	require.NoError(t, err)
+	require.Equal(t, []Grant{
+		{Grantee: "bob", Object: "com.habitat.posts", Effect: EffectAllow, Action: ActionRead},
+		{Grantee: "carol", Object: "com.habitat.posts", Effect: EffectDeny, Action: ActionRead},
+		{Grantee: "dave", Object: "com.habitat.posts", Effect: EffectAllow, Action: ActionRead},
+	}, grants)
+
+	// New grants on "nsid.*" are stored as "nsid", and can be removed either way.
+	require.NoError(t, store.AddLexiconReadPermission("erin", "alice", "com.habitat.notes.*"))
+	hasPermission, err := store.HasPermission("erin", "alice", "com.habitat.notes", "record1", ActionRead)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+	require.NoError(t, store.RemoveLexiconReadPermission("erin", "alice", "com.habitat.notes"))
+	hasPermission, err = store.HasPermission("erin", "alice", "com.habitat.notes", "record1", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+}
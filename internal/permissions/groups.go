@@ -0,0 +1,217 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Groups are named sets of DIDs owned by a user, such as "family" or "coworkers". Permissions are granted to a
+// group by using GroupGrantee(name) as the grantee, and apply to everyone who is a member of the owner's group
+// at the time of the check. Groups may also contain the owner's other groups, whose members are then members too.
+
+// GroupGranteePrefix marks a grantee as one of the owner's groups rather than a DID.
+const GroupGranteePrefix = "group:"
+
+var (
+	ErrGroupNotFound    = errors.New("group not found")
+	ErrGroupExists      = errors.New("group already exists")
+	ErrInvalidGroupName = errors.New("invalid group name")
+	ErrGroupInItself    = errors.New("a group can't be a member of itself")
+)
+
+var groupNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// GroupGrantee returns the grantee that permissions granted to the named group are stored under.
+func GroupGrantee(name string) string {
+	return GroupGranteePrefix + name
+}
+
+// PermissionGroup is a named group of grantees owned by a user.
+type PermissionGroup struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Owner     string `gorm:"not null"`
+	Name      string `gorm:"not null"`
+}
+
+// CreateGroup creates an empty group owned by owner.
+func (s *sqliteStore) CreateGroup(owner string, name string) error {
+	if !groupNameRegex.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidGroupName, name)
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&PermissionGroup{}).Where("owner = ? AND name = ?", owner, name).Count(&count).Error; err != nil {
+			return err
+		} else if count > 0 {
+			return ErrGroupExists
+		}
+		return tx.Create(&PermissionGroup{Owner: owner, Name: name}).Error
+	})
+	if err != nil && !errors.Is(err, ErrGroupExists) {
+		return fmt.Errorf("failed to create group: %w", err)
+	}
+	return err
+}
+
+// DeleteGroup deletes a group along with its memberships, its membership of other groups and any permissions
+// granted to it.
+func (s *sqliteStore) DeleteGroup(owner string, name string) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		group, err := findGroup(tx, owner, name)
+		if err != nil {
+			return err
+		}
+		members := groupSubject(owner, name)
+		var memberships []RelationTuple
+		// Its members, and the groups it is a member of.
+		err = tx.Where("relation = ?", relationMember).
+			Where(tx.Where("object_type = ? AND object_id = ?", members.Object.Type, members.Object.ID).Or(
+				"subject_type = ? AND subject_id = ? AND subject_relation = ?",
+				members.Object.Type, members.Object.ID, members.Relation,
+			)).
+			Order("id").
+			Find(&memberships).Error
+		if err != nil {
+			return err
+		}
+		for _, row := range memberships {
+			t := row.tuple()
+			name, member := group.Name, subjectGrantee(owner, t.Subject)
+			if t.Object != members.Object {
+				name, member = strings.TrimPrefix(t.Object.ID, owner+"/"), GroupGrantee(group.Name)
+			}
+			if err := recordMemberEvent(tx, EventMemberRemoved, owner, name, member, owner); err != nil {
+				return err
+			}
+		}
+		if err := tx.Scopes(objectIs(members.Object)).Delete(&RelationTuple{}).Error; err != nil {
+			return err
+		}
+		// So the grants don't come back if a group with the same name is created later.
+		_, err = deleteGrants(tx, EventRevoked, owner, subjectIn(tx, []Subject{members}))
+		if err != nil {
+			return err
+		}
+		if err := tx.Scopes(subjectIn(tx, []Subject{members})).Delete(&RelationTuple{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(group).Error
+	})
+	if err != nil && !errors.Is(err, ErrGroupNotFound) {
+		return fmt.Errorf("failed to delete group: %w", err)
+	}
+	return err
+}
+
+// AddGroupMember adds member to the owner's group. Members are DIDs, or the owner's other groups (see
+// GroupGrantee), which must already exist. Adding an existing member is a no-op. New members are notified of the
+// grants they gain through the group (see ListShareEvents).
+func (s *sqliteStore) AddGroupMember(owner string, name string, member string) error {
+	group, err := findGroup(s.db, owner, name)
+	if err != nil {
+		return err
+	}
+	if isGroupGrantee(member) {
+		memberName := strings.TrimPrefix(member, GroupGranteePrefix)
+		if memberName == name {
+			return ErrGroupInItself
+		} else if _, err := findGroup(s.db, owner, memberName); err != nil {
+			return err
+		}
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		object, subject := groupSubject(owner, group.Name).Object, granteeSubject(owner, member)
+		var existing int64
+		err := tx.Model(&RelationTuple{}).
+			Scopes(objectIs(object), subjectIn(tx, []Subject{subject})).
+			Where("relation = ?", relationMember).
+			Count(&existing).Error
+		if err != nil || existing > 0 {
+			return err
+		}
+		if err := writeTuple(tx, Tuple{Object: object, Relation: relationMember, Subject: subject}); err != nil {
+			return err
+		}
+		if err := recordMemberEvent(tx, EventMemberAdded, owner, group.Name, member, owner); err != nil {
+			return err
+		}
+		return recordShareEvents(tx, owner, group.Name, member, owner)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to add group member: %w", err)
+	}
+	return nil
+}
+
+// RemoveGroupMember removes member from the owner's group.
+func (s *sqliteStore) RemoveGroupMember(owner string, name string, member string) error {
+	group, err := findGroup(s.db, owner, name)
+	if err != nil {
+		return err
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Scopes(
+			objectIs(groupSubject(owner, group.Name).Object),
+			subjectIn(tx, []Subject{granteeSubject(owner, member)}),
+		).Where("relation = ?", relationMember).Delete(&RelationTuple{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return recordMemberEvent(tx, EventMemberRemoved, owner, group.Name, member, owner)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove group member: %w", err)
+	}
+	return nil
+}
+
+// ListGroups returns a map of the owner's group names to their members. Groups that are members of other groups
+// are listed as their grantee (see GroupGrantee).
+func (s *sqliteStore) ListGroups(owner string) (map[string][]string, error) {
+	var groups []PermissionGroup
+	if err := s.db.Where("owner = ?", owner).Find(&groups).Error; err != nil {
+		return nil, fmt.Errorf("failed to query groups: %w", err)
+	}
+	result := make(map[string][]string, len(groups))
+	for _, group := range groups {
+		result[group.Name] = []string{}
+	}
+
+	var rows []RelationTuple
+	err := s.db.Scopes(ownedBy(typeGroup, owner)).Where("relation = ?", relationMember).Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query group members: %w", err)
+	}
+	for _, row := range rows {
+		t := row.tuple()
+		name := strings.TrimPrefix(t.Object.ID, owner+"/")
+		result[name] = append(result[name], subjectGrantee(owner, t.Subject))
+	}
+	for _, members := range result {
+		slices.Sort(members)
+	}
+	return result, nil
+}
+
+func findGroup(db *gorm.DB, owner string, name string) (*PermissionGroup, error) {
+	var group PermissionGroup
+	err := db.Where("owner = ? AND name = ?", owner, name).First(&group).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %q", ErrGroupNotFound, name)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query group: %w", err)
+	}
+	return &group, nil
+}
+
+// isGroupGrantee reports whether grantee refers to a group rather than a DID.
+func isGroupGrantee(grantee string) bool {
+	return strings.HasPrefix(grantee, GroupGranteePrefix)
+}
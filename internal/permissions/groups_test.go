@@ -0,0 +1,113 @@
+package permissions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestGroupPermissions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "alice", "com.habitat.posts"))
+
+	// Bob can read through the group, charlie can't.
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+
+	hasPermission, err = store.HasPermission("charlie", "alice", "com.habitat.posts", "record1", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+
+	policy, err := store.Policy("alice", "bob", "com.habitat.posts", ActionRead)
+	require.NoError(t, err)
+	require.True(t, policy.Allows(RecordObject("com.habitat.posts", "record1")))
+
+	// Groups are scoped to their owner: membership in alice's group grants nothing on carol's data.
+	require.NoError(t, store.CreateGroup("carol", "family"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "carol", "com.habitat.posts"))
+	hasPermission, err = store.HasPermission("bob", "carol", "com.habitat.posts", "record1", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+
+	// Removing bob from the group revokes his access.
+	require.NoError(t, store.RemoveGroupMember("alice", "family", "bob"))
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+}
+
+func TestGroupDeleteRemovesGrants(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "alice", "com.habitat.posts"))
+	require.NoError(t, store.DeleteGroup("alice", "family"))
+
+	permissions, err := store.ListReadPermissionsByLexicon("alice")
+	require.NoError(t, err)
+	require.Empty(t, permissions)
+
+	// Recreating the group doesn't bring back its members or grants.
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	groups, err := store.ListGroups("alice")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"family": {}}, groups)
+
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+}
+
+func TestGroupManagement(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	require.NoError(t, store.CreateGroup("alice", "family"))
+	require.NoError(t, store.CreateGroup("alice", "coworkers"))
+	require.ErrorIs(t, store.CreateGroup("alice", "family"), ErrGroupExists)
+	require.ErrorIs(t, store.CreateGroup("alice", "group:family"), ErrInvalidGroupName)
+
+	require.NoError(t, store.AddGroupMember("alice", "family", "charlie"))
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	// Adding a member twice is a no-op.
+	require.NoError(t, store.AddGroupMember("alice", "family", "bob"))
+	require.NoError(t, store.AddGroupMember("alice", "coworkers", "bob"))
+
+	groups, err := store.ListGroups("alice")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{
+		"family":    {"bob", "charlie"},
+		"coworkers": {"bob"},
+	}, groups)
+
+	groups, err = store.ListGroups("bob")
+	require.NoError(t, err)
+	require.Empty(t, groups)
+
+	require.ErrorIs(t, store.AddGroupMember("alice", "friends", "bob"), ErrGroupNotFound)
+	require.ErrorIs(t, store.RemoveGroupMember("alice", "friends", "bob"), ErrGroupNotFound)
+	require.ErrorIs(t, store.DeleteGroup("alice", "friends"), ErrGroupNotFound)
+	require.ErrorIs(
+		t,
+		store.AddLexiconReadPermission(GroupGrantee("friends"), "alice", "com.habitat.posts"),
+		ErrGroupNotFound,
+	)
+}
@@ -0,0 +1,301 @@
+package permissions
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/eagraf/habitat-new/internal/migrations"
+	"gorm.io/gorm"
+)
+
+// Migrations are the versioned schema changes for the permissions tables.
+//
+// Migrations must never be edited once released; add a new one instead. Version 1 matches the schema
+// that AutoMigrate used to create, so databases that predate this framework are picked up where they are.
+var Migrations = migrations.Set{
+	Component: "permissions",
+	Migrations: []migrations.Migration{
+		{
+			Version: 1,
+			Name:    "create_permissions",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"CREATE TABLE IF NOT EXISTS `permissions` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`grantee` text NOT NULL,`owner` text NOT NULL,`object` text NOT NULL,`effect` text NOT NULL,CONSTRAINT `chk_permissions_effect` CHECK (effect IN ('allow', 'deny')))",
+					"CREATE INDEX IF NOT EXISTS `idx_permissions_owner` ON `permissions`(`owner`)",
+					"CREATE UNIQUE INDEX IF NOT EXISTS `idx_grantee_owner_object` ON `permissions`(`grantee`,`owner`,`object`)",
+					"CREATE INDEX IF NOT EXISTS `idx_permissions_grantee_owner` ON `permissions`(`grantee`,`owner`)",
+					"CREATE INDEX IF NOT EXISTS `idx_permissions_deleted_at` ON `permissions`(`deleted_at`)",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 2,
+			Name:    "create_permission_groups",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"CREATE TABLE `permission_groups` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`owner` text NOT NULL,`name` text NOT NULL)",
+					"CREATE UNIQUE INDEX `idx_permission_groups_owner_name` ON `permission_groups`(`owner`,`name`)",
+					"CREATE TABLE `permission_group_members` (`group_id` integer NOT NULL,`member` text NOT NULL,`created_at` datetime,PRIMARY KEY (`group_id`,`member`))",
+					"CREATE INDEX `idx_permission_group_members_member` ON `permission_group_members`(`member`)",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 3,
+			Name:    "add_permission_action",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"ALTER TABLE `permissions` ADD COLUMN `action` text NOT NULL DEFAULT 'read' CONSTRAINT `chk_permissions_action` CHECK (action IN ('read', 'create', 'update'))",
+					"DROP INDEX `idx_grantee_owner_object`",
+					"CREATE UNIQUE INDEX `idx_grantee_owner_object_action` ON `permissions`(`grantee`,`owner`,`object`,`action`)",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 4,
+			Name:    "add_permission_window",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"ALTER TABLE `permissions` ADD COLUMN `not_before` datetime",
+					"ALTER TABLE `permissions` ADD COLUMN `expires_at` datetime",
+					"CREATE INDEX `idx_permissions_expires_at` ON `permissions`(`expires_at`)",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 5,
+			Name:    "create_capabilities",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"CREATE TABLE `capabilities` (`id` text PRIMARY KEY,`created_at` datetime,`owner` text NOT NULL,`object` text NOT NULL,`expires_at` datetime,`max_uses` integer,`uses` integer NOT NULL DEFAULT 0)",
+					"CREATE INDEX `idx_capabilities_owner` ON `capabilities`(`owner`)",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			// Grants on "nsid.*" mean the same as grants on "nsid", which they are rewritten to. Where both exist
+			// for the same grantee and action, the deny wins, as it would when evaluated. Soft-deleted grants are
+			// left over from revokes, and are dropped first so they don't get in the way.
+			Version: 6,
+			Name:    "normalize_permission_objects",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"DELETE FROM `permissions` WHERE `deleted_at` IS NOT NULL",
+					"UPDATE `permissions` SET `effect` = 'deny' WHERE EXISTS (SELECT 1 FROM `permissions` AS `p` WHERE `p`.`object` = `permissions`.`object` || '.*' AND `p`.`grantee` = `permissions`.`grantee` AND `p`.`owner` = `permissions`.`owner` AND `p`.`action` = `permissions`.`action` AND `p`.`effect` = 'deny')",
+					"DELETE FROM `permissions` WHERE `object` LIKE '%.*' AND EXISTS (SELECT 1 FROM `permissions` AS `p` WHERE `p`.`object` || '.*' = `permissions`.`object` AND `p`.`grantee` = `permissions`.`grantee` AND `p`.`owner` = `permissions`.`owner` AND `p`.`action` = `permissions`.`action`)",
+					"UPDATE `permissions` SET `object` = SUBSTR(`object`, 1, LENGTH(`object`) - 2) WHERE `object` LIKE '%.*'",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			// Grants and group memberships become relation tuples (see HabitatSchema). Group grantees become the
+			// group's members, and each grant's action and effect become its relation. Groups themselves stay in
+			// permission_groups, which tuples refer to by owner and name.
+			Version: 7,
+			Name:    "create_relation_tuples",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"CREATE TABLE `relation_tuples` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`object_type` text NOT NULL,`object_id` text NOT NULL,`relation` text NOT NULL,`subject_type` text NOT NULL,`subject_id` text NOT NULL,`subject_relation` text NOT NULL DEFAULT '',`not_before` datetime,`expires_at` datetime)",
+					"CREATE UNIQUE INDEX `idx_relation_tuples_tuple` ON `relation_tuples`(`object_type`,`object_id`,`relation`,`subject_type`,`subject_id`,`subject_relation`)",
+					"CREATE INDEX `idx_relation_tuples_subject` ON `relation_tuples`(`subject_type`,`subject_id`,`subject_relation`)",
+					"CREATE INDEX `idx_relation_tuples_expires_at` ON `relation_tuples`(`expires_at`)",
+					"INSERT INTO `relation_tuples` (`created_at`,`object_type`,`object_id`,`relation`,`subject_type`,`subject_id`,`subject_relation`,`not_before`,`expires_at`) SELECT `created_at`, 'object', `owner` || '/' || `object`, `action` || '_' || `effect`, CASE WHEN `grantee` LIKE 'group:%' THEN 'group' ELSE 'user' END, CASE WHEN `grantee` LIKE 'group:%' THEN `owner` || '/' || SUBSTR(`grantee`, 7) ELSE `grantee` END, CASE WHEN `grantee` LIKE 'group:%' THEN 'member' ELSE '' END, `not_before`, `expires_at` FROM `permissions` WHERE `deleted_at` IS NULL",
+					"INSERT INTO `relation_tuples` (`created_at`,`object_type`,`object_id`,`relation`,`subject_type`,`subject_id`) SELECT `m`.`created_at`, 'group', `g`.`owner` || '/' || `g`.`name`, 'member', 'user', `m`.`member` FROM `permission_group_members` AS `m` JOIN `permission_groups` AS `g` ON `g`.`id` = `m`.`group_id`",
+					"DROP TABLE `permissions`",
+					"DROP TABLE `permission_group_members`",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			// The triggers keep the event log append-only.
+			Version: 8,
+			Name:    "create_permission_events",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"CREATE TABLE `permission_events` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`owner` text NOT NULL,`kind` text NOT NULL,`grantee` text NOT NULL,`object` text NOT NULL,`action` text NOT NULL,`effect` text NOT NULL,`actor` text NOT NULL DEFAULT '')",
+					"CREATE INDEX `idx_permission_events_owner` ON `permission_events`(`owner`,`id`)",
+					"CREATE INDEX `idx_permission_events_grantee` ON `permission_events`(`grantee`,`id`)",
+					"CREATE TRIGGER `permission_events_no_update` BEFORE UPDATE ON `permission_events` BEGIN SELECT RAISE(ABORT, 'permission events are append-only'); END",
+					"CREATE TRIGGER `permission_events_no_delete` BEFORE DELETE ON `permission_events` BEGIN SELECT RAISE(ABORT, 'permission events are append-only'); END",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			// Capabilities used to store a collection or "collection.rkey" as one object, which can't be told
+			// apart. Existing objects are kept as collections here, and split by split_capability_records.
+			Version: 9,
+			Name:    "split_capability_objects",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"ALTER TABLE `capabilities` RENAME COLUMN `object` TO `collection`",
+					"ALTER TABLE `capabilities` ADD COLUMN `rkey` text NOT NULL DEFAULT ''",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			// Record objects used to be "nsid.rkey", and are now "nsid:rkey" (see RecordObject).
+			Version: 10,
+			Name:    "separate_record_objects",
+			Up:      separateRecordObjects,
+		},
+		{
+			// Denies that separate_record_objects couldn't tell apart from collections are applied to every
+			// record they could name as well (see denyAmbiguousRecordObjects).
+			Version: 11,
+			Name:    "deny_ambiguous_record_objects",
+			Up:      denyAmbiguousRecordObjects,
+		},
+		{
+			// Kept as collections, capabilities handed out for records stopped working (see splitCapabilityRecords).
+			Version: 12,
+			Name:    "split_capability_records",
+			Up:      splitCapabilityRecords,
+		},
+	},
+}
+
+// separateRecordObjects rewrites the record objects of grants to "nsid:rkey". Only segments with characters that
+// can't appear in NSIDs must be part of a record key, so the record key is taken to start at the first of them.
+// Other objects can't be told apart from collections, and are left as they are; as collections, allows never cover
+// more than they did before, but denies may cover less, which denyAmbiguousRecordObjects makes up for.
+func separateRecordObjects(tx *gorm.DB) error {
+	var rows []RelationTuple
+	if err := tx.Where("object_type = ?", typeObject).Find(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		owner, object, _ := strings.Cut(row.ObjectID, "/")
+		segments := strings.Split(object, ".")
+		i := slices.IndexFunc(segments, func(segment string) bool {
+			return strings.ContainsAny(segment, "_~:")
+		})
+		if i < 1 {
+			continue
+		}
+		id := ObjectID(owner, RecordObject(strings.Join(segments[:i], "."), strings.Join(segments[i:], ".")))
+		if err := tx.Model(&RelationTuple{}).Where("id = ?", row.ID).Update("object_id", id).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// denyAmbiguousRecordObjects copies every deny on an object that separate_record_objects left as a collection to
+// each record it could have named, keeping the original. An object of n segments could be any record in the
+// collections made of its first 3 (the fewest an NSID has) to n-1 segments, so denying all of them can only deny
+// more than was meant, never less.
+func denyAmbiguousRecordObjects(tx *gorm.DB) error {
+	var rows []struct {
+		ID       uint
+		ObjectID string
+		Relation string
+	}
+	err := tx.Table("relation_tuples").
+		Select("id", "object_id", "relation").
+		Where("object_type = ?", typeObject).
+		Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		owner, object, _ := strings.Cut(row.ObjectID, "/")
+		if !strings.HasSuffix(row.Relation, "_deny") || isRecordObject(object) {
+			continue
+		}
+		segments := strings.Split(object, ".")
+		for i := 3; i < len(segments); i++ {
+			id := ObjectID(owner, RecordObject(strings.Join(segments[:i], "."), strings.Join(segments[i:], ".")))
+			err := tx.Exec(
+				"INSERT OR IGNORE INTO `relation_tuples` (`created_at`,`object_type`,`object_id`,`relation`,`subject_type`,`subject_id`,`subject_relation`,`not_before`,`expires_at`) SELECT `created_at`, `object_type`, ?, `relation`, `subject_type`, `subject_id`, `subject_relation`, `not_before`, `expires_at` FROM `relation_tuples` WHERE `id` = ?",
+				id,
+				row.ID,
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// splitCapabilityRecords splits capabilities that split_capability_objects kept as collections into a collection and
+// record key wherever the object could be a record, so that links to records work again. As in separateRecordObjects,
+// the record key starts at the first segment that can't appear in NSIDs, or else is the last segment, provided the
+// segments before it make an NSID.
+func splitCapabilityRecords(tx *gorm.DB) error {
+	var rows []struct {
+		ID         string
+		Collection string
+	}
+	if err := tx.Table("capabilities").Select("id", "collection").Where("rkey = ''").Scan(&rows).Error; err != nil {
+		return err
+	}
+	for _, row := range rows {
+		segments := strings.Split(row.Collection, ".")
+		i := slices.IndexFunc(segments, func(segment string) bool {
+			return strings.ContainsAny(segment, "_~:")
+		})
+		if i < 0 {
+			i = len(segments) - 1
+		}
+		// NSIDs have at least 3 segments.
+		if i < 3 {
+			continue
+		}
+		err := tx.Exec(
+			"UPDATE `capabilities` SET `collection` = ?, `rkey` = ? WHERE `id` = ?",
+			strings.Join(segments[:i], "."),
+			strings.Join(segments[i:], "."),
+			row.ID,
+		).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
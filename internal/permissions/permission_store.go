@@ -2,17 +2,58 @@ package permissions
 
 import (
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// Action is what a permission allows the grantee to do with the owner's records.
+type Action string
+
+const (
+	// ActionRead allows reading records.
+	ActionRead Action = "read"
+	// ActionCreate allows creating new records, and updating the records the grantee created.
+	ActionCreate Action = "create"
+	// ActionUpdate allows overwriting any existing record.
+	ActionUpdate Action = "update"
+)
+
+var ErrInvalidAction = fmt.Errorf("action must be %q, %q or %q", ActionRead, ActionCreate, ActionUpdate)
+
+// ParseAction parses an action, defaulting to ActionRead if it is empty.
+func ParseAction(action string) (Action, error) {
+	switch Action(action) {
+	case "":
+		return ActionRead, nil
+	case ActionRead, ActionCreate, ActionUpdate:
+		return Action(action), nil
+	default:
+		return "", ErrInvalidAction
+	}
+}
+
 type Store interface {
 	HasPermission(
 		requester string,
 		owner string,
 		nsid string,
 		rkey string,
+		action Action,
 	) (bool, error)
+	AddLexiconPermission(
+		grantee string,
+		owner string,
+		nsid string,
+		action Action,
+	) error
+	RemoveLexiconPermission(
+		grantee string,
+		owner string,
+		nsid string,
+		action Action,
+	) error
+	ListPermissionsByLexicon(owner string, action Action) (map[string][]string, error)
 	AddLexiconReadPermission(
 		grantee string,
 		owner string,
@@ -24,11 +65,36 @@ type Store interface {
 		nsid string,
 	) error
 	ListReadPermissionsByLexicon(owner string) (map[string][]string, error)
-	ListReadPermissionsByUser(
+	Policy(owner string, requester string, nsid string, action Action) (*Policy, error)
+	ListSharedWith(grantee string) ([]Share, error)
+	Explain(requester string, owner string, object string, action Action) (*Explanation, error)
+	Check(subject Subject, relation string, object ObjectRef) (bool, error)
+	Expand(relation string, object ObjectRef) (*ExpandTree, error)
+
+	CreateGroup(owner string, name string) error
+	DeleteGroup(owner string, name string) error
+	AddGroupMember(owner string, name string, member string) error
+	RemoveGroupMember(owner string, name string, member string) error
+	ListGroups(owner string) (map[string][]string, error)
+
+	AddGrant(owner string, grant Grant) error
+	RemoveGrant(owner string, grantee string, object string, action Action) error
+	ListGrants(owner string) ([]Grant, error)
+	DeleteExpiredGrants(now time.Time) (int64, error)
+	ListPermissionEvents(owner string, before int64, limit int) ([]PermissionEvent, error)
+	ListShareEvents(grantee string, after int64, limit int) ([]PermissionEvent, error)
+
+	CreateCapability(
 		owner string,
-		requester string,
-		nsid string,
-	) (allow []string, deny []string, err error)
+		collection string,
+		rkey string,
+		expiresAt *time.Time,
+		maxUses *int,
+	) (*Capability, error)
+	GetCapability(id string) (*Capability, error)
+	UseCapability(id string, now time.Time) error
+	ListCapabilities(owner string) ([]Capability, error)
+	RevokeCapability(owner string, id string) error
 }
 
 type sqliteStore struct {
@@ -37,23 +103,24 @@ type sqliteStore struct {
 
 var _ Store = (*sqliteStore)(nil)
 
-// Permission represents a permission entry in the database
-type Permission struct {
-	gorm.Model
-	Grantee string `gorm:"not null;index:idx_permissions_grantee_owner,priority:1;uniqueIndex:idx_grantee_owner_object"`
-	Owner   string `gorm:"not null;index:idx_permissions_owner;index:idx_permissions_grantee_owner,priority:2;uniqueIndex:idx_grantee_owner_object"`
-	Object  string `gorm:"not null;uniqueIndex:idx_grantee_owner_object"`
-	Effect  string `gorm:"not null;check:effect IN ('allow', 'deny')"`
+// activeAt restricts a query to tuples whose window includes now.
+func activeAt(now time.Time) func(*gorm.DB) *gorm.DB {
+	now = now.UTC()
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("(not_before IS NULL OR not_before <= ?) AND (expires_at IS NULL OR expires_at > ?)", now, now)
+	}
 }
 
 // NewSQLiteStore creates a new SQLite-backed permission store.
 // The store manages permissions at different granularities:
-// - Whole NSID prefixes: "com.habitat.*"
+// - Whole NSID prefixes: "com.habitat"
 // - Specific NSIDs: "com.habitat.collection"
-// - Specific records: "com.habitat.collection.recordKey"
+// - Specific records: "com.habitat.collection:recordKey"
+//
+// Permissions are stored as relation tuples (see HabitatSchema). Any pending permissions migrations (see
+// Migrations) are applied before the store is returned.
 func NewSQLiteStore(db *gorm.DB) (*sqliteStore, error) {
-	// AutoMigrate will create the table with all indexes defined in the Permission struct
-	err := db.AutoMigrate(&Permission{})
+	_, err := Migrations.Up(db)
 	if err != nil {
 		return nil, fmt.Errorf("failed to migrate permissions table: %w", err)
 	}
@@ -61,78 +128,111 @@ func NewSQLiteStore(db *gorm.DB) (*sqliteStore, error) {
 	return &sqliteStore{db: db}, nil
 }
 
-// HasPermission checks if a requester has permission to access a specific record.
-// It checks permissions in the following order:
-// 1. Owner always has access
-// 2. Specific record permissions (exact match)
-// 3. NSID-level permissions (prefix match with .*)
-// 4. Wildcard prefix permissions (e.g., "com.habitat.*")
+// HasPermission checks if a requester has permission to perform action on a specific record, or on a whole NSID
+// if rkey is empty. The owner always has permission; anyone else needs a grant, which is decided by checking the
+// action's permission on the object (see Check).
 func (s *sqliteStore) HasPermission(
 	requester string,
 	owner string,
 	nsid string,
 	rkey string,
+	action Action,
 ) (bool, error) {
-	// Owner always has permission
 	if requester == owner {
 		return true, nil
 	}
-
-	// Build the full object path
 	object := nsid
 	if rkey != "" {
-		object = fmt.Sprintf("%s.%s", nsid, rkey)
+		object = RecordObject(nsid, rkey)
 	}
+	return s.Check(UserSubject(requester), string(action), ObjectRef{Type: typeObject, ID: ObjectID(owner, object)})
+}
 
-	// Check for permissions using a single query that matches:
-	// 1. Exact object match: object = "com.habitat.posts.record1"
-	// 2. Prefix matches for parent NSIDs:
-	//    For object = "com.habitat.posts.record1", match stored permissions:
-	//    - "com.habitat.posts" (the NSID itself)
-	//    - "com.habitat"
-	//    - "com"
-	//    This works by checking if the object LIKE the stored permission + ".%"
-	var permission Permission
-	err := s.db.Where("grantee = ? AND owner = ? AND (object = ? OR ? LIKE object || '.%')",
-		requester, owner, object, object).
-		Order("LENGTH(object) DESC, effect DESC").
-		Limit(1).
-		First(&permission).Error
-
-	if err == gorm.ErrRecordNotFound {
-		// No permission found, deny by default
-		return false, nil
-	} else if err != nil {
-		return false, fmt.Errorf("failed to query permission: %w", err)
+// Policy returns what the requester may do with the owner's records in the nsid collection. It includes the grants
+// on the collection, the prefixes above it and the records in it, made directly or to the owner's groups that the
+// requester is a member of. Grants outside their time window are left out. This is used to filter records when
+// querying, and decides the same way as HasPermission.
+func (s *sqliteStore) Policy(owner string, requester string, nsid string, action Action) (*Policy, error) {
+	if requester == owner {
+		return ownerPolicy(), nil
+	}
+	grants, err := s.matchingGrants(s.db.Scopes(activeAt(time.Now())), owner, requester, nsid, action, true)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]Rule, 0, len(grants))
+	for _, grant := range grants {
+		rules = append(rules, Rule{Object: grant.Object, Effect: grant.Effect})
+	}
+	return NewPolicy(rules...), nil
+}
+
+// matchingGrants returns the grants for the action to the requester or their groups that apply to the object: those
+// on the object itself or any prefix of it and, if withChildren is set, those on objects under it. Grants are
+// ordered by precedence, most specific first and then deny before allow.
+func (s *sqliteStore) matchingGrants(
+	db *gorm.DB,
+	owner string,
+	requester string,
+	object string,
+	action Action,
+	withChildren bool,
+) ([]Grant, error) {
+	subjects, err := s.subjectsOf(requester, owner)
+	if err != nil {
+		return nil, err
 	}
 
-	return permission.Effect == "allow", nil
+	// Looking prefixes up by value, rather than matching them with LIKE, lets the query use the object index.
+	id := ObjectID(owner, object)
+	prefixes := []string{id}
+	for parent, ok := objectParent(object); ok; parent, ok = objectParent(parent) {
+		prefixes = append(prefixes, ObjectID(owner, parent))
+	}
+	objects := s.db.Where("object_id IN ?", prefixes)
+	if withChildren && !isRecordObject(object) {
+		objects = objects.
+			Or(`object_id LIKE ? ESCAPE '\'`, escapeLike(id)+".%").
+			Or(`object_id LIKE ? ESCAPE '\'`, escapeLike(id)+recordSeparator+"%")
+	}
+
+	var rows []RelationTuple
+	err = db.Scopes(subjectIn(s.db, subjects)).
+		Where("object_type = ? AND relation IN ?", typeObject, grantRelations(action)).
+		Where(objects).
+		Order("LENGTH(object_id) DESC, relation DESC, subject_type, subject_id").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %w", err)
+	}
+	grants := make([]Grant, 0, len(rows))
+	for _, row := range rows {
+		grants = append(grants, grantFromTuple(owner, row.tuple()))
+	}
+	return grants, nil
 }
 
 // AddLexiconReadPermission grants read permission for an entire lexicon (NSID).
 // The permission is stored as just the NSID (e.g., "com.habitat.posts").
 // The HasPermission method will automatically check for both exact matches and wildcard patterns.
+// Grantees may be DIDs or one of the owner's groups (see GroupGrantee), which must already exist.
 func (s *sqliteStore) AddLexiconReadPermission(
 	grantee string,
 	owner string,
 	nsid string,
 ) error {
-	permission := Permission{
-		Grantee: grantee,
-		Owner:   owner,
-		Object:  nsid,
-		Effect:  "allow",
-	}
-
-	// Use gorm.G for the generic GORM wrapper if available, or direct DB methods
-	result := s.db.Where("grantee = ? AND owner = ? AND object = ?", grantee, owner, nsid).
-		Assign(Permission{Effect: "allow"}).
-		FirstOrCreate(&permission)
+	return s.AddLexiconPermission(grantee, owner, nsid, ActionRead)
+}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to add lexicon permission: %w", result.Error)
-	}
-	return nil
+// AddLexiconPermission grants permission to perform action on an entire lexicon (NSID). See
+// AddLexiconReadPermission.
+func (s *sqliteStore) AddLexiconPermission(
+	grantee string,
+	owner string,
+	nsid string,
+	action Action,
+) error {
+	return s.AddGrant(owner, Grant{Grantee: grantee, Object: nsid, Effect: EffectAllow, Action: action})
 }
 
 // RemoveLexiconReadPermission removes read permission for an entire lexicon.
@@ -141,71 +241,44 @@ func (s *sqliteStore) RemoveLexiconReadPermission(
 	owner string,
 	nsid string,
 ) error {
-	result := s.db.Where("grantee = ? AND owner = ? AND object = ?", grantee, owner, nsid).
-		Delete(&Permission{})
+	return s.RemoveLexiconPermission(grantee, owner, nsid, ActionRead)
+}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to remove lexicon permission: %w", result.Error)
-	}
-	return nil
+// RemoveLexiconPermission removes permission to perform action on an entire lexicon.
+func (s *sqliteStore) RemoveLexiconPermission(
+	grantee string,
+	owner string,
+	nsid string,
+	action Action,
+) error {
+	return s.RemoveGrant(owner, grantee, nsid, action)
 }
 
 // ListReadPermissionsByLexicon returns a map of lexicon NSIDs to lists of grantees
 // who have permission to read that lexicon.
 func (s *sqliteStore) ListReadPermissionsByLexicon(owner string) (map[string][]string, error) {
-	var permissions []Permission
-	err := s.db.Where("owner = ? AND effect = ?", owner, "allow").
-		Find(&permissions).Error
+	return s.ListPermissionsByLexicon(owner, ActionRead)
+}
+
+// ListPermissionsByLexicon returns a map of lexicon NSIDs to lists of grantees
+// who currently have permission to perform action on that lexicon.
+func (s *sqliteStore) ListPermissionsByLexicon(owner string, action Action) (map[string][]string, error) {
+	var rows []RelationTuple
+	err := s.db.Scopes(ownedBy(typeObject, owner), activeAt(time.Now())).
+		Where("relation = ?", grantRelation(action, EffectAllow)).
+		Order("object_id, subject_type, subject_id").
+		Find(&rows).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to query permissions: %w", err)
 	}
 
 	result := make(map[string][]string)
-	for _, perm := range permissions {
+	for _, row := range rows {
 		// The object is stored as the NSID itself (e.g., "com.habitat.posts")
 		// So we can use it directly as the lexicon
-		result[perm.Object] = append(result[perm.Object], perm.Grantee)
+		grant := grantFromTuple(owner, row.tuple())
+		result[grant.Object] = append(result[grant.Object], grant.Grantee)
 	}
 
 	return result, nil
 }
-
-// ListReadPermissionsByUser returns the allow and deny lists for a specific user
-// for a given NSID. This is used to filter records when querying.
-func (s *sqliteStore) ListReadPermissionsByUser(
-	owner string,
-	requester string,
-	nsid string,
-) ([]string, []string, error) {
-	if requester == owner {
-		return []string{fmt.Sprintf("%s.*", nsid)}, []string{}, nil
-	}
-	// Query all permissions for this grantee/owner combination
-	// that could match the given NSID
-	// object = nsid OR object = nsid.*
-	var permissions []Permission
-	err := s.db.Where("grantee = ?", requester).
-		Where("owner = ?", owner).
-		Where(
-			s.db.Where("object = ?", nsid).Or("object LIKE ? || '.%'", nsid),
-		).
-		Find(&permissions).
-		Error
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to query permissions: %w", err)
-	}
-
-	allows := []string{}
-	denies := []string{}
-
-	for _, perm := range permissions {
-		switch perm.Effect {
-		case "allow":
-			allows = append(allows, perm.Object)
-		case "deny":
-			denies = append(denies, perm.Object)
-		}
-	}
-
-	return allows, denies, nil
-}
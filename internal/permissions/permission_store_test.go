@@ -16,12 +16,12 @@ func TestSQLiteStoreBasicPermissions(t *testing.T) {
 	require.NoError(t, err)
 
 	// Test: Owner always has permission
-	hasPermission, err := store.HasPermission("alice", "alice", "com.habitat.posts", "record1")
+	hasPermission, err := store.HasPermission("alice", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission, "owner should always have permission")
 
 	// Test: Non-owner without permission should be denied
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.False(t, hasPermission, "non-owner without permission should be denied")
 
@@ -30,16 +30,16 @@ func TestSQLiteStoreBasicPermissions(t *testing.T) {
 	require.NoError(t, err)
 
 	// Test: Bob should now have permission to all posts
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission, "bob should have permission after grant")
 
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record2")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record2", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission, "bob should have permission to all records in the lexicon")
 
 	// Test: Bob should not have permission to other lexicons
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1", ActionRead)
 	require.NoError(t, err)
 	require.False(t, hasPermission, "bob should not have permission to other lexicons")
 
@@ -47,7 +47,7 @@ func TestSQLiteStoreBasicPermissions(t *testing.T) {
 	err = store.RemoveLexiconReadPermission("bob", "alice", "com.habitat.posts")
 	require.NoError(t, err)
 
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.False(t, hasPermission, "bob should not have permission after removal")
 }
@@ -64,20 +64,20 @@ func TestSQLiteStorePrefixPermissions(t *testing.T) {
 	require.NoError(t, err)
 
 	// Bob should have access to any lexicon under com.habitat
-	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1")
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.follows", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.follows", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
 	// Bob should not have access to other top-level domains
-	hasPermission, err = store.HasPermission("bob", "alice", "org.example.posts", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "org.example.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.False(t, hasPermission)
 }
@@ -121,17 +121,15 @@ func TestSQLiteStoreListByUser(t *testing.T) {
 	require.NoError(t, err)
 
 	// List bob's permissions for com.habitat.posts
-	allows, denies, err := store.ListReadPermissionsByUser("alice", "bob", "com.habitat.posts")
+	policy, err := store.Policy("alice", "bob", "com.habitat.posts", ActionRead)
 	require.NoError(t, err)
-	require.Len(t, allows, 1)
-	require.Contains(t, allows, "com.habitat.posts")
-	require.Len(t, denies, 0)
+	require.True(t, policy.Allows("com.habitat.posts.record1"))
+	require.False(t, policy.Allows("com.habitat.comments.record1"))
 
 	// Charlie has no permissions
-	allows, denies, err = store.ListReadPermissionsByUser("alice", "charlie", "com.habitat.posts")
+	policy, err = store.Policy("alice", "charlie", "com.habitat.posts", ActionRead)
 	require.NoError(t, err)
-	require.Len(t, allows, 0)
-	require.Len(t, denies, 0)
+	require.False(t, policy.AllowsAny())
 }
 
 func TestSQLiteStorePermissionHierarchy(t *testing.T) {
@@ -150,20 +148,20 @@ func TestSQLiteStorePermissionHierarchy(t *testing.T) {
 	require.NoError(t, err)
 
 	// Bob has access via broad permission
-	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1")
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
 	// Charlie only has access to posts
-	hasPermission, err = store.HasPermission("charlie", "alice", "com.habitat.posts", "record1")
+	hasPermission, err = store.HasPermission("charlie", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
-	hasPermission, err = store.HasPermission("charlie", "alice", "com.habitat.likes", "record1")
+	hasPermission, err = store.HasPermission("charlie", "alice", "com.habitat.likes", "record1", ActionRead)
 	require.NoError(t, err)
 	require.False(t, hasPermission)
 }
@@ -180,7 +178,7 @@ func TestSQLiteStoreEmptyRecordKey(t *testing.T) {
 	require.NoError(t, err)
 
 	// Check permission with empty record key (should check NSID-level permission)
-	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "")
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission, "should have permission to NSID when record key is empty")
 }
@@ -201,17 +199,17 @@ func TestSQLiteStoreMultipleOwners(t *testing.T) {
 	require.NoError(t, err)
 
 	// Bob should have access to alice's posts
-	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1")
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
 	// Bob should not have access to alice's likes
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1", ActionRead)
 	require.NoError(t, err)
 	require.False(t, hasPermission)
 
 	// Bob should have access to charlie's likes
-	hasPermission, err = store.HasPermission("bob", "charlie", "com.habitat.likes", "record1")
+	hasPermission, err = store.HasPermission("bob", "charlie", "com.habitat.likes", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
@@ -240,37 +238,94 @@ func TestSQLiteStoreDenyOverridesAllow(t *testing.T) {
 	require.NoError(t, err)
 
 	// Bob should have access to posts
-	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1")
+	hasPermission, err := store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
 	// Bob should have access to likes
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
-	// Now add a deny rule for likes specifically using GORM
-	denyPermission := Permission{
+	// Now add a deny rule for likes specifically
+	err = store.AddGrant("alice", Grant{
 		Grantee: "bob",
-		Owner:   "alice",
 		Object:  "com.habitat.likes",
-		Effect:  "deny",
-	}
-	err = db.Create(&denyPermission).Error
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	})
 	require.NoError(t, err)
 
 	// Bob should still have access to posts
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.posts", "record1", ActionRead)
 	require.NoError(t, err)
 	require.True(t, hasPermission)
 
 	// Bob should now be denied access to likes (deny overrides broader allow)
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "record1", ActionRead)
 	require.NoError(t, err)
 	require.False(t, hasPermission, "deny should override broader allow")
 
 	// Bob should also be denied access to specific like records
-	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "specific-record")
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.likes", "specific-record", ActionRead)
 	require.NoError(t, err)
 	require.False(t, hasPermission, "deny should apply to all records under likes")
 }
+
+func TestSQLiteStoreWritePermissions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	// The owner can do anything.
+	hasPermission, err := store.HasPermission("alice", "alice", "com.habitat.comments", "", ActionUpdate)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+
+	err = store.AddLexiconPermission("bob", "alice", "com.habitat.comments", ActionCreate)
+	require.NoError(t, err)
+
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.comments", "record1", ActionCreate)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+
+	// Actions are granted independently of each other.
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.comments", "record1", ActionUpdate)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.comments", "record1", ActionRead)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+
+	policy, err := store.Policy("alice", "bob", "com.habitat.comments", ActionRead)
+	require.NoError(t, err)
+	require.False(t, policy.AllowsAny())
+
+	// The same object can be granted for several actions.
+	err = store.AddLexiconReadPermission("bob", "alice", "com.habitat.comments")
+	require.NoError(t, err)
+
+	permissions, err := store.ListPermissionsByLexicon("alice", ActionCreate)
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"com.habitat.comments": {"bob"}}, permissions)
+
+	permissions, err = store.ListReadPermissionsByLexicon("alice")
+	require.NoError(t, err)
+	require.Equal(t, map[string][]string{"com.habitat.comments": {"bob"}}, permissions)
+
+	err = store.RemoveLexiconPermission("bob", "alice", "com.habitat.comments", ActionCreate)
+	require.NoError(t, err)
+
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.comments", "record1", ActionCreate)
+	require.NoError(t, err)
+	require.False(t, hasPermission)
+
+	hasPermission, err = store.HasPermission("bob", "alice", "com.habitat.comments", "record1", ActionRead)
+	require.NoError(t, err)
+	require.True(t, hasPermission)
+
+	require.ErrorIs(t, store.AddLexiconPermission("bob", "alice", "com.habitat.comments", "delete"), ErrInvalidAction)
+}
@@ -0,0 +1,123 @@
+package permissions
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+
+	"gorm.io/gorm/clause"
+)
+
+// Rule allows or denies access to an object and everything under it. Records have nothing under them, so rules on
+// records only match the record itself.
+type Rule struct {
+	Object string
+	Effect Effect
+}
+
+// Policy is what a requester may do with an owner's objects, compiled from the grants that apply to them. It
+// answers both single permission checks (Allows) and which records a query may return (Condition), so that
+// getting a record and listing its collection always agree.
+//
+// The most specific rule matching an object decides, with deny winning over allow at equal specificity. Objects
+// that no rule matches are denied.
+type Policy struct {
+	// Set for the owner, who may do anything.
+	all bool
+	// Most specific first, then deny before allow.
+	rules []Rule
+}
+
+// NewPolicy returns a policy made of the given rules.
+func NewPolicy(rules ...Rule) *Policy {
+	rules = slices.Clone(rules)
+	slices.SortStableFunc(rules, func(a Rule, b Rule) int {
+		if c := cmp.Compare(len(b.Object), len(a.Object)); c != 0 {
+			return c
+		}
+		// "deny" sorts before "allow".
+		return cmp.Compare(b.Effect, a.Effect)
+	})
+	return &Policy{rules: rules}
+}
+
+func ownerPolicy() *Policy {
+	return &Policy{all: true}
+}
+
+// covers reports whether a rule on prefix applies to object.
+func covers(prefix string, object string) bool {
+	if object == prefix {
+		return true
+	}
+	if isRecordObject(prefix) {
+		return false
+	}
+	return strings.HasPrefix(object, prefix+".") || strings.HasPrefix(object, prefix+recordSeparator)
+}
+
+// Allows reports whether the policy allows access to the object.
+func (p *Policy) Allows(object string) bool {
+	if p.all {
+		return true
+	}
+	for _, rule := range p.rules {
+		if covers(rule.Object, object) {
+			return rule.Effect == EffectAllow
+		}
+	}
+	return false
+}
+
+// AllowsAny reports whether the policy could allow access to anything.
+func (p *Policy) AllowsAny() bool {
+	return p.all || slices.ContainsFunc(p.rules, func(rule Rule) bool { return rule.Effect == EffectAllow })
+}
+
+// Condition returns a query condition matching the rows whose object, given by the SQL expression objectExpr, the
+// policy allows access to.
+func (p *Policy) Condition(objectExpr string) clause.Expression {
+	if p.all {
+		return clause.Expr{SQL: "1 = 1"}
+	}
+	// An object is allowed if an allow rule covers it, and no deny rule that is at least as specific does.
+	// Rules that cover the same object are prefixes of each other, so only denies under each allow matter.
+	var allowed []clause.Expression
+	for _, allow := range p.rules {
+		if allow.Effect != EffectAllow {
+			continue
+		}
+		conditions := []clause.Expression{coversExpr(objectExpr, allow.Object)}
+		for _, deny := range p.rules {
+			if deny.Effect == EffectDeny && covers(allow.Object, deny.Object) {
+				conditions = append(conditions, clause.Not(coversExpr(objectExpr, deny.Object)))
+			}
+		}
+		allowed = append(allowed, clause.And(conditions...))
+	}
+	if len(allowed) == 0 {
+		return clause.Expr{SQL: "1 = 0"}
+	}
+	return clause.Or(allowed...)
+}
+
+// coversExpr is the SQL form of covers.
+func coversExpr(objectExpr string, prefix string) clause.Expression {
+	if isRecordObject(prefix) {
+		return clause.Expr{SQL: objectExpr + " = ?", Vars: []any{prefix}}
+	}
+	return clause.Expr{
+		SQL: "(" + objectExpr + " = ? OR " + objectExpr + ` LIKE ? ESCAPE '\' OR ` + objectExpr + ` LIKE ? ESCAPE '\')`,
+		Vars: []any{
+			prefix,
+			escapeLike(prefix) + ".%",
+			escapeLike(prefix) + recordSeparator + "%",
+		},
+	}
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
@@ -0,0 +1,103 @@
+package permissions
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// Objects to check policies against, including prefixes that only differ by LIKE wildcards, and records whose
+// keys look like collections under them.
+var policyTestObjects = []string{
+	"com",
+	"com.habitat",
+	"com.habitat.posts",
+	"com.habitat.posts:a",
+	"com.habitat.posts:a.b",
+	"com.habitat.posts.a",
+	"com.habitat.posts.a:b",
+	"com.habitat.posts:b",
+	"com.habitat.posts_x:a",
+	"com.habitat.postsXx:a",
+	"com.habitat.postscards:a",
+	"com.habitat_.posts:a",
+	"com.habitatX.posts:a",
+	"org.habitat.posts:a",
+}
+
+func randomPolicy(r *rand.Rand) *Policy {
+	rules := make([]Rule, r.IntN(5))
+	for i := range rules {
+		rules[i].Object = policyTestObjects[r.IntN(len(policyTestObjects))]
+		rules[i].Effect = EffectAllow
+		if r.IntN(3) == 0 {
+			rules[i].Effect = EffectDeny
+		}
+	}
+	// Occasionally use a wildcard-looking object that must only match literally.
+	if len(rules) > 0 && r.IntN(4) == 0 {
+		rules[0].Object = "com.habitat.posts_x"
+	}
+	return NewPolicy(rules...)
+}
+
+// TestPolicyConditionMatchesAllows checks that evaluating a policy in SQL agrees with evaluating it in Go, for
+// random policies.
+func TestPolicyConditionMatchesAllows(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec("CREATE TABLE objects (object text)").Error)
+	for _, object := range policyTestObjects {
+		require.NoError(t, db.Exec("INSERT INTO objects VALUES (?)", object).Error)
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+	for range 300 {
+		policy := randomPolicy(r)
+		var matched []string
+		err := db.Table("objects").Where(policy.Condition("object")).Order("object").Pluck("object", &matched).Error
+		require.NoError(t, err)
+
+		var allowed []string
+		for _, object := range policyTestObjects {
+			if policy.Allows(object) {
+				allowed = append(allowed, object)
+			}
+		}
+		require.ElementsMatch(t, allowed, matched, "%+v", policy.rules)
+		if len(allowed) > 0 {
+			require.True(t, policy.AllowsAny())
+		}
+	}
+}
+
+func TestPolicyPrecedence(t *testing.T) {
+	policy := NewPolicy(
+		Rule{Object: "com.habitat", Effect: EffectAllow},
+		Rule{Object: "com.habitat.posts", Effect: EffectDeny},
+		Rule{Object: "com.habitat.posts:a", Effect: EffectAllow},
+		Rule{Object: "com.habitat.photos", Effect: EffectAllow},
+		Rule{Object: "com.habitat.photos", Effect: EffectDeny},
+	)
+	for object, allowed := range map[string]bool{
+		"com.habitat.notes:a":  true,
+		"com.habitat.posts:a":  true,
+		"com.habitat.posts:b":  false,
+		"com.habitat.photos:a": false,
+		"com.habitatX:a":       false,
+		"com":                  false,
+		// Rules on records only cover the record itself.
+		"com.habitat.posts:a.b": false,
+		"com.habitat.posts.a":   false,
+		"com.habitat.posts.a:b": false,
+	} {
+		require.Equal(t, allowed, policy.Allows(object), object)
+	}
+
+	require.True(t, ownerPolicy().Allows("anything"))
+	require.False(t, NewPolicy().AllowsAny())
+	require.False(t, NewPolicy(Rule{Object: "com", Effect: EffectDeny}).AllowsAny())
+}
@@ -0,0 +1,384 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidSchema = errors.New("invalid schema")
+
+// Schema describes the types of object that relation tuples may refer to, and how permissions on them are
+// computed. Schemas are written in a small language:
+//
+//	// Comments run to the end of the line.
+//	definition group {
+//		relation member: user | group#member
+//	}
+//
+//	definition doc {
+//		relation parent: folder
+//		relation viewer: user | group#member
+//		relation banned: user
+//		permission view = (viewer + parent->view) - banned
+//	}
+//
+// Relations are stored as tuples, and list the subjects allowed in them: objects of a type, or everyone with a
+// relation to objects of a type ("group#member"). Permissions are computed from relations and other permissions:
+// "+" is union, "&" intersection and "-" exclusion, all left-associative and of equal precedence, and "a->b"
+// checks b on every object that the object is related to by a.
+type Schema struct {
+	Definitions map[string]*Definition
+}
+
+// Definition is a type of object.
+type Definition struct {
+	Name        string
+	Relations   map[string]*Relation
+	Permissions map[string]expr
+}
+
+// Relation is a stored relation, along with the subjects it allows.
+type Relation struct {
+	Name     string
+	Subjects []SubjectType
+}
+
+// SubjectType is a kind of subject allowed in a relation: objects of Type, or the subjects with Relation to them if
+// it is set.
+type SubjectType struct {
+	Type     string
+	Relation string
+}
+
+// expr is a permission expression: a relationRef, an arrow or a setOp.
+type expr interface {
+	String() string
+}
+
+// relationRef refers to a relation or permission on the same object.
+type relationRef struct {
+	name string
+}
+
+func (r *relationRef) String() string {
+	return r.name
+}
+
+// arrow checks relation on each object related to the object by tupleset.
+type arrow struct {
+	tupleset string
+	relation string
+}
+
+func (a *arrow) String() string {
+	return a.tupleset + "->" + a.relation
+}
+
+// setOp combines two expressions with union ("+"), intersection ("&") or exclusion ("-").
+type setOp struct {
+	op    byte
+	left  expr
+	right expr
+}
+
+func (s *setOp) String() string {
+	return fmt.Sprintf("(%s %c %s)", s.left, s.op, s.right)
+}
+
+// ParseSchema parses and validates a schema.
+func ParseSchema(src string) (*Schema, error) {
+	p := &schemaParser{tokens: tokenizeSchema(src)}
+	schema := &Schema{Definitions: map[string]*Definition{}}
+	for !p.done() {
+		def, err := p.definition()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+		}
+		if _, ok := schema.Definitions[def.Name]; ok {
+			return nil, fmt.Errorf("%w: %q is defined twice", ErrInvalidSchema, def.Name)
+		}
+		schema.Definitions[def.Name] = def
+	}
+	if err := schema.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidSchema, err)
+	}
+	return schema, nil
+}
+
+// MustParseSchema is like ParseSchema, but panics if the schema is invalid.
+func MustParseSchema(src string) *Schema {
+	schema, err := ParseSchema(src)
+	if err != nil {
+		panic(err)
+	}
+	return schema
+}
+
+func tokenizeSchema(src string) []string {
+	var tokens []string
+	for _, line := range strings.Split(src, "\n") {
+		if i := strings.Index(line, "//"); i >= 0 {
+			line = line[:i]
+		}
+		for i := 0; i < len(line); {
+			c := rune(line[i])
+			switch {
+			case unicode.IsSpace(c):
+				i++
+			case strings.HasPrefix(line[i:], "->"):
+				tokens = append(tokens, "->")
+				i += 2
+			case isIdentRune(c):
+				j := i
+				for j < len(line) && isIdentRune(rune(line[j])) {
+					j++
+				}
+				tokens = append(tokens, line[i:j])
+				i = j
+			default:
+				tokens = append(tokens, string(c))
+				i++
+			}
+		}
+	}
+	return tokens
+}
+
+func isIdentRune(c rune) bool {
+	return c == '_' || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+type schemaParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *schemaParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *schemaParser) peek() string {
+	if p.done() {
+		return ""
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *schemaParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *schemaParser) expect(token string) error {
+	if got := p.next(); got != token {
+		return fmt.Errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (p *schemaParser) ident() (string, error) {
+	token := p.next()
+	if token == "" || !isIdentRune(rune(token[0])) {
+		return "", fmt.Errorf("expected a name, got %q", token)
+	}
+	return token, nil
+}
+
+func (p *schemaParser) definition() (*Definition, error) {
+	if err := p.expect("definition"); err != nil {
+		return nil, err
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	def := &Definition{Name: name, Relations: map[string]*Relation{}, Permissions: map[string]expr{}}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for p.peek() != "}" {
+		kind := p.next()
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := def.Relations[name]; ok {
+			return nil, fmt.Errorf("%s#%s is defined twice", def.Name, name)
+		} else if _, ok := def.Permissions[name]; ok {
+			return nil, fmt.Errorf("%s#%s is defined twice", def.Name, name)
+		}
+		switch kind {
+		case "relation":
+			relation, err := p.relation(name)
+			if err != nil {
+				return nil, err
+			}
+			def.Relations[name] = relation
+		case "permission":
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			e, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			def.Permissions[name] = e
+		default:
+			return nil, fmt.Errorf("expected \"relation\", \"permission\" or \"}\", got %q", kind)
+		}
+	}
+	return def, p.expect("}")
+}
+
+func (p *schemaParser) relation(name string) (*Relation, error) {
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	relation := &Relation{Name: name}
+	for {
+		typ, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		subject := SubjectType{Type: typ}
+		if p.peek() == "#" {
+			p.next()
+			if subject.Relation, err = p.ident(); err != nil {
+				return nil, err
+			}
+		}
+		relation.Subjects = append(relation.Subjects, subject)
+		if p.peek() != "|" {
+			return relation, nil
+		}
+		p.next()
+	}
+}
+
+func (p *schemaParser) expr() (expr, error) {
+	left, err := p.term()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch op := p.peek(); op {
+		case "+", "&", "-":
+			p.next()
+			right, err := p.term()
+			if err != nil {
+				return nil, err
+			}
+			left = &setOp{op: op[0], left: left, right: right}
+		default:
+			return left, nil
+		}
+	}
+}
+
+func (p *schemaParser) term() (expr, error) {
+	if p.peek() == "(" {
+		p.next()
+		e, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	}
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek() != "->" {
+		return &relationRef{name: name}, nil
+	}
+	p.next()
+	relation, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	return &arrow{tupleset: name, relation: relation}, nil
+}
+
+// hasRelationOrPermission reports whether objects of the type have the named relation or permission.
+func (s *Schema) hasRelationOrPermission(typ string, name string) bool {
+	def, ok := s.Definitions[typ]
+	if !ok {
+		return false
+	}
+	_, isRelation := def.Relations[name]
+	_, isPermission := def.Permissions[name]
+	return isRelation || isPermission
+}
+
+func (s *Schema) validate() error {
+	for _, def := range s.Definitions {
+		for _, relation := range def.Relations {
+			for _, subject := range relation.Subjects {
+				if _, ok := s.Definitions[subject.Type]; !ok {
+					return fmt.Errorf("%s#%s allows undefined type %q", def.Name, relation.Name, subject.Type)
+				}
+				if subject.Relation != "" && !s.hasRelationOrPermission(subject.Type, subject.Relation) {
+					return fmt.Errorf(
+						"%s#%s allows undefined subject %s#%s",
+						def.Name, relation.Name, subject.Type, subject.Relation,
+					)
+				}
+			}
+		}
+		for name, e := range def.Permissions {
+			if err := s.validateExpr(def, e); err != nil {
+				return fmt.Errorf("%s#%s: %w", def.Name, name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateExpr(def *Definition, e expr) error {
+	switch e := e.(type) {
+	case *relationRef:
+		if !s.hasRelationOrPermission(def.Name, e.name) {
+			return fmt.Errorf("%q is not defined", e.name)
+		}
+	case *arrow:
+		tupleset, ok := def.Relations[e.tupleset]
+		if !ok {
+			return fmt.Errorf("%q is not a relation", e.tupleset)
+		}
+		for _, subject := range tupleset.Subjects {
+			if subject.Relation != "" {
+				return fmt.Errorf("%q allows subject sets, which can't be followed", e.tupleset)
+			}
+			if !s.hasRelationOrPermission(subject.Type, e.relation) {
+				return fmt.Errorf("%q is not defined on %q", e.relation, subject.Type)
+			}
+		}
+	case *setOp:
+		if err := s.validateExpr(def, e.left); err != nil {
+			return err
+		}
+		return s.validateExpr(def, e.right)
+	}
+	return nil
+}
+
+// validateTuple checks that the tuple's relation is stored, and allows its subject.
+func (s *Schema) validateTuple(t *Tuple) error {
+	def, ok := s.Definitions[t.Object.Type]
+	if !ok {
+		return fmt.Errorf("%w: undefined type %q", ErrInvalidTuple, t.Object.Type)
+	}
+	relation, ok := def.Relations[t.Relation]
+	if !ok {
+		return fmt.Errorf("%w: %s has no relation %q", ErrInvalidTuple, t.Object.Type, t.Relation)
+	}
+	for _, allowed := range relation.Subjects {
+		if allowed.Type == t.Subject.Object.Type && allowed.Relation == t.Subject.Relation {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s#%s does not allow %s", ErrInvalidTuple, t.Object.Type, t.Relation, t.Subject)
+}
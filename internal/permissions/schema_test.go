@@ -0,0 +1,87 @@
+package permissions
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchema(t *testing.T) {
+	schema, err := ParseSchema(`
+		// Users have no relations.
+		definition user {}
+
+		definition folder {
+			relation viewer: user
+		}
+
+		definition doc {
+			relation parent: folder // Documents are in one folder.
+			relation viewer: user | group#member
+			relation editor: user
+			relation banned: user
+			permission edit = editor - banned
+			permission view = viewer + edit + parent->viewer - banned & viewer
+			permission nested = viewer - (banned & editor)
+		}
+
+		definition group {
+			relation member: user | group#member
+		}
+	`)
+	require.NoError(t, err)
+	require.Len(t, schema.Definitions, 4)
+
+	doc := schema.Definitions["doc"]
+	require.Equal(t, []SubjectType{{Type: "user"}, {Type: "group", Relation: "member"}}, doc.Relations["viewer"].Subjects)
+	require.Equal(t, "(editor - banned)", doc.Permissions["edit"].String())
+	// Operators are left-associative, with equal precedence.
+	require.Equal(t, "((((viewer + edit) + parent->viewer) - banned) & viewer)", doc.Permissions["view"].String())
+	require.Equal(t, "(viewer - (banned & editor))", doc.Permissions["nested"].String())
+
+	require.Contains(t, HabitatSchema.Definitions["object"].Permissions, "read")
+}
+
+func TestParseSchemaErrors(t *testing.T) {
+	for name, src := range map[string]string{
+		"syntax":                  `definition user`,
+		"unknown keyword":         `definition user { attribute name }`,
+		"unclosed parenthesis":    `definition user { relation a: user permission b = (a + a }`,
+		"duplicate definition":    `definition user {} definition user {}`,
+		"duplicate relation":      `definition user { relation a: user relation a: user }`,
+		"undefined subject type":  `definition doc { relation viewer: user }`,
+		"undefined subject set":   `definition user {} definition doc { relation viewer: user#member }`,
+		"undefined in permission": `definition user { permission view = viewer }`,
+		"arrow over permission": `
+			definition user {}
+			definition doc { relation viewer: user permission view = viewer permission p = view->view }`,
+		"arrow to undefined": `
+			definition user {}
+			definition doc { relation parent: doc relation viewer: user permission view = parent->edit }`,
+		"arrow over subject set": `
+			definition user {}
+			definition group { relation member: user }
+			definition doc { relation viewer: group#member permission view = viewer->member }`,
+	} {
+		_, err := ParseSchema(src)
+		require.ErrorIs(t, err, ErrInvalidSchema, name)
+	}
+}
+
+func TestValidateTuple(t *testing.T) {
+	alice := ObjectRef{Type: typeObject, ID: ObjectID("alice", "com.habitat.posts")}
+	family := groupSubject("alice", "family")
+	require.NoError(t, HabitatSchema.validateTuple(&Tuple{Object: alice, Relation: "read_allow", Subject: family}))
+	require.NoError(t, HabitatSchema.validateTuple(&Tuple{Object: alice, Relation: "read_deny", Subject: UserSubject("bob")}))
+
+	for _, tuple := range []Tuple{
+		// Permissions are computed, not stored.
+		{Object: alice, Relation: "read", Subject: UserSubject("bob")},
+		{Object: alice, Relation: "write_allow", Subject: UserSubject("bob")},
+		{Object: ObjectRef{Type: "doc", ID: "alice/doc"}, Relation: "read_allow", Subject: UserSubject("bob")},
+		// Only group members may be granted to, not groups themselves.
+		{Object: alice, Relation: "read_allow", Subject: Subject{Object: family.Object}},
+	} {
+		require.ErrorIs(t, HabitatSchema.validateTuple(&tuple), ErrInvalidTuple, tuple.String())
+	}
+}
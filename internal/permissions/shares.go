@@ -0,0 +1,53 @@
+package permissions
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Share is an object that an owner has allowed a grantee to read.
+type Share struct {
+	Owner  string `json:"owner"`
+	Object string `json:"object"`
+}
+
+// ListSharedWith returns the objects that other owners currently allow grantee to read, directly or through their
+// groups, ordered by owner and object. Objects that are also denied to the grantee are left out, but records
+// denied within a shared collection are not: use HasPermission or Policy to check those.
+func (s *sqliteStore) ListSharedWith(grantee string) ([]Share, error) {
+	subjects, err := s.subjectsOf(grantee, "")
+	if err != nil {
+		return nil, err
+	}
+	var rows []RelationTuple
+	err = s.db.Scopes(activeAt(time.Now()), subjectIn(s.db, subjects)).
+		Where("object_type = ? AND relation IN ?", typeObject, grantRelations(ActionRead)).
+		Where(`object_id NOT LIKE ? ESCAPE '\'`, escapeLike(grantee)+"/%").
+		Order("object_id").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %w", err)
+	}
+
+	denied := map[string]bool{}
+	for _, row := range rows {
+		if row.Relation == grantRelation(ActionRead, EffectDeny) {
+			denied[row.ObjectID] = true
+		}
+	}
+	shares := []Share{}
+	for _, row := range rows {
+		if row.Relation != grantRelation(ActionRead, EffectAllow) || denied[row.ObjectID] {
+			continue
+		}
+		owner, object, _ := strings.Cut(row.ObjectID, "/")
+		share := Share{Owner: owner, Object: object}
+		// The same object may be shared directly and through a group.
+		if n := len(shares); n > 0 && shares[n-1] == share {
+			continue
+		}
+		shares = append(shares, share)
+	}
+	return shares, nil
+}
@@ -0,0 +1,74 @@
+package permissions
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestListSharedWith(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+
+	require.NoError(t, store.AddLexiconReadPermission("bob", "alice", "com.habitat.posts"))
+	require.NoError(t, store.AddGrant("carol", Grant{
+		Grantee: "bob",
+		Object:  RecordObject("com.habitat.photos", "beach"),
+		Effect:  EffectAllow,
+		Action:  ActionRead,
+	}))
+
+	// Shared through a group, as well as directly.
+	require.NoError(t, store.CreateGroup("carol", "friends"))
+	require.NoError(t, store.AddGroupMember("carol", "friends", "bob"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("friends"), "carol", "com.habitat.events"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("friends"), "carol", "com.habitat.posts"))
+	require.NoError(t, store.AddLexiconReadPermission("bob", "carol", "com.habitat.posts"))
+
+	// Neither denied, expired nor write grants count.
+	require.NoError(t, store.AddGrant("dave", Grant{
+		Grantee: "bob",
+		Object:  "com.habitat.posts",
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("friends"), "carol", "com.habitat.notes"))
+	require.NoError(t, store.AddGrant("carol", Grant{
+		Grantee: "bob",
+		Object:  "com.habitat.notes",
+		Effect:  EffectDeny,
+		Action:  ActionRead,
+	}))
+	hourAgo := time.Now().Add(-time.Hour)
+	require.NoError(t, store.AddGrant("erin", Grant{
+		Grantee:   "bob",
+		Object:    "com.habitat.posts",
+		Effect:    EffectAllow,
+		Action:    ActionRead,
+		ExpiresAt: &hourAgo,
+	}))
+	require.NoError(t, store.AddLexiconPermission("frank", "bob", "com.habitat.posts", ActionCreate))
+
+	// Groups that bob isn't a member of don't count either.
+	require.NoError(t, store.CreateGroup("gina", "family"))
+	require.NoError(t, store.AddLexiconReadPermission(GroupGrantee("family"), "gina", "com.habitat.posts"))
+
+	shares, err := store.ListSharedWith("bob")
+	require.NoError(t, err)
+	require.Equal(t, []Share{
+		{Owner: "alice", Object: "com.habitat.posts"},
+		{Owner: "carol", Object: "com.habitat.events"},
+		{Owner: "carol", Object: "com.habitat.photos:beach"},
+		{Owner: "carol", Object: "com.habitat.posts"},
+	}, shares)
+
+	shares, err = store.ListSharedWith("nobody")
+	require.NoError(t, err)
+	require.Empty(t, shares)
+}
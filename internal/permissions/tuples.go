@@ -0,0 +1,294 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Permissions are stored as relation tuples, in the style of Zanzibar: "subject has relation to object". Grants
+// relate users or group members to objects, and memberships relate users or other groups' members to groups. What a
+// tuple means is given by the schema (see HabitatSchema), and checked with Check.
+
+var ErrInvalidTuple = errors.New("invalid relation tuple")
+
+// HabitatSchema describes Habitat's objects. Objects are identified by their owner and permission object, as
+// "owner/object" (see ObjectID), and groups by their owner and name, as "owner/name". Grants are stored in an
+// allow and a deny relation for their action. Each object inherits what its parent allows unless it is denied on
+// the object itself, which is the same as the most specific grant winning, with deny winning ties (see Policy).
+//
+// An object's parent is the object one segment up ("com.habitat" for "com.habitat.posts", and "com.habitat.posts"
+// for the record "com.habitat.posts:key"). Parents are derived from object IDs rather than stored.
+var HabitatSchema = MustParseSchema(`
+	definition user {}
+
+	definition group {
+		relation member: user | group#member
+	}
+
+	definition object {
+		relation parent: object
+
+		relation read_allow: user | group#member
+		relation read_deny: user | group#member
+		permission read = (read_allow + parent->read) - read_deny
+
+		relation create_allow: user | group#member
+		relation create_deny: user | group#member
+		permission create = (create_allow + parent->create) - create_deny
+
+		relation update_allow: user | group#member
+		relation update_deny: user | group#member
+		permission update = (update_allow + parent->update) - update_deny
+	}
+`)
+
+const (
+	typeUser   = "user"
+	typeGroup  = "group"
+	typeObject = "object"
+
+	relationMember = "member"
+	relationParent = "parent"
+)
+
+// ObjectRef identifies an object of a type in the schema. Its string form is "type:id".
+type ObjectRef struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+func (o ObjectRef) String() string {
+	return o.Type + ":" + o.ID
+}
+
+// ParseObjectRef parses the string form of an ObjectRef.
+func ParseObjectRef(s string) (ObjectRef, error) {
+	typ, id, ok := strings.Cut(s, ":")
+	if !ok || typ == "" || id == "" {
+		return ObjectRef{}, fmt.Errorf("%w: object %q must be \"type:id\"", ErrInvalidTuple, s)
+	}
+	return ObjectRef{Type: typ, ID: id}, nil
+}
+
+// Owner returns the DID that owns the object, which prefixes the IDs of objects and groups.
+func (o ObjectRef) Owner() string {
+	owner, _, _ := strings.Cut(o.ID, "/")
+	return owner
+}
+
+// ObjectID returns the ID of one of owner's permission objects.
+func ObjectID(owner string, object string) string {
+	return owner + "/" + object
+}
+
+// Subject is either an object, or everyone with Relation to the object if it is set. Its string form is "type:id"
+// or "type:id#relation".
+type Subject struct {
+	Object   ObjectRef
+	Relation string
+}
+
+func (s Subject) String() string {
+	if s.Relation == "" {
+		return s.Object.String()
+	}
+	return s.Object.String() + "#" + s.Relation
+}
+
+// ParseSubject parses the string form of a Subject.
+func ParseSubject(s string) (Subject, error) {
+	object, relation, _ := strings.Cut(s, "#")
+	ref, err := ParseObjectRef(object)
+	if err != nil {
+		return Subject{}, err
+	}
+	return Subject{Object: ref, Relation: relation}, nil
+}
+
+// UserSubject returns the subject for a DID.
+func UserSubject(did string) Subject {
+	return Subject{Object: ObjectRef{Type: typeUser, ID: did}}
+}
+
+// groupSubject returns the subject for the members of one of owner's groups.
+func groupSubject(owner string, name string) Subject {
+	return Subject{Object: ObjectRef{Type: typeGroup, ID: ObjectID(owner, name)}, Relation: relationMember}
+}
+
+// Tuple relates a subject to an object. It only holds from NotBefore until ExpiresAt, if they are set.
+type Tuple struct {
+	Object    ObjectRef
+	Relation  string
+	Subject   Subject
+	NotBefore *time.Time
+	ExpiresAt *time.Time
+}
+
+func (t Tuple) String() string {
+	return t.Object.String() + "#" + t.Relation + "@" + t.Subject.String()
+}
+
+// RelationTuple is how tuples are stored.
+type RelationTuple struct {
+	ID              uint `gorm:"primarykey"`
+	CreatedAt       time.Time
+	ObjectType      string `gorm:"not null"`
+	ObjectID        string `gorm:"not null"`
+	Relation        string `gorm:"not null"`
+	SubjectType     string `gorm:"not null"`
+	SubjectID       string `gorm:"not null"`
+	SubjectRelation string `gorm:"not null;default:''"`
+	// Times are stored in UTC so that they compare correctly.
+	NotBefore *time.Time
+	ExpiresAt *time.Time
+}
+
+func (r *RelationTuple) tuple() Tuple {
+	return Tuple{
+		Object:   ObjectRef{Type: r.ObjectType, ID: r.ObjectID},
+		Relation: r.Relation,
+		Subject: Subject{
+			Object:   ObjectRef{Type: r.SubjectType, ID: r.SubjectID},
+			Relation: r.SubjectRelation,
+		},
+		NotBefore: utc(r.NotBefore),
+		ExpiresAt: utc(r.ExpiresAt),
+	}
+}
+
+// objectIs restricts a query to tuples on object.
+func objectIs(object ObjectRef) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("object_type = ? AND object_id = ?", object.Type, object.ID)
+	}
+}
+
+// ownedBy restricts a query to tuples on owner's objects of a type, whose IDs start with "owner/". The range lets
+// the query use the object index, unlike LIKE.
+func ownedBy(typ string, owner string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("object_type = ? AND object_id >= ? AND object_id < ?", typ, owner+"/", owner+"0")
+	}
+}
+
+// subjectIn restricts a query to tuples whose subject is one of subjects.
+func subjectIn(db *gorm.DB, subjects []Subject) func(*gorm.DB) *gorm.DB {
+	return func(tx *gorm.DB) *gorm.DB {
+		if len(subjects) == 0 {
+			return tx.Where("1 = 0")
+		}
+		condition := db
+		for i, subject := range subjects {
+			where := condition.Or
+			if i == 0 {
+				where = condition.Where
+			}
+			condition = where(
+				"subject_type = ? AND subject_id = ? AND subject_relation = ?",
+				subject.Object.Type, subject.Object.ID, subject.Relation,
+			)
+		}
+		return tx.Where(condition)
+	}
+}
+
+// writeTuple stores a tuple, replacing the window of an existing one.
+func writeTuple(db *gorm.DB, t Tuple) error {
+	if err := HabitatSchema.validateTuple(&t); err != nil {
+		return err
+	}
+	return db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{
+			{Name: "object_type"}, {Name: "object_id"}, {Name: "relation"},
+			{Name: "subject_type"}, {Name: "subject_id"}, {Name: "subject_relation"},
+		},
+		DoUpdates: clause.Assignments(map[string]any{
+			"not_before": utc(t.NotBefore),
+			"expires_at": utc(t.ExpiresAt),
+		}),
+	}).Create(&RelationTuple{
+		ObjectType:      t.Object.Type,
+		ObjectID:        t.Object.ID,
+		Relation:        t.Relation,
+		SubjectType:     t.Subject.Object.Type,
+		SubjectID:       t.Subject.Object.ID,
+		SubjectRelation: t.Subject.Relation,
+		NotBefore:       utc(t.NotBefore),
+		ExpiresAt:       utc(t.ExpiresAt),
+	}).Error
+}
+
+// deleteTuples deletes the tuples relating subject to object by any of relations.
+func deleteTuples(db *gorm.DB, object ObjectRef, subject Subject, relations ...string) error {
+	return db.Scopes(objectIs(object), subjectIn(db, []Subject{subject})).
+		Where("relation IN ?", relations).
+		Delete(&RelationTuple{}).Error
+}
+
+// readTuples returns the tuples on object with relation that hold at now. Parents of objects aren't stored, but
+// derived from their IDs.
+func (s *sqliteStore) readTuples(object ObjectRef, relation string, now time.Time) ([]Tuple, error) {
+	if object.Type == typeObject && relation == relationParent {
+		owner, id, _ := strings.Cut(object.ID, "/")
+		parentID, ok := objectParent(id)
+		if !ok {
+			return nil, nil
+		}
+		parent := ObjectRef{Type: typeObject, ID: ObjectID(owner, parentID)}
+		return []Tuple{{Object: object, Relation: relation, Subject: Subject{Object: parent}}}, nil
+	}
+
+	var rows []RelationTuple
+	err := s.db.Scopes(objectIs(object), activeAt(now)).
+		Where("relation = ?", relation).
+		Order("subject_type, subject_id, subject_relation").
+		Find(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query relation tuples: %w", err)
+	}
+	tuples := make([]Tuple, 0, len(rows))
+	for _, row := range rows {
+		tuples = append(tuples, row.tuple())
+	}
+	return tuples, nil
+}
+
+// subjectsOf returns the subjects that did is included in: the user itself, and the members of every group that
+// it is a member of, directly or through other groups. If owner is set, only the owner's groups are followed.
+func (s *sqliteStore) subjectsOf(did string, owner string) ([]Subject, error) {
+	return subjectsIncluding(s.db, UserSubject(did), owner)
+}
+
+// subjectsIncluding returns subject, and the members of every group that subject is a member of, directly or
+// through other groups. If owner is set, only the owner's groups are followed.
+func subjectsIncluding(db *gorm.DB, subject Subject, owner string) ([]Subject, error) {
+	subjects := []Subject{subject}
+	seen := map[Subject]bool{subject: true}
+	for frontier := subjects; len(frontier) > 0; {
+		query := db.Scopes(activeAt(time.Now()), subjectIn(db, frontier)).Where("relation = ?", relationMember)
+		if owner != "" {
+			query = query.Scopes(ownedBy(typeGroup, owner))
+		} else {
+			query = query.Where("object_type = ?", typeGroup)
+		}
+		var rows []RelationTuple
+		if err := query.Find(&rows).Error; err != nil {
+			return nil, fmt.Errorf("failed to query group memberships: %w", err)
+		}
+		frontier = nil
+		for _, row := range rows {
+			group := Subject{Object: ObjectRef{Type: row.ObjectType, ID: row.ObjectID}, Relation: relationMember}
+			if !seen[group] {
+				seen[group] = true
+				subjects = append(subjects, group)
+				frontier = append(frontier, group)
+			}
+		}
+	}
+	return subjects, nil
+}
@@ -0,0 +1,232 @@
+package privi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/eagraf/habitat-new/internal/permissions"
+	"github.com/eagraf/habitat-new/internal/utils"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// capabilityIssuer mints and checks capability tokens: JWTs signed with the node's key, identifying one of the
+// capabilities in the permission store. The token only proves that this node handed it out; whether it can still
+// be used is decided by the store, so capabilities can be revoked and their uses counted.
+type capabilityIssuer struct {
+	signer *ServiceAuthSigner
+	store  permissions.Store
+}
+
+// mint creates a capability on one of the owner's collections, or a record in it if rkey is set, and returns a
+// token carrying it.
+func (c *capabilityIssuer) mint(
+	owner string,
+	collection string,
+	rkey string,
+	expiresAt *time.Time,
+	maxUses *int,
+) (string, *permissions.Capability, error) {
+	capability, err := c.store.CreateCapability(owner, collection, rkey, expiresAt, maxUses)
+	if err != nil {
+		return "", nil, err
+	}
+	claims := &jwt.RegisteredClaims{
+		Issuer:   c.signer.did,
+		Subject:  owner,
+		IssuedAt: jwt.NewNumericDate(time.Now()),
+		ID:       capability.ID,
+	}
+	if expiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*expiresAt)
+	}
+	token, err := c.signer.sign(claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, capability, nil
+}
+
+// capability returns the capability carried by a token, without counting a use of it.
+func (c *capabilityIssuer) capability(token string) (*permissions.Capability, error) {
+	claims := &jwt.RegisteredClaims{}
+	if err := c.signer.verify(token, claims); err != nil {
+		return nil, err
+	}
+	capability, err := c.store.GetCapability(claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if capability.Owner != claims.Subject {
+		return nil, fmt.Errorf("capability %s does not belong to %s", capability.ID, claims.Subject)
+	}
+	return capability, nil
+}
+
+// capabilityCoversRecord reports whether the capability can be used to read a record in did's repo.
+func capabilityCoversRecord(capability *permissions.Capability, did string, collection string, rkey string) bool {
+	return capability.Owner == did &&
+		capability.Collection == collection &&
+		(capability.Rkey == "" || capability.Rkey == rkey)
+}
+
+// capabilityPolicy returns the policy to list the collection in did's repo with, or nil if the capability covers
+// none of it. Capabilities on a single record list just that record.
+func capabilityPolicy(capability *permissions.Capability, did string, collection string) *permissions.Policy {
+	if capability.Owner != did || capability.Collection != collection {
+		return nil
+	}
+	object := collection
+	if capability.Rkey != "" {
+		object = permissions.RecordObject(collection, capability.Rkey)
+	}
+	return permissions.NewPolicy(permissions.Rule{Object: object, Effect: permissions.EffectAllow})
+}
+
+// getCapability returns the capability presented with the request, or nil if the request isn't authenticated with
+// one. If an invalid capability is presented, an error response is written.
+func (s *Server) getCapability(w http.ResponseWriter, r *http.Request) (*permissions.Capability, bool) {
+	if r.Header.Get("Habitat-Auth-Method") != authMethodCapability {
+		return nil, true
+	}
+	if s.capabilities == nil {
+		writeAuthRequired(w, fmt.Errorf("capabilities are not enabled"))
+		return nil, false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeAuthRequired(w, fmt.Errorf("missing bearer token"))
+		return nil, false
+	}
+	capability, err := s.capabilities.capability(token)
+	if err != nil {
+		writeAuthRequired(w, fmt.Errorf("invalid capability token: %w", err))
+		return nil, false
+	}
+	return capability, true
+}
+
+// useCapability counts a use of the capability if it covers what is being read. If it can't be used, an error
+// response is written.
+func (s *Server) useCapability(w http.ResponseWriter, capability *permissions.Capability, covered bool) bool {
+	if !covered {
+		utils.LogAndHTTPError(w, ErrUnauthorized, "capability does not cover this request", http.StatusForbidden)
+		return false
+	}
+	if err := s.store.permissions.UseCapability(capability.ID, time.Now()); err != nil {
+		writeAuthRequired(w, fmt.Errorf("using capability: %w", err))
+		return false
+	}
+	return true
+}
+
+type createCapabilityRequest struct {
+	Collection string `json:"collection"`
+	// If set, the capability only covers this record rather than the whole collection.
+	Rkey string `json:"rkey,omitempty"`
+	// Optionally limits how long, or how many times, the capability can be used.
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	MaxUses   *int       `json:"maxUses,omitempty"`
+}
+
+type createCapabilityResponse struct {
+	// Presented as a bearer token with "Habitat-Auth-Method: capability" to getRecord, listRecords or getBlob.
+	Token      string                  `json:"token"`
+	Capability *permissions.Capability `json:"capability"`
+}
+
+// CreateCapability mints a token that lets whoever holds it read a collection or record in the caller's repo.
+func (s *Server) CreateCapability(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	if s.capabilities == nil {
+		utils.LogAndHTTPError(
+			w,
+			errors.New("capabilities are not enabled"),
+			"creating capability",
+			http.StatusNotImplemented,
+		)
+		return
+	}
+	req := &createCapabilityRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "decode json request", http.StatusBadRequest)
+		return
+	}
+	if _, err := collectionObject(req.Collection, req.Rkey); err != nil {
+		utils.LogAndHTTPError(w, err, "parsing capability", http.StatusBadRequest)
+		return
+	}
+
+	token, capability, err := s.capabilities.mint(
+		callerDID.String(),
+		req.Collection,
+		req.Rkey,
+		req.ExpiresAt,
+		req.MaxUses,
+	)
+	if errors.Is(err, permissions.ErrInvalidObject) || errors.Is(err, permissions.ErrInvalidMaxUses) {
+		utils.LogAndHTTPError(w, err, "creating capability", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		utils.LogAndHTTPError(w, err, "creating capability", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(&createCapabilityResponse{Token: token, Capability: capability})
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListCapabilities returns the capabilities the caller has handed out, including expired and used up ones.
+func (s *Server) ListCapabilities(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	capabilities, err := s.store.permissions.ListCapabilities(callerDID.String())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "list capabilities from store", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(capabilities)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+type revokeCapabilityRequest struct {
+	ID string `json:"id"`
+}
+
+// RevokeCapability stops the tokens carrying one of the caller's capabilities from working.
+func (s *Server) RevokeCapability(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	req := &revokeCapabilityRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "decode json request", http.StatusBadRequest)
+		return
+	}
+	err = s.store.permissions.RevokeCapability(callerDID.String(), req.ID)
+	if errors.Is(err, permissions.ErrCapabilityNotFound) {
+		utils.LogAndHTTPError(w, err, "revoking capability", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.LogAndHTTPError(w, err, "revoking capability", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,155 @@
+package privi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/eagraf/habitat-new/api/habitat"
+	"github.com/eagraf/habitat-new/internal/permissions"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newCapabilityTestServer(t *testing.T) *Server {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	perms, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	signer, err := NewServiceAuthSigner("did:web:node.example", key)
+	require.NoError(t, err)
+
+	dir := identity.NewMockDirectory()
+	insertIdentityWithKey(t, &dir, "did:web:alice.example", key)
+	return &Server{
+		store:        newStore(perms, repo),
+		dir:          &dir,
+		repo:         repo,
+		capabilities: &capabilityIssuer{signer: signer, store: perms},
+	}
+}
+
+func getWithCapability(s *Server, handler http.HandlerFunc, path string, token string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("Habitat-Auth-Method", "capability")
+	r.Header.Set("Authorization", "Bearer "+token)
+	handler(w, r)
+	return w
+}
+
+func TestCapabilityGetRecord(t *testing.T) {
+	s := newCapabilityTestServer(t)
+	alice := "did:web:alice.example"
+	coll := "com.habitat.photos"
+	for _, rkey := range []string{"beach", "party"} {
+		require.NoError(t, s.repo.PutRecord(alice, alice, coll, rkey, map[string]any{"rkey": rkey}, nil))
+	}
+
+	maxUses := 1
+	token, _, err := s.capabilities.mint(alice, coll, "beach", nil, &maxUses)
+	require.NoError(t, err)
+
+	// Capabilities only cover their record.
+	w := getWithCapability(s, s.GetRecord, "/xrpc/getRecord?repo="+alice+"&collection="+coll+"&rkey=party", token)
+	require.Equal(t, http.StatusForbidden, w.Code)
+
+	w = getWithCapability(s, s.GetRecord, "/xrpc/getRecord?repo="+alice+"&collection="+coll+"&rkey=beach", token)
+	require.Equal(t, http.StatusOK, w.Code)
+	var output habitat.NetworkHabitatRepoGetRecordOutput
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&output))
+	require.Equal(t, map[string]any{"rkey": "beach"}, output.Value)
+
+	// The capability's only use was spent above.
+	w = getWithCapability(s, s.GetRecord, "/xrpc/getRecord?repo="+alice+"&collection="+coll+"&rkey=beach", token)
+	requireAuthRequired(t, w)
+
+	w = getWithCapability(s, s.GetRecord, "/xrpc/getRecord?repo="+alice+"&collection="+coll+"&rkey=beach", "junk")
+	requireAuthRequired(t, w)
+}
+
+func TestCapabilityListRecordsAndRevoke(t *testing.T) {
+	s := newCapabilityTestServer(t)
+	alice := "did:web:alice.example"
+	coll := "com.habitat.photos"
+	for _, rkey := range []string{"beach", "party"} {
+		require.NoError(t, s.repo.PutRecord(alice, alice, coll, rkey, map[string]any{}, nil))
+	}
+	require.NoError(t, s.repo.PutRecord(alice, alice, "com.habitat.posts", "post", map[string]any{}, nil))
+
+	list := func(collection string, token string) *httptest.ResponseRecorder {
+		return getWithCapability(s, s.ListRecords, "/xrpc/listRecords?repo="+alice+"&collection="+collection, token)
+	}
+	listed := func(w *httptest.ResponseRecorder) []string {
+		require.Equal(t, http.StatusOK, w.Code)
+		var output habitat.NetworkHabitatRepoListRecordsOutput
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&output))
+		uris := []string{}
+		for _, record := range output.Records {
+			uris = append(uris, record.Uri)
+		}
+		return uris
+	}
+
+	collectionToken, capability, err := s.capabilities.mint(alice, coll, "", nil, nil)
+	require.NoError(t, err)
+	require.Len(t, listed(list(coll, collectionToken)), 2)
+	require.Equal(t, http.StatusForbidden, list("com.habitat.posts", collectionToken).Code)
+
+	recordToken, _, err := s.capabilities.mint(alice, coll, "party", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, []string{"habitat://" + alice + "/" + coll + "/party"}, listed(list(coll, recordToken)))
+	// A record capability doesn't cover a collection named after the record.
+	require.Equal(t, http.StatusForbidden, list(coll+".party", recordToken).Code)
+
+	require.NoError(t, s.store.permissions.RevokeCapability(alice, capability.ID))
+	requireAuthRequired(t, list(coll, collectionToken))
+}
+
+func TestCapabilityGetBlob(t *testing.T) {
+	s := newCapabilityTestServer(t)
+	alice := "did:web:alice.example"
+	coll := "com.habitat.photos"
+	blob := func(data string) map[string]any {
+		ref, err := s.repo.UploadBlob(alice, []byte(data), "image/png")
+		require.NoError(t, err)
+		return map[string]any{"$type": "blob", "ref": map[string]any{"$link": ref.Ref.String()}, "mimeType": "image/png"}
+	}
+	cid := func(blob map[string]any) string {
+		return blob["ref"].(map[string]any)["$link"].(string)
+	}
+	beach, party, unused := blob("beach"), blob("party"), blob("unused")
+	require.NoError(t, s.repo.PutRecord(alice, alice, coll, "beach", map[string]any{"image": beach}, nil))
+	require.NoError(t, s.repo.PutRecord(alice, alice, coll, "party", map[string]any{"image": party}, nil))
+
+	maxUses := 1
+	token, _, err := s.capabilities.mint(alice, coll, "beach", nil, &maxUses)
+	require.NoError(t, err)
+	get := func(blob map[string]any) *httptest.ResponseRecorder {
+		return getWithCapability(s, s.GetBlob, "/xrpc/getBlob?did="+alice+"&cid="+cid(blob), token)
+	}
+
+	// Without a capability, callers have to authenticate.
+	w := httptest.NewRecorder()
+	s.GetBlob(w, httptest.NewRequest(http.MethodGet, "/xrpc/getBlob?did="+alice+"&cid="+cid(beach), nil))
+	requireAuthRequired(t, w)
+
+	// Blobs are only covered through the records that reference them, and uncovered requests don't use up the
+	// capability.
+	require.Equal(t, http.StatusForbidden, get(party).Code)
+	require.Equal(t, http.StatusForbidden, get(unused).Code)
+
+	w = get(beach)
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "beach", w.Body.String())
+	requireAuthRequired(t, get(beach))
+}
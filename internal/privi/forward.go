@@ -0,0 +1,192 @@
+package privi
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/eagraf/habitat-new/internal/bffauth"
+	"github.com/eagraf/habitat-new/internal/didweb"
+	"github.com/eagraf/habitat-new/util"
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// Forwarding reads of repos hosted on other Habitat nodes to those nodes.
+
+// habitatServiceID is the id of the service entry in a DID document pointing at the node hosting the repo.
+const habitatServiceID = didweb.HabitatServiceID
+
+// forwardedHeader is set on forwarded requests so that the receiving node serves them itself rather than
+// forwarding them again.
+const forwardedHeader = "Habitat-Forwarded"
+
+const forwardCacheSize = 1000
+
+// Response headers that are passed back to the caller.
+var forwardedResponseHeaders = []string{
+	"Content-Type",
+	"Cache-Control",
+	"Expires",
+	"ETag",
+	"Last-Modified",
+}
+
+// forwarder proxies requests to other Habitat nodes, caching responses as allowed by their headers.
+type forwarder struct {
+	client httpDoer
+	// Issues node-to-node tokens for the caller. May be nil.
+	tokens bffauth.Client
+	// If there is no node-to-node token for the caller, requests are authenticated as this node instead, so
+	// they see what the remote repo's owner has shared with this node. May be nil, in which case such
+	// requests are sent unauthenticated.
+	signer *ServiceAuthSigner
+	// Keyed by caller and url, since responses depend on the caller's permissions.
+	cache *lru.Cache[string, *cachedResponse]
+}
+
+type cachedResponse struct {
+	status  int
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+func newForwarder(client httpDoer, tokens bffauth.Client, signer *ServiceAuthSigner) *forwarder {
+	cache, err := lru.New[string, *cachedResponse](forwardCacheSize)
+	if err != nil {
+		// Only fails for a non-positive size.
+		panic(err)
+	}
+	return &forwarder{client: client, tokens: tokens, signer: signer, cache: cache}
+}
+
+// forward sends r to the same path on the node at endpoint on behalf of callerDID, and writes the node's
+// response to w. An error is returned only if no response was written.
+func (f *forwarder) forward(
+	w http.ResponseWriter,
+	r *http.Request,
+	endpoint string,
+	callerDID syntax.DID,
+) error {
+	u := strings.TrimSuffix(endpoint, "/") + r.URL.Path
+	if r.URL.RawQuery != "" {
+		u += "?" + r.URL.RawQuery
+	}
+	key := callerDID.String() + " " + u
+
+	if cached, ok := f.cache.Get(key); ok {
+		if time.Now().Before(cached.expires) {
+			writeCachedResponse(w, cached)
+			return nil
+		}
+		f.cache.Remove(key)
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(forwardedHeader, "true")
+	client, err := f.authorize(req, endpoint, callerDID)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer util.Close(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	response := &cachedResponse{
+		status: resp.StatusCode,
+		header: http.Header{},
+		body:   body,
+	}
+	for _, h := range forwardedResponseHeaders {
+		if v := resp.Header.Get(h); v != "" {
+			response.header.Set(h, v)
+		}
+	}
+	if resp.StatusCode == http.StatusOK {
+		response.expires = cacheExpiry(resp.Header, time.Now())
+		if time.Now().Before(response.expires) {
+			f.cache.Add(key, response)
+		}
+	}
+	writeCachedResponse(w, response)
+	return nil
+}
+
+// authorize adds credentials to req and returns the client to send it with.
+func (f *forwarder) authorize(req *http.Request, endpoint string, callerDID syntax.DID) (httpDoer, error) {
+	if f.tokens != nil {
+		token, err := f.tokens.GetToken(callerDID.String())
+		if err != nil {
+			return nil, fmt.Errorf("getting node token: %w", err)
+		}
+		if token != "" {
+			req.Header.Set("Habitat-Auth-Method", authMethodBFFAuth)
+			req.Header.Set("Authorization", "Bearer "+token)
+			return f.client, nil
+		}
+	}
+	if f.signer != nil {
+		serviceDID, err := serviceDIDFor(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return f.signer.client(f.client, serviceDID+"#"+habitatServiceID), nil
+	}
+	return f.client, nil
+}
+
+func writeCachedResponse(w http.ResponseWriter, response *cachedResponse) {
+	for h, v := range response.header {
+		w.Header()[h] = v
+	}
+	w.WriteHeader(response.status)
+	_, _ = io.Copy(w, bytes.NewReader(response.body))
+}
+
+// cacheExpiry returns when a response with the given headers, received at now, stops being fresh. The zero
+// time is returned if it may not be cached.
+func cacheExpiry(header http.Header, now time.Time) time.Time {
+	maxAge := -1
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "no-store", "no-cache":
+			return time.Time{}
+		case "max-age":
+			seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+			if err != nil {
+				return time.Time{}
+			}
+			maxAge = seconds
+		}
+	}
+
+	if maxAge >= 0 {
+		// Age is how long the response has already spent in other caches.
+		age, _ := strconv.Atoi(header.Get("Age"))
+		return now.Add(time.Duration(maxAge-age) * time.Second)
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return time.Time{}
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,177 @@
+package privi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/eagraf/habitat-new/internal/bffauth"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeNodeTokens struct{}
+
+func (fakeNodeTokens) GetToken(did string) (string, error) {
+	return "token-for-" + did, nil
+}
+
+// fakeNode is another Habitat node that echoes the caller's credentials.
+func fakeNode(t *testing.T, cacheControl string) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		require.Equal(t, "true", r.Header.Get(forwardedHeader))
+		require.Equal(t, "bffauth", r.Header.Get("Habitat-Auth-Method"))
+		w.Header().Set("Content-Type", "application/json")
+		if cacheControl != "" {
+			w.Header().Set("Cache-Control", cacheControl)
+		}
+		_, _ = fmt.Fprintf(w, `{"path":%q,"auth":%q}`, r.URL.RequestURI(), r.Header.Get("Authorization"))
+	}))
+	t.Cleanup(node.Close)
+	return node, &hits
+}
+
+func TestForwardCachesResponses(t *testing.T) {
+	node, hits := fakeNode(t, "private, max-age=60")
+	f := newForwarder(http.DefaultClient, fakeNodeTokens{}, nil)
+
+	get := func(caller syntax.DID) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/xrpc/com.habitat.getRecord?repo=did:web:them&rkey=a", nil)
+		require.NoError(t, f.forward(w, r, node.URL+"/", caller))
+		return w
+	}
+
+	w := get("did:web:me")
+	require.Equal(t, http.StatusOK, w.Code)
+	require.Equal(t, "private, max-age=60", w.Header().Get("Cache-Control"))
+	require.JSONEq(
+		t,
+		`{"path":"/xrpc/com.habitat.getRecord?repo=did:web:them&rkey=a","auth":"Bearer token-for-did:web:me"}`,
+		w.Body.String(),
+	)
+	require.Equal(t, int32(1), hits.Load())
+
+	// Served from the cache.
+	require.Equal(t, w.Body.String(), get("did:web:me").Body.String())
+	require.Equal(t, int32(1), hits.Load())
+
+	// Other callers may see different records, so they aren't served from the same entry.
+	require.Contains(t, get("did:web:other").Body.String(), "token-for-did:web:other")
+	require.Equal(t, int32(2), hits.Load())
+}
+
+func TestForwardHonorsNoStore(t *testing.T) {
+	node, hits := fakeNode(t, "no-store")
+	f := newForwarder(http.DefaultClient, fakeNodeTokens{}, nil)
+
+	for range 2 {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/xrpc/com.habitat.listRecords?repo=did:web:them", nil)
+		require.NoError(t, f.forward(w, r, node.URL, "did:web:me"))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	require.Equal(t, int32(2), hits.Load())
+}
+
+func TestCacheExpiry(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+
+	require.Equal(t, now.Add(time.Minute), cacheExpiry(header("Cache-Control", "public, max-age=60"), now))
+	require.Equal(t, now.Add(50*time.Second), cacheExpiry(header("Cache-Control", "max-age=60", "Age", "10"), now))
+	require.True(t, cacheExpiry(header("Cache-Control", "max-age=60, no-cache"), now).IsZero())
+	require.True(t, cacheExpiry(header("Cache-Control", "no-store"), now).IsZero())
+	require.True(t, cacheExpiry(header(), now).IsZero())
+
+	expires := now.Add(time.Hour)
+	require.Equal(t, expires, cacheExpiry(header("Expires", expires.Format(http.TimeFormat)), now))
+	// max-age takes precedence over Expires.
+	require.Equal(
+		t,
+		now.Add(time.Minute),
+		cacheExpiry(header("Cache-Control", "max-age=60", "Expires", expires.Format(http.TimeFormat)), now),
+	)
+}
+
+func TestRemoteEndpoint(t *testing.T) {
+	dir := identity.NewMockDirectory()
+	dir.Insert(identity.Identity{
+		DID:      "did:web:local-user",
+		Services: map[string]identity.ServiceEndpoint{"habitat": {Type: "HabitatServer", URL: "https://me.example/"}},
+	})
+	dir.Insert(identity.Identity{
+		DID:      "did:web:remote-user",
+		Services: map[string]identity.ServiceEndpoint{"habitat": {Type: "HabitatServer", URL: "https://them.example"}},
+	})
+	dir.Insert(identity.Identity{DID: "did:web:no-habitat"})
+
+	s := &Server{dir: &dir, serviceEndpoint: "https://me.example"}
+	ctx := context.Background()
+
+	endpoint, err := s.remoteEndpoint(ctx, "did:web:local-user")
+	require.NoError(t, err)
+	require.Empty(t, endpoint)
+
+	endpoint, err = s.remoteEndpoint(ctx, "did:web:remote-user")
+	require.NoError(t, err)
+	require.Equal(t, "https://them.example", endpoint)
+
+	endpoint, err = s.remoteEndpoint(ctx, "did:web:no-habitat")
+	require.NoError(t, err)
+	require.Empty(t, endpoint)
+
+	// Forwarding is disabled without a service endpoint of our own.
+	s.serviceEndpoint = ""
+	endpoint, err = s.remoteEndpoint(ctx, "did:web:remote-user")
+	require.NoError(t, err)
+	require.Empty(t, endpoint)
+}
+
+func TestForwardFallsBackToServiceAuth(t *testing.T) {
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	signer, err := NewServiceAuthSigner("did:web:me.example", key)
+	require.NoError(t, err)
+
+	var auth string
+	node := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Empty(t, r.Header.Get("Habitat-Auth-Method"))
+		auth = r.Header.Get("Authorization")
+	}))
+	t.Cleanup(node.Close)
+
+	// The stub bffauth client has no tokens to give out.
+	f := newForwarder(http.DefaultClient, bffauth.NewClient(), signer)
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/xrpc/com.habitat.getRecord?repo=did:web:them", nil)
+	require.NoError(t, f.forward(w, r, node.URL, "did:web:caller"))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	token, ok := strings.CutPrefix(auth, "Bearer ")
+	require.True(t, ok)
+	claims := &serviceAuthClaims{}
+	_, _, err = jwt.NewParser().ParseUnverified(token, claims)
+	require.NoError(t, err)
+	require.Equal(t, "did:web:me.example", claims.Issuer)
+	require.Equal(t, "com.habitat.getRecord", claims.LexMethod)
+	serviceDID, err := serviceDIDFor(node.URL)
+	require.NoError(t, err)
+	require.Equal(t, jwt.ClaimStrings{serviceDID + "#habitat"}, claims.Audience)
+}
@@ -0,0 +1,225 @@
+package privi
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Importing a collection of public records from the owner's PDS as private records.
+
+// importPageSize is the number of public records fetched from the PDS at a time.
+const importPageSize = 100
+
+// importJobTTL is how long a finished job's status is kept for polling.
+const importJobTTL = time.Hour
+
+// ImportRecordResult is the outcome of importing a single record.
+type ImportRecordResult struct {
+	Rkey string `json:"rkey"`
+	// Error is set if the record could not be imported. The public record is left in place, and no private copy is.
+	Error string `json:"error,omitempty"`
+}
+
+// ImportStatus is the progress of an import job.
+type ImportStatus struct {
+	ID         string `json:"id"`
+	Did        string `json:"did"`
+	Collection string `json:"collection"`
+	Done       bool   `json:"done"`
+	// Error is set if the job stopped early, e.g. because the PDS could not be listed.
+	Error    string               `json:"error,omitempty"`
+	Imported int                  `json:"imported"`
+	Failed   int                  `json:"failed"`
+	Records  []ImportRecordResult `json:"records"`
+
+	finishedAt time.Time
+}
+
+// importCollection copies every record in the collection on the owner's PDS into the repo, along with the
+// blobs they reference, and deletes each public record once its private copy is written. A record only ever
+// has one visibility (see ErrPublicRecordExists), so records are moved rather than copied. report is called
+// with the outcome of each record; failing records do not stop the import.
+func (p *store) importCollection(
+	ctx context.Context,
+	pds pdsClient,
+	did string,
+	collection string,
+	report func(ImportRecordResult),
+) error {
+	// Blobs shared between records only need to be copied once.
+	copied := map[string]bool{}
+	cursor := ""
+	for {
+		page, err := pds.listRecords(ctx, did, collection, importPageSize, cursor, false)
+		if err != nil {
+			return fmt.Errorf("listing public records: %w", err)
+		}
+		for _, record := range page {
+			report(p.importRecord(ctx, pds, did, collection, record, copied))
+		}
+		if len(page) < importPageSize {
+			return nil
+		}
+		cursor = page[len(page)-1].Rkey
+	}
+}
+
+func (p *store) importRecord(
+	ctx context.Context,
+	pds pdsClient,
+	did string,
+	collection string,
+	record publicRecord,
+	copied map[string]bool,
+) ImportRecordResult {
+	result := ImportRecordResult{Rkey: record.Rkey}
+	for _, ref := range findBlobRefs(record.Value) {
+		if copied[ref] {
+			continue
+		}
+		if err := p.copyBlob(ctx, pds, did, ref); err != nil {
+			result.Error = fmt.Sprintf("copying blob %s: %s", ref, err)
+			return result
+		}
+		copied[ref] = true
+	}
+
+	if err := p.repo.PutRecord(did, did, collection, record.Rkey, record.Value, nil); err != nil {
+		result.Error = fmt.Sprintf("writing private record: %s", err)
+		return result
+	}
+
+	if err := pds.deleteRecord(ctx, did, collection, record.Rkey); err != nil {
+		// The public record still exists, so remove the private copy again and leave it for a retry.
+		if rollbackErr := p.repo.DeleteRecord(did, collection, record.Rkey); rollbackErr != nil {
+			log.Error().Err(rollbackErr).Msgf("rolling back private copy of %s/%s/%s", did, collection, record.Rkey)
+		}
+		result.Error = fmt.Sprintf("deleting public record: %s", err)
+		return result
+	}
+	p.publicRecords.Add(publicRecordKey(did, collection, record.Rkey), false)
+	return result
+}
+
+func (p *store) copyBlob(ctx context.Context, pds pdsClient, did string, cid string) error {
+	data, mimeType, err := pds.getBlob(ctx, did, cid)
+	if err != nil {
+		return err
+	}
+	ref, err := p.repo.UploadBlob(did, data, mimeType)
+	if err != nil {
+		return err
+	}
+	if ref.Ref.String() != cid {
+		return fmt.Errorf("blob has cid %s after copying", ref.Ref.String())
+	}
+	return nil
+}
+
+// findBlobRefs returns the CIDs of all blobs referenced from a record value, e.g.
+// {"$type": "blob", "ref": {"$link": "bafk..."}, "mimeType": "image/png", "size": 1234}.
+func findBlobRefs(value any) []string {
+	refs := []string{}
+	switch v := value.(type) {
+	case map[string]any:
+		if v["$type"] == "blob" {
+			if ref, ok := v["ref"].(map[string]any); ok {
+				if link, ok := ref["$link"].(string); ok {
+					return append(refs, link)
+				}
+			}
+		}
+		for _, child := range v {
+			refs = append(refs, findBlobRefs(child)...)
+		}
+	case []any:
+		for _, child := range v {
+			refs = append(refs, findBlobRefs(child)...)
+		}
+	}
+	return refs
+}
+
+// importer runs imports in the background and keeps their status in memory, so it is lost on restart. Finished
+// jobs are dropped after importJobTTL.
+type importer struct {
+	store *store
+
+	mu   sync.Mutex
+	jobs map[string]*ImportStatus
+}
+
+func newImporter(store *store) *importer {
+	return &importer{store: store, jobs: map[string]*ImportStatus{}}
+}
+
+// start begins importing the collection and returns the job's id.
+func (i *importer) start(
+	ctx context.Context,
+	pds pdsClient,
+	did string,
+	collection string,
+) string {
+	status := &ImportStatus{
+		ID:         uuid.NewString(),
+		Did:        did,
+		Collection: collection,
+		Records:    []ImportRecordResult{},
+	}
+	i.mu.Lock()
+	i.evict(time.Now())
+	i.jobs[status.ID] = status
+	i.mu.Unlock()
+
+	go func() {
+		err := i.store.importCollection(ctx, pds, did, collection, func(r ImportRecordResult) {
+			i.mu.Lock()
+			defer i.mu.Unlock()
+			status.Records = append(status.Records, r)
+			if r.Error == "" {
+				status.Imported++
+			} else {
+				status.Failed++
+			}
+		})
+
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		status.Done = true
+		status.finishedAt = time.Now()
+		if err != nil {
+			log.Error().Err(err).Msgf("importing %s for %s", collection, did)
+			status.Error = err.Error()
+		}
+	}()
+	return status.ID
+}
+
+// status returns a snapshot of the job's progress.
+func (i *importer) status(id string) (ImportStatus, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.evict(time.Now())
+	status, ok := i.jobs[id]
+	if !ok {
+		return ImportStatus{}, false
+	}
+	snapshot := *status
+	snapshot.Records = slices.Clone(status.Records)
+	return snapshot, true
+}
+
+// evict drops the jobs that finished more than importJobTTL before now. i.mu must be held.
+func (i *importer) evict(now time.Time) {
+	for id, status := range i.jobs {
+		if status.Done && now.Sub(status.finishedAt) > importJobTTL {
+			delete(i.jobs, id)
+		}
+	}
+}
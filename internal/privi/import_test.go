@@ -0,0 +1,151 @@
+package privi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindBlobRefs(t *testing.T) {
+	blob := func(cid string) map[string]any {
+		return map[string]any{"$type": "blob", "ref": map[string]any{"$link": cid}, "mimeType": "image/png"}
+	}
+	refs := findBlobRefs(map[string]any{
+		"text":   "hello",
+		"avatar": blob("cid-1"),
+		"embed": map[string]any{
+			"images": []any{map[string]any{"image": blob("cid-2")}, map[string]any{"alt": "no image"}},
+		},
+	})
+	require.ElementsMatch(t, []string{"cid-1", "cid-2"}, refs)
+	require.Empty(t, findBlobRefs(map[string]any{"$type": "blob"}))
+}
+
+func TestImportCollection(t *testing.T) {
+	p, _ := testStore(t)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+	coll := "my.fake.collection"
+
+	image := pds.addBlob(t, "my-did", []byte("png bytes"), "image/png")
+	missing := map[string]any{"$type": "blob", "ref": map[string]any{"$link": "bafkreimissing"}}
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, "a", map[string]any{"image": image}, nil))
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, "b", map[string]any{"text": "b"}, nil))
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, "c", map[string]any{"image": missing}, nil))
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", "other.collection", "d", map[string]any{}, nil))
+
+	results := []ImportRecordResult{}
+	err := p.importCollection(ctx, pds.client(), "my-did", coll, func(r ImportRecordResult) {
+		results = append(results, r)
+	})
+	require.NoError(t, err)
+
+	require.Len(t, results, 3)
+	require.Equal(t, ImportRecordResult{Rkey: "a"}, results[0])
+	require.Equal(t, ImportRecordResult{Rkey: "b"}, results[1])
+	require.Equal(t, "c", results[2].Rkey)
+	require.Contains(t, results[2].Error, "copying blob bafkreimissing")
+
+	// Imported records and their blobs are private now, and their public copies are gone.
+	for _, rkey := range []string{"a", "b"} {
+		_, err := p.repo.GetRecord("my-did", coll, rkey)
+		require.NoError(t, err)
+		_, ok := pds.get("my-did", coll, rkey)
+		require.False(t, ok)
+	}
+	mimeType, data, err := p.repo.GetBlob("my-did", image["ref"].(map[string]any)["$link"].(string))
+	require.NoError(t, err)
+	require.Equal(t, "image/png", mimeType)
+	require.Equal(t, []byte("png bytes"), data)
+
+	// The failed record is left public, and other collections are untouched.
+	_, err = p.repo.GetRecord("my-did", coll, "c")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+	_, ok := pds.get("my-did", coll, "c")
+	require.True(t, ok)
+	_, ok = pds.get("my-did", "other.collection", "d")
+	require.True(t, ok)
+
+	// Imported records can now be written privately.
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, map[string]any{}, "a", nil))
+}
+
+func TestImportCollectionPages(t *testing.T) {
+	p, _ := testStore(t)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+	coll := "my.fake.collection"
+
+	total := importPageSize + importPageSize/2
+	for i := range total {
+		rkey := fmt.Sprintf("key-%03d", i)
+		require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, rkey, map[string]any{"i": float64(i)}, nil))
+	}
+
+	imported := 0
+	err := p.importCollection(ctx, pds.client(), "my-did", coll, func(r ImportRecordResult) {
+		require.Empty(t, r.Error)
+		imported++
+	})
+	require.NoError(t, err)
+	require.Equal(t, total, imported)
+}
+
+func TestImportCollectionKeepsOneCopy(t *testing.T) {
+	p, _ := testStore(t)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+	coll := "my.fake.collection"
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, "a", map[string]any{}, nil))
+
+	// If the public record can't be deleted, the private copy is removed again.
+	pds.setFailDeletes(true)
+	results := []ImportRecordResult{}
+	err := p.importCollection(ctx, pds.client(), "my-did", coll, func(r ImportRecordResult) {
+		results = append(results, r)
+	})
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	require.Contains(t, results[0].Error, "deleting public record")
+
+	_, err = p.repo.GetRecord("my-did", coll, "a")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+	_, ok := pds.get("my-did", coll, "a")
+	require.True(t, ok)
+}
+
+func TestImporter(t *testing.T) {
+	p, _ := testStore(t)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+	coll := "my.fake.collection"
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, "a", map[string]any{}, nil))
+
+	i := newImporter(p)
+	id := i.start(ctx, pds.client(), "my-did", coll)
+
+	require.Eventually(t, func() bool {
+		status, ok := i.status(id)
+		return ok && status.Done
+	}, time.Second, 10*time.Millisecond)
+
+	status, _ := i.status(id)
+	require.Equal(t, "my-did", status.Did)
+	require.Equal(t, 1, status.Imported)
+	require.Equal(t, 0, status.Failed)
+	require.Empty(t, status.Error)
+	require.Equal(t, []ImportRecordResult{{Rkey: "a"}}, status.Records)
+
+	_, ok := i.status("unknown")
+	require.False(t, ok)
+
+	// Finished jobs are dropped after a while.
+	i.mu.Lock()
+	i.jobs[id].finishedAt = time.Now().Add(-importJobTTL - time.Minute)
+	i.mu.Unlock()
+	_, ok = i.status(id)
+	require.False(t, ok)
+}
@@ -0,0 +1,155 @@
+package privi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/eagraf/habitat-new/internal/migrations"
+	"gorm.io/gorm"
+)
+
+// SQLiteMigrations are the versioned schema changes for the records and blobs tables in SQLite.
+//
+// Migrations must never be edited once released; add a new one instead. They use explicit SQL rather
+// than gorm's AutoMigrate so that replaying them always produces the same schema, regardless of what
+// the Go structs look like at the time. Version 1 matches the schema that AutoMigrate used to create,
+// so databases that predate this framework are picked up where they are.
+var SQLiteMigrations = migrations.Set{
+	Component: "privi",
+	Migrations: []migrations.Migration{
+		{
+			Version: 1,
+			Name:    "create_records_and_blobs",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"CREATE TABLE IF NOT EXISTS `records` (`did` text,`rkey` text,`rec` text,PRIMARY KEY (`did`,`rkey`))",
+					"CREATE TABLE IF NOT EXISTS `blobs` (`id` integer PRIMARY KEY AUTOINCREMENT,`created_at` datetime,`updated_at` datetime,`deleted_at` datetime,`did` text,`cid` text,`mime_type` text,`blob` blob)",
+					"CREATE INDEX IF NOT EXISTS `idx_blobs_deleted_at` ON `blobs`(`deleted_at`)",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 2,
+			Name:    "split_record_collection",
+			Up:      migrateRecordCollections,
+		},
+		{
+			Version: 3,
+			Name:    "add_record_author",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					"ALTER TABLE `records` ADD COLUMN `author` text",
+					// Until now, only owners could write to their repos.
+					"UPDATE `records` SET `author` = `did`",
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	},
+}
+
+// PostgresMigrations are the versioned schema changes for the records and blobs tables in Postgres.
+// The Postgres backend was introduced after records gained a collection column, so it starts there.
+var PostgresMigrations = migrations.Set{
+	Component: "privi",
+	Migrations: []migrations.Migration{
+		{
+			Version: 1,
+			Name:    "create_records_and_blobs",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					`CREATE TABLE IF NOT EXISTS records (did text, collection text, rkey text, rec jsonb, PRIMARY KEY (did, collection, rkey))`,
+					`CREATE TABLE IF NOT EXISTS blobs (id bigserial PRIMARY KEY, created_at timestamptz, updated_at timestamptz, deleted_at timestamptz, did text, cid text, mime_type text, blob bytea)`,
+					`CREATE INDEX IF NOT EXISTS idx_blobs_deleted_at ON blobs (deleted_at)`,
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			Version: 2,
+			Name:    "add_record_author",
+			Up: func(tx *gorm.DB) error {
+				for _, stmt := range []string{
+					`ALTER TABLE records ADD COLUMN author text`,
+					// Until now, only owners could write to their repos.
+					`UPDATE records SET author = did`,
+				} {
+					if err := tx.Exec(stmt).Error; err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	},
+}
+
+// legacyRecord is the schema of the records table before collection was split out into its own
+// column, when rkey held "collection.rkey".
+type legacyRecord struct {
+	Did  string
+	Rkey string
+	Rec  string
+}
+
+// migrateRecordCollections migrates a records table that encodes the collection into rkey to one with
+// separate did, collection and rkey columns. It is a no-op for databases that were created with the
+// collection column before this migration existed.
+//
+// The legacy encoding is ambiguous for rkeys containing dots, so the collection is taken to be
+// everything before the last dot, which matches how these records were previously read back.
+func migrateRecordCollections(tx *gorm.DB) error {
+	if tx.Migrator().HasColumn("records", "collection") {
+		return nil
+	}
+
+	if err := tx.Migrator().RenameTable("records", "records_legacy"); err != nil {
+		return fmt.Errorf("renaming legacy records table: %w", err)
+	}
+	err := tx.Exec(
+		"CREATE TABLE `records` (`did` text,`collection` text,`rkey` text,`rec` text,PRIMARY KEY (`did`,`collection`,`rkey`))",
+	).Error
+	if err != nil {
+		return fmt.Errorf("creating records table: %w", err)
+	}
+
+	var legacy []legacyRecord
+	if err := tx.Table("records_legacy").Find(&legacy).Error; err != nil {
+		return fmt.Errorf("reading legacy records: %w", err)
+	}
+	for _, l := range legacy {
+		idx := strings.LastIndex(l.Rkey, ".")
+		if idx <= 0 {
+			return fmt.Errorf("legacy record key %q for %s has no collection", l.Rkey, l.Did)
+		}
+		// Insert explicitly rather than through Record, which follows the latest schema rather than this one.
+		err := tx.Exec(
+			"INSERT INTO `records` (`did`, `collection`, `rkey`, `rec`) VALUES (?, ?, ?, ?)",
+			l.Did,
+			l.Rkey[:idx],
+			l.Rkey[idx+1:],
+			l.Rec,
+		).Error
+		if err != nil {
+			return fmt.Errorf("migrating record %q for %s: %w", l.Rkey, l.Did, err)
+		}
+	}
+
+	if err := tx.Migrator().DropTable("records_legacy"); err != nil {
+		return fmt.Errorf("dropping legacy records table: %w", err)
+	}
+	return nil
+}
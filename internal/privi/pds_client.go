@@ -0,0 +1,263 @@
+package privi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/eagraf/habitat-new/util"
+)
+
+// pdsClient reads and writes public records in a repo on its PDS.
+type pdsClient interface {
+	putRecord(
+		ctx context.Context,
+		did string,
+		collection string,
+		rkey string,
+		record map[string]any,
+		validate *bool,
+	) error
+	// getRecord returns ErrRecordNotFound if the record does not exist.
+	getRecord(ctx context.Context, did string, collection string, rkey string) (map[string]any, error)
+	// deleteRecord succeeds if the record does not exist.
+	deleteRecord(ctx context.Context, did string, collection string, rkey string) error
+	// listRecords lists records in ascending rkey order (or descending if reverse is set) after the cursor.
+	listRecords(
+		ctx context.Context,
+		did string,
+		collection string,
+		limit int64,
+		cursor string,
+		reverse bool,
+	) ([]publicRecord, error)
+	// getBlob returns the blob's contents and mimetype.
+	getBlob(ctx context.Context, did string, cid string) ([]byte, string, error)
+}
+
+// publicRecord is a record read from a PDS.
+type publicRecord struct {
+	Rkey  string
+	Cid   string
+	Value map[string]any
+}
+
+// httpDoer is satisfied by both *http.Client and *oauthclient.DpopHttpClient.
+type httpDoer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// xrpcPDSClient calls com.atproto.repo.* endpoints on a PDS. Writes must go through a client that is
+// authorized for the repo, e.g. the DPoP client bound to the user's OAuth session.
+type xrpcPDSClient struct {
+	client httpDoer
+	host   string
+}
+
+var _ pdsClient = (*xrpcPDSClient)(nil)
+
+func newXRPCPDSClient(client httpDoer, host string) *xrpcPDSClient {
+	return &xrpcPDSClient{client: client, host: strings.TrimSuffix(host, "/")}
+}
+
+// xrpcError is the standard error body of an XRPC response.
+type xrpcError struct {
+	Status  int    `json:"-"`
+	Name    string `json:"error"`
+	Message string `json:"message"`
+}
+
+func (e *xrpcError) Error() string {
+	return fmt.Sprintf("pds returned %d: %s: %s", e.Status, e.Name, e.Message)
+}
+
+// checkXRPCResponse returns the response's *xrpcError if it was not successful.
+func checkXRPCResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	xerr := &xrpcError{Status: resp.StatusCode}
+	_ = json.NewDecoder(resp.Body).Decode(xerr)
+	return xerr
+}
+
+func (c *xrpcPDSClient) do(
+	ctx context.Context,
+	method string,
+	nsid string,
+	query url.Values,
+	body any,
+	out any,
+) error {
+	var reqBody io.Reader
+	if body != nil {
+		bs, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(bs)
+	}
+
+	u := fmt.Sprintf("%s/xrpc/%s", c.host, nsid)
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer util.Close(resp.Body)
+
+	if err := checkXRPCResponse(resp); err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (c *xrpcPDSClient) putRecord(
+	ctx context.Context,
+	did string,
+	collection string,
+	rkey string,
+	record map[string]any,
+	validate *bool,
+) error {
+	return c.do(ctx, http.MethodPost, "com.atproto.repo.putRecord", nil, &struct {
+		Repo       string         `json:"repo"`
+		Collection string         `json:"collection"`
+		Rkey       string         `json:"rkey"`
+		Record     map[string]any `json:"record"`
+		Validate   *bool          `json:"validate,omitempty"`
+	}{
+		Repo:       did,
+		Collection: collection,
+		Rkey:       rkey,
+		Record:     record,
+		Validate:   validate,
+	}, nil)
+}
+
+func (c *xrpcPDSClient) getRecord(
+	ctx context.Context,
+	did string,
+	collection string,
+	rkey string,
+) (map[string]any, error) {
+	var out struct {
+		Value map[string]any `json:"value"`
+	}
+	err := c.do(ctx, http.MethodGet, "com.atproto.repo.getRecord", url.Values{
+		"repo":       {did},
+		"collection": {collection},
+		"rkey":       {rkey},
+	}, nil, &out)
+	var xerr *xrpcError
+	if errors.As(err, &xerr) && xerr.Name == "RecordNotFound" {
+		return nil, ErrRecordNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	return out.Value, nil
+}
+
+func (c *xrpcPDSClient) deleteRecord(ctx context.Context, did string, collection string, rkey string) error {
+	return c.do(ctx, http.MethodPost, "com.atproto.repo.deleteRecord", nil, &struct {
+		Repo       string `json:"repo"`
+		Collection string `json:"collection"`
+		Rkey       string `json:"rkey"`
+	}{
+		Repo:       did,
+		Collection: collection,
+		Rkey:       rkey,
+	}, nil)
+}
+
+func (c *xrpcPDSClient) listRecords(
+	ctx context.Context,
+	did string,
+	collection string,
+	limit int64,
+	cursor string,
+	reverse bool,
+) ([]publicRecord, error) {
+	query := url.Values{
+		"repo":       {did},
+		"collection": {collection},
+		"limit":      {strconv.FormatInt(limit, 10)},
+		// The PDS lists in descending order unless reverse is set.
+		"reverse": {strconv.FormatBool(!reverse)},
+	}
+	if cursor != "" {
+		query.Set("cursor", cursor)
+	}
+	var out struct {
+		Records []struct {
+			Uri   string         `json:"uri"`
+			Cid   string         `json:"cid"`
+			Value map[string]any `json:"value"`
+		} `json:"records"`
+	}
+	if err := c.do(ctx, http.MethodGet, "com.atproto.repo.listRecords", query, nil, &out); err != nil {
+		return nil, err
+	}
+
+	records := []publicRecord{}
+	for _, r := range out.Records {
+		// at://<did>/<collection>/<rkey>
+		slash := strings.LastIndex(r.Uri, "/")
+		if slash < 0 {
+			return nil, fmt.Errorf("invalid record uri %q", r.Uri)
+		}
+		records = append(records, publicRecord{
+			Rkey:  r.Uri[slash+1:],
+			Cid:   r.Cid,
+			Value: r.Value,
+		})
+	}
+	return records, nil
+}
+
+func (c *xrpcPDSClient) getBlob(ctx context.Context, did string, cid string) ([]byte, string, error) {
+	query := url.Values{"did": {did}, "cid": {cid}}
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodGet,
+		fmt.Sprintf("%s/xrpc/com.atproto.sync.getBlob?%s", c.host, query.Encode()),
+		nil,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer util.Close(resp.Body)
+
+	if err := checkXRPCResponse(resp); err != nil {
+		return nil, "", err
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return data, resp.Header.Get("Content-Type"), nil
+}
@@ -0,0 +1,226 @@
+package privi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/require"
+)
+
+// fakePDS is a stand-in PDS that serves com.atproto.repo record endpoints from memory.
+type fakePDS struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	records map[string]map[string]any
+	blobs   map[string]fakeBlob
+	// failDeletes makes deleteRecord fail, to test rollbacks.
+	failDeletes bool
+	getRecords  int
+}
+
+func newFakePDS(t *testing.T) *fakePDS {
+	pds := &fakePDS{records: map[string]map[string]any{}, blobs: map[string]fakeBlob{}}
+	pds.Server = httptest.NewServer(http.HandlerFunc(pds.serveHTTP))
+	t.Cleanup(pds.Close)
+	return pds
+}
+
+func (p *fakePDS) client() *xrpcPDSClient {
+	return newXRPCPDSClient(http.DefaultClient, p.URL)
+}
+
+func fakePDSKey(did string, collection string, rkey string) string {
+	return did + "/" + collection + "/" + rkey
+}
+
+func (p *fakePDS) get(did string, collection string, rkey string) (map[string]any, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rec, ok := p.records[fakePDSKey(did, collection, rkey)]
+	return rec, ok
+}
+
+type fakeBlob struct {
+	data     []byte
+	mimeType string
+}
+
+// addBlob stores a blob and returns a blob ref to it, for use in record values.
+func (p *fakePDS) addBlob(t *testing.T, did string, data []byte, mimeType string) map[string]any {
+	c, err := cid.NewPrefixV1(cid.Raw, multihash.SHA2_256).Sum(data)
+	require.NoError(t, err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blobs[did+"/"+c.String()] = fakeBlob{data: data, mimeType: mimeType}
+	return map[string]any{
+		"$type":    "blob",
+		"ref":      map[string]any{"$link": c.String()},
+		"mimeType": mimeType,
+		"size":     float64(len(data)),
+	}
+}
+
+func (p *fakePDS) getRecordCalls() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.getRecords
+}
+
+func (p *fakePDS) setFailDeletes(fail bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failDeletes = fail
+}
+
+func writeXRPCError(w http.ResponseWriter, status int, name string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(&xrpcError{Name: name, Message: name})
+}
+
+func (p *fakePDS) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var body struct {
+		Repo       string         `json:"repo"`
+		Collection string         `json:"collection"`
+		Rkey       string         `json:"rkey"`
+		Record     map[string]any `json:"record"`
+	}
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeXRPCError(w, http.StatusBadRequest, "InvalidRequest")
+			return
+		}
+	}
+
+	switch r.URL.Path {
+	case "/xrpc/com.atproto.repo.putRecord":
+		p.records[fakePDSKey(body.Repo, body.Collection, body.Rkey)] = body.Record
+		_ = json.NewEncoder(w).Encode(map[string]any{"uri": "at://" + fakePDSKey(body.Repo, body.Collection, body.Rkey)})
+	case "/xrpc/com.atproto.repo.deleteRecord":
+		if p.failDeletes {
+			writeXRPCError(w, http.StatusInternalServerError, "InternalServerError")
+			return
+		}
+		delete(p.records, fakePDSKey(body.Repo, body.Collection, body.Rkey))
+		_ = json.NewEncoder(w).Encode(map[string]any{})
+	case "/xrpc/com.atproto.repo.getRecord":
+		p.getRecords++
+		q := r.URL.Query()
+		key := fakePDSKey(q.Get("repo"), q.Get("collection"), q.Get("rkey"))
+		rec, ok := p.records[key]
+		if !ok {
+			writeXRPCError(w, http.StatusBadRequest, "RecordNotFound")
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"uri": "at://" + key, "value": rec})
+	case "/xrpc/com.atproto.repo.listRecords":
+		q := r.URL.Query()
+		prefix := fakePDSKey(q.Get("repo"), q.Get("collection"), "")
+		rkeys := []string{}
+		for key := range p.records {
+			if rkey, ok := strings.CutPrefix(key, prefix); ok {
+				rkeys = append(rkeys, rkey)
+			}
+		}
+		// Like a real PDS, list in descending order unless reverse is set.
+		ascending := q.Get("reverse") == "true"
+		sort.Strings(rkeys)
+		if !ascending {
+			slices.Reverse(rkeys)
+		}
+		limit, err := strconv.Atoi(q.Get("limit"))
+		if err != nil {
+			limit = 50
+		}
+
+		records := []map[string]any{}
+		for _, rkey := range rkeys {
+			if cursor := q.Get("cursor"); cursor != "" && (ascending && rkey <= cursor || !ascending && rkey >= cursor) {
+				continue
+			}
+			if len(records) == limit {
+				break
+			}
+			records = append(records, map[string]any{
+				"uri":   "at://" + prefix + rkey,
+				"cid":   "cid-" + rkey,
+				"value": p.records[prefix+rkey],
+			})
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"records": records})
+	case "/xrpc/com.atproto.sync.getBlob":
+		q := r.URL.Query()
+		blob, ok := p.blobs[q.Get("did")+"/"+q.Get("cid")]
+		if !ok {
+			writeXRPCError(w, http.StatusBadRequest, "BlobNotFound")
+			return
+		}
+		w.Header().Set("Content-Type", blob.mimeType)
+		_, _ = w.Write(blob.data)
+	default:
+		writeXRPCError(w, http.StatusNotImplemented, "MethodNotImplemented")
+	}
+}
+
+func TestXRPCPDSClient(t *testing.T) {
+	pds := newFakePDS(t)
+	client := pds.client()
+	ctx := context.Background()
+	val := map[string]any{"text": "hello"}
+
+	_, err := client.getRecord(ctx, "my-did", "my.collection", "key")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+
+	require.NoError(t, client.putRecord(ctx, "my-did", "my.collection", "key", val, nil))
+	got, err := client.getRecord(ctx, "my-did", "my.collection", "key")
+	require.NoError(t, err)
+	require.Equal(t, val, got)
+
+	require.NoError(t, client.deleteRecord(ctx, "my-did", "my.collection", "key"))
+	_, err = client.getRecord(ctx, "my-did", "my.collection", "key")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+
+	pds.setFailDeletes(true)
+	var xerr *xrpcError
+	require.ErrorAs(t, client.deleteRecord(ctx, "my-did", "my.collection", "key"), &xerr)
+	require.Equal(t, http.StatusInternalServerError, xerr.Status)
+}
+
+func TestXRPCPDSClientListRecords(t *testing.T) {
+	pds := newFakePDS(t)
+	client := pds.client()
+	ctx := context.Background()
+	for _, rkey := range []string{"b", "a", "c"} {
+		require.NoError(t, client.putRecord(ctx, "did:plc:me", "my.collection", rkey, map[string]any{"k": rkey}, nil))
+	}
+	require.NoError(t, client.putRecord(ctx, "did:plc:me", "other.collection", "d", map[string]any{}, nil))
+
+	list := func(limit int64, cursor string, reverse bool) []string {
+		records, err := client.listRecords(ctx, "did:plc:me", "my.collection", limit, cursor, reverse)
+		require.NoError(t, err)
+		rkeys := []string{}
+		for _, r := range records {
+			require.Equal(t, map[string]any{"k": r.Rkey}, r.Value)
+			rkeys = append(rkeys, r.Rkey)
+		}
+		return rkeys
+	}
+	require.Equal(t, []string{"a", "b", "c"}, list(10, "", false))
+	require.Equal(t, []string{"c", "b", "a"}, list(10, "", true))
+	require.Equal(t, []string{"b"}, list(1, "a", false))
+	require.Equal(t, []string{"b", "a"}, list(10, "c", true))
+}
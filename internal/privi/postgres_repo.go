@@ -0,0 +1,71 @@
+package privi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+)
+
+// bytea values are limited to 1GB.
+const postgresMaxBlobSize = 1 << 30
+
+// NewPostgresRepo returns a Repo backed by Postgres, applying any pending migrations (see PostgresMigrations) first.
+// indexedFields are record field paths (e.g. "createdAt") that are commonly used to filter or sort in
+// listRecords; each gets an expression index.
+func NewPostgresRepo(db *gorm.DB, indexedFields ...string) (Repo, error) {
+	ran, err := PostgresMigrations.Up(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range ran {
+		log.Info().Msgf("applied privi migration %d: %s", m.Version, m.Name)
+	}
+
+	return newGormRepo(db, postgresMaxBlobSize, &postgresDialect{}, indexedFields)
+}
+
+// postgresDialect evaluates record fields with jsonb path operators over the jsonb rec column.
+type postgresDialect struct{}
+
+var _ dialect = (*postgresDialect)(nil)
+
+// JSON nulls are mapped to SQL NULL so that they behave like missing fields, as they do with
+// SQLite's json_extract.
+func (d *postgresDialect) fieldExpr(path string) string {
+	return fmt.Sprintf("NULLIF(rec #> '{%s}', 'null'::jsonb)", strings.ReplaceAll(path, ".", ","))
+}
+
+// Fields are jsonb values, so scalars are converted to jsonb before comparing. jsonb compares numbers
+// numerically and strings lexically, like SQLite does for json_extract results.
+func (d *postgresDialect) valueExpr(value any) string {
+	switch value.(type) {
+	case string:
+		return "to_jsonb(?::text)"
+	case float64:
+		return "to_jsonb(?::numeric)"
+	case bool:
+		return "to_jsonb(?::boolean)"
+	default:
+		return "?"
+	}
+}
+
+func (d *postgresDialect) containsExpr() string {
+	return "strpos(rec::text, ?) > 0"
+}
+
+// indexField creates an expression index on the field, which Postgres uses for any query with the
+// same expression as fieldExpr.
+func (d *postgresDialect) indexField(db *gorm.DB, path string) error {
+	err := db.Exec(fmt.Sprintf(
+		"CREATE INDEX IF NOT EXISTS idx_records_%s ON records (did, (%s))",
+		fieldColumnName(path),
+		d.fieldExpr(path),
+	)).Error
+	if err != nil {
+		return fmt.Errorf("creating index for %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package privi
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// The Postgres tests run against a scratch database given by HABITAT_TEST_POSTGRES_DSN, e.g.
+// "host=localhost user=postgres dbname=habitat_test sslmode=disable". Its tables are dropped.
+const testPostgresDSNEnv = "HABITAT_TEST_POSTGRES_DSN"
+
+func newTestPostgresRepo(t *testing.T, indexedFields ...string) Repo {
+	dsn := os.Getenv(testPostgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", testPostgresDSNEnv)
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		require.NoError(t, sqlDB.Close())
+	})
+
+	require.NoError(t, db.Exec("DROP TABLE IF EXISTS records, blobs, schema_migrations").Error)
+	repo, err := NewPostgresRepo(db, indexedFields...)
+	require.NoError(t, err)
+	return repo
+}
+
+func TestPostgresRepoConformance(t *testing.T) {
+	runRepoConformance(t, newTestPostgresRepo)
+}
+
+func TestPostgresDialectFieldExpr(t *testing.T) {
+	d := &postgresDialect{}
+	require.Equal(t, "NULLIF(rec #> '{subject,uri}', 'null'::jsonb)", d.fieldExpr("subject.uri"))
+	require.Equal(t, "to_jsonb(?::numeric)", d.valueExpr(float64(1)))
+	require.Equal(t, "to_jsonb(?::text)", d.valueExpr("a"))
+}
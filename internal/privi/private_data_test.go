@@ -1,10 +1,15 @@
 package privi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"math/rand/v2"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/eagraf/habitat-new/api/habitat"
 	"github.com/eagraf/habitat-new/internal/permissions"
 	"github.com/stretchr/testify/require"
@@ -27,12 +32,13 @@ func TestControllerPrivateDataPutGet(t *testing.T) {
 	repo, err := NewSQLiteRepo(db)
 	require.NoError(t, err)
 	p := newStore(dummy, repo)
+	pds := newFakePDS(t)
 
 	// putRecord
 	coll := "my.fake.collection"
 	rkey := "my-rkey"
 	validate := true
-	err = p.putRecord("my-did", coll, val, rkey, &validate)
+	err = p.putRecord(context.Background(), pds.client(), "my-did", "my-did", coll, val, rkey, &validate)
 	require.NoError(t, err)
 
 	got, err := p.getRecord(coll, rkey, "my-did", "another-did")
@@ -49,7 +55,7 @@ func TestControllerPrivateDataPutGet(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, val, unmarshalled)
 
-	err = p.putRecord("my-did", coll, val, rkey, &validate)
+	err = p.putRecord(context.Background(), pds.client(), "my-did", "my-did", coll, val, rkey, &validate)
 	require.NoError(t, err)
 }
 
@@ -64,12 +70,13 @@ func TestListOwnRecords(t *testing.T) {
 	repo, err := NewSQLiteRepo(db)
 	require.NoError(t, err)
 	p := newStore(dummy, repo)
+	pds := newFakePDS(t)
 
 	// putRecord
 	coll := "my.fake.collection"
 	rkey := "my-rkey"
 	validate := true
-	err = p.putRecord("my-did", coll, val, rkey, &validate)
+	err = p.putRecord(context.Background(), pds.client(), "my-did", "my-did", coll, val, rkey, &validate)
 	require.NoError(t, err)
 
 	records, err := p.listRecords(
@@ -91,12 +98,13 @@ func TestListRecords(t *testing.T) {
 	repo, err := NewSQLiteRepo(db)
 	require.NoError(t, err)
 	p := newStore(dummy, repo)
+	pds := newFakePDS(t)
 
 	// putRecord
 	coll := "my.fake.collection"
 	rkey := "my-rkey"
 	validate := true
-	err = p.putRecord("my-did", coll, val, rkey, &validate)
+	err = p.putRecord(context.Background(), pds.client(), "my-did", "my-did", coll, val, rkey, &validate)
 	require.NoError(t, err)
 
 	records, err := p.listRecords(
@@ -118,3 +126,370 @@ func TestListRecords(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, records, 1)
 }
+
+func TestPutPublicRecord(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	dummy, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	p := newStore(dummy, repo)
+	pds := newFakePDS(t)
+
+	coll := "my.fake.collection"
+	require.NoError(t, p.putRecord(context.Background(), pds.client(), "my-did", "my-did", coll, map[string]any{"v": "private"}, "my-rkey", nil))
+
+	// Writing the record publicly replaces the private copy.
+	val := map[string]any{"v": "public"}
+	require.NoError(t, p.putPublicRecord(context.Background(), pds.client(), "my-did", coll, val, "my-rkey", nil))
+
+	got, ok := pds.get("my-did", coll, "my-rkey")
+	require.True(t, ok)
+	require.Equal(t, val, got)
+	_, err = repo.GetRecord("my-did", coll, "my-rkey")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+}
+
+func TestSetRecordVisibility(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	dummy, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	p := newStore(dummy, repo)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+
+	coll := "my.fake.collection"
+	val := map[string]any{"someKey": "someVal"}
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, val, "my-rkey", nil))
+
+	// Promote
+	require.NoError(t, p.setRecordVisibility(ctx, pds.client(), "my-did", coll, "my-rkey", VisibilityPublic))
+	got, ok := pds.get("my-did", coll, "my-rkey")
+	require.True(t, ok)
+	require.Equal(t, val, got)
+	_, err = repo.GetRecord("my-did", coll, "my-rkey")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+
+	// Demote
+	require.NoError(t, p.setRecordVisibility(ctx, pds.client(), "my-did", coll, "my-rkey", VisibilityPrivate))
+	_, ok = pds.get("my-did", coll, "my-rkey")
+	require.False(t, ok)
+	private, err := repo.GetRecord("my-did", coll, "my-rkey")
+	require.NoError(t, err)
+	var unmarshalled map[string]any
+	require.NoError(t, json.Unmarshal([]byte(private.Rec), &unmarshalled))
+	require.Equal(t, val, unmarshalled)
+
+	// Demoting again fails, since there is no public copy
+	err = p.setRecordVisibility(ctx, pds.client(), "my-did", coll, "my-rkey", VisibilityPrivate)
+	require.ErrorIs(t, err, ErrRecordNotFound)
+
+	err = p.setRecordVisibility(ctx, pds.client(), "my-did", coll, "my-rkey", "friends")
+	require.ErrorIs(t, err, ErrInvalidVisibility)
+}
+
+func TestSetRecordVisibilityRollsBack(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	dummy, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	p := newStore(dummy, repo)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+
+	coll := "my.fake.collection"
+	val := map[string]any{"someKey": "someVal"}
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, "my-rkey", val, nil))
+
+	// The public copy can't be deleted, so the private copy is removed again.
+	pds.setFailDeletes(true)
+	err = p.setRecordVisibility(ctx, pds.client(), "my-did", coll, "my-rkey", VisibilityPrivate)
+	require.Error(t, err)
+
+	_, ok := pds.get("my-did", coll, "my-rkey")
+	require.True(t, ok)
+	_, err = repo.GetRecord("my-did", coll, "my-rkey")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+}
+
+func TestPutRecordPublicRecordExists(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	dummy, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	p := newStore(dummy, repo)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+
+	coll := "my.fake.collection"
+	val := map[string]any{"someKey": "someVal"}
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, "public-rkey", val, nil))
+
+	// Colliding with a public record is rejected
+	err = p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, val, "public-rkey", nil)
+	require.ErrorIs(t, err, ErrPublicRecordExists)
+	_, err = repo.GetRecord("my-did", coll, "public-rkey")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+
+	// Other keys, and the same key in other repos, do not collide
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, val, "private-rkey", nil))
+	require.NoError(t, p.putRecord(ctx, pds.client(), "other-did", "other-did", coll, val, "public-rkey", nil))
+
+	// Lookups are cached, so the PDS is only asked once per key
+	lookups := pds.getRecordCalls()
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, val, "private-rkey", nil))
+	require.ErrorIs(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, val, "public-rkey", nil), ErrPublicRecordExists)
+	require.Equal(t, lookups, pds.getRecordCalls())
+
+	// Once the record is made private through privi, it can be overwritten privately
+	require.NoError(t, p.setRecordVisibility(ctx, pds.client(), "my-did", coll, "public-rkey", VisibilityPrivate))
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, val, "public-rkey", nil))
+}
+
+func TestPutRecordWritePermissions(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	perms, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	p := newStore(perms, repo)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+
+	coll := "my.fake.comments"
+	val := map[string]any{"text": "hi"}
+	require.ErrorIs(t, p.putRecord(ctx, pds.client(), "my-did", "commenter", coll, val, "a", nil), ErrUnauthorized)
+
+	// Commenters can create comments and edit their own.
+	require.NoError(t, perms.AddLexiconPermission("commenter", "my-did", coll, permissions.ActionCreate))
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "commenter", coll, val, "a", nil))
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "commenter", coll, val, "a", nil))
+	got, err := repo.GetRecord("my-did", coll, "a")
+	require.NoError(t, err)
+	require.Equal(t, "commenter", got.Author)
+
+	// But not the owner's, or anyone else's.
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, val, "b", nil))
+	require.ErrorIs(t, p.putRecord(ctx, pds.client(), "my-did", "commenter", coll, val, "b", nil), ErrUnauthorized)
+
+	require.NoError(t, perms.AddLexiconPermission("moderator", "my-did", coll, permissions.ActionUpdate))
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "moderator", coll, val, "a", nil))
+	require.ErrorIs(t, p.putRecord(ctx, pds.client(), "my-did", "moderator", coll, val, "c", nil), ErrUnauthorized)
+	got, err = repo.GetRecord("my-did", coll, "a")
+	require.NoError(t, err)
+	require.Equal(t, "commenter", got.Author)
+
+	// Write permissions don't grant read access.
+	_, err = p.getRecord(coll, "a", "my-did", "commenter")
+	require.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestRecordGrants(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	perms, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	p := newStore(perms, repo)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+
+	coll := "my.fake.photos"
+	for _, rkey := range []string{"beach", "beach.2025", "party"} {
+		require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, map[string]any{}, rkey, nil))
+	}
+	// A collection named like the record.
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll+".beach", map[string]any{}, "a", nil))
+	require.NoError(t, perms.AddGrant("my-did", permissions.Grant{
+		Grantee: "your-did",
+		Object:  permissions.RecordObject(coll, "beach"),
+		Effect:  permissions.EffectAllow,
+		Action:  permissions.ActionRead,
+	}))
+
+	_, err = p.getRecord(coll, "beach", "my-did", "your-did")
+	require.NoError(t, err)
+	// Record grants only cover the record itself.
+	for _, other := range [][2]string{{coll, "party"}, {coll, "beach.2025"}, {coll + ".beach", "a"}} {
+		_, err = p.getRecord(other[0], other[1], "my-did", "your-did")
+		require.ErrorIs(t, err, ErrUnauthorized, other)
+	}
+
+	records, err := p.listRecords(
+		&habitat.NetworkHabitatRepoListRecordsParams{Collection: coll, Repo: "my-did"},
+		"your-did",
+	)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "beach", records[0].Rkey)
+	records, err = p.listRecords(
+		&habitat.NetworkHabitatRepoListRecordsParams{Collection: coll + ".beach", Repo: "my-did"},
+		"your-did",
+	)
+	require.NoError(t, err)
+	require.Empty(t, records)
+}
+
+// TestGetAndListRecordsAgree checks that, for random grants, a record can be read with getRecord exactly when
+// listRecords returns it, and exactly when the grants allow it: the most specific active grant covering it decides,
+// with deny winning ties, and grants on records only cover that record.
+func TestGetAndListRecordsAgree(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	perms, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	p := newStore(perms, repo)
+
+	// Record keys that look like collections under their own collection, and collections named like records.
+	records := map[string][]string{
+		"com.example.posts":      {"a", "a.b", "b", "c_d", "cxd"},
+		"com.example.posts.a":    {"b", "c"},
+		"com.example.postscards": {"a"},
+	}
+	var objects []string
+	for collection, rkeys := range records {
+		for _, rkey := range rkeys {
+			objects = append(objects, permissions.RecordObject(collection, rkey))
+		}
+		objects = append(objects, collection, collection+".*")
+	}
+	objects = append(objects, "com", "com.example")
+
+	now := time.Now()
+	hourAgo, inAnHour := now.Add(-time.Hour), now.Add(time.Hour)
+
+	// allowed decides whether grants let the reader read a record, independently of the permissions package.
+	allowed := func(grants []permissions.Grant, inGroup bool, collection string, rkey string) bool {
+		var winner *permissions.Grant
+		for _, grant := range grants {
+			if grant.Grantee != "reader" && !(inGroup && grant.Grantee == permissions.GroupGrantee("group")) {
+				continue
+			}
+			if (grant.NotBefore != nil && grant.NotBefore.After(now)) ||
+				(grant.ExpiresAt != nil && !grant.ExpiresAt.After(now)) {
+				continue
+			}
+			object := strings.TrimSuffix(grant.Object, ".*")
+			if object != permissions.RecordObject(collection, rkey) &&
+				(strings.Contains(object, ":") || (object != collection && !strings.HasPrefix(collection, object+"."))) {
+				continue
+			}
+			if winner == nil || len(object) > len(strings.TrimSuffix(winner.Object, ".*")) ||
+				(len(object) == len(strings.TrimSuffix(winner.Object, ".*")) && grant.Effect == permissions.EffectDeny) {
+				winner = &grant
+			}
+		}
+		return winner != nil && winner.Effect == permissions.EffectAllow
+	}
+
+	r := rand.New(rand.NewPCG(1, 2))
+	for i := range 50 {
+		owner := fmt.Sprintf("owner-%d", i)
+		for collection, rkeys := range records {
+			for _, rkey := range rkeys {
+				require.NoError(t, repo.PutRecord(owner, owner, collection, rkey, map[string]any{}, nil))
+			}
+		}
+		require.NoError(t, perms.CreateGroup(owner, "group"))
+		inGroup := r.IntN(2) == 0
+		if inGroup {
+			require.NoError(t, perms.AddGroupMember(owner, "group", "reader"))
+		}
+		for range r.IntN(6) {
+			grant := permissions.Grant{
+				Grantee: "reader",
+				Object:  objects[r.IntN(len(objects))],
+				Effect:  permissions.EffectAllow,
+				Action:  permissions.ActionRead,
+			}
+			if r.IntN(3) == 0 {
+				grant.Grantee = permissions.GroupGrantee("group")
+			}
+			if r.IntN(3) == 0 {
+				grant.Effect = permissions.EffectDeny
+			}
+			switch r.IntN(4) {
+			case 0:
+				grant.ExpiresAt = &hourAgo
+			case 1:
+				grant.NotBefore = &inAnHour
+			}
+			require.NoError(t, perms.AddGrant(owner, grant))
+		}
+
+		for collection, rkeys := range records {
+			listed, err := p.listRecords(
+				&habitat.NetworkHabitatRepoListRecordsParams{Collection: collection, Repo: owner},
+				"reader",
+			)
+			require.NoError(t, err)
+			listedRkeys := map[string]bool{}
+			for _, record := range listed {
+				listedRkeys[record.Rkey] = true
+			}
+
+			grants, err := perms.ListGrants(owner)
+			require.NoError(t, err)
+			for _, rkey := range rkeys {
+				_, err := p.getRecord(collection, rkey, syntax.DID(owner), "reader")
+				if err != nil {
+					require.ErrorIs(t, err, ErrUnauthorized)
+				}
+				require.Equal(t, err == nil, listedRkeys[rkey], "%s/%s with grants %+v", collection, rkey, grants)
+				require.Equal(
+					t,
+					allowed(grants, inGroup, collection, rkey),
+					err == nil,
+					"%s/%s with grants %+v",
+					collection,
+					rkey,
+					grants,
+				)
+			}
+		}
+	}
+}
+
+func TestGetBlob(t *testing.T) {
+	p, perms := testStore(t)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+	coll := "my.fake.collection"
+
+	ref, err := p.repo.UploadBlob("my-did", []byte("png bytes"), "image/png")
+	require.NoError(t, err)
+	cid := ref.Ref.String()
+	image := map[string]any{"$type": "blob", "ref": map[string]any{"$link": cid}, "mimeType": "image/png"}
+
+	// Owners can always read their blobs.
+	_, data, err := p.getBlob("my-did", cid, "my-did")
+	require.NoError(t, err)
+	require.Equal(t, []byte("png bytes"), data)
+
+	// Others can only read them through a record they can read. Mentioning the CID isn't referencing the blob.
+	_, _, err = p.getBlob("my-did", cid, "another-did")
+	require.ErrorIs(t, err, ErrUnauthorized)
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, map[string]any{"text": cid}, "text", nil))
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, map[string]any{"image": image}, "photo", nil))
+	require.NoError(t, perms.AddLexiconReadPermission("another-did", "my-did", permissions.RecordObject(coll, "text")))
+	_, _, err = p.getBlob("my-did", cid, "another-did")
+	require.ErrorIs(t, err, ErrUnauthorized)
+
+	require.NoError(t, perms.AddLexiconReadPermission("another-did", "my-did", permissions.RecordObject(coll, "photo")))
+	mimeType, data, err := p.getBlob("my-did", cid, "another-did")
+	require.NoError(t, err)
+	require.Equal(t, "image/png", mimeType)
+	require.Equal(t, []byte("png bytes"), data)
+}
@@ -1,11 +1,17 @@
 package privi
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/eagraf/habitat-new/api/habitat"
 	"github.com/eagraf/habitat-new/internal/permissions"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/rs/zerolog/log"
 )
 
 // Privi is an ATProto PDS Wrapper which allows for storing & getting private data.
@@ -22,9 +28,18 @@ type store struct {
 	permissions permissions.Store
 
 	// The backing store for the data. Should implement similar methods to public atproto repos
-	repo *sqliteRepo
+	repo Repo
+
+	// Whether a public record exists on the owner's PDS, keyed by did/collection/rkey. Entries expire since
+	// public records can be written without going through privi.
+	publicRecords *expirable.LRU[string, bool]
 }
 
+const (
+	publicRecordCacheSize = 10000
+	publicRecordCacheTTL  = 5 * time.Minute
+)
+
 var (
 	ErrPublicRecordExists      = fmt.Errorf("a public record exists with the same key")
 	ErrNoPutsOnEncryptedRecord = fmt.Errorf("directly put-ting to this lexicon is not valid")
@@ -33,25 +48,175 @@ var (
 )
 
 // TODO: take in a carfile/sqlite where user's did is persisted
-func newStore(perms permissions.Store, repo *sqliteRepo) *store {
+func newStore(perms permissions.Store, repo Repo) *store {
 	return &store{
-		permissions: perms,
-		repo:        repo,
+		permissions:   perms,
+		repo:          repo,
+		publicRecords: expirable.NewLRU[string, bool](publicRecordCacheSize, nil, publicRecordCacheTTL),
 	}
 }
 
 // putRecord puts the given record on the repo connected to this store (currently an in-memory repo that is a KV store)
-// It does not do any encryption, permissions, auth, etc. It is assumed that only the owner of the store can call this and that
-// is gated by some higher up level. This should be re-written in the future to not give any incorrect impression.
+// It does not do any encryption or auth; the author is assumed to be authenticated by some higher up level.
+// Anyone other than the owner needs permission to write: ActionCreate to create a record or update one they
+// created, or ActionUpdate to update anyone's.
+//
+// A record lives in exactly one place, so putRecord returns ErrPublicRecordExists if the owner's PDS already has a
+// public record with the same key.
 func (p *store) putRecord(
+	ctx context.Context,
+	pds pdsClient,
+	did string,
+	author string,
+	collection string,
+	record map[string]any,
+	rkey string,
+	validate *bool,
+) error {
+	if author != did {
+		if err := p.checkWritePermission(did, author, collection, rkey); err != nil {
+			return err
+		}
+	}
+	exists, err := p.hasPublicRecord(ctx, pds, did, collection, rkey)
+	if err != nil {
+		return fmt.Errorf("checking for public record: %w", err)
+	} else if exists {
+		return ErrPublicRecordExists
+	}
+	return p.repo.PutRecord(did, author, collection, rkey, record, validate)
+}
+
+// checkWritePermission returns ErrUnauthorized if author may not put the record into did's repo.
+func (p *store) checkWritePermission(did string, author string, collection string, rkey string) error {
+	action := permissions.ActionCreate
+	existing, err := p.repo.GetRecord(did, collection, rkey)
+	if err == nil && existing.Author != author {
+		action = permissions.ActionUpdate
+	} else if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+
+	allowed, err := p.permissions.HasPermission(author, did, collection, rkey, action)
+	if err != nil {
+		return err
+	} else if !allowed {
+		return ErrUnauthorized
+	}
+	return nil
+}
+
+func publicRecordKey(did string, collection string, rkey string) string {
+	return did + "/" + collection + "/" + rkey
+}
+
+// hasPublicRecord looks the key up on the owner's PDS, using cached results where possible.
+func (p *store) hasPublicRecord(
+	ctx context.Context,
+	pds pdsClient,
+	did string,
+	collection string,
+	rkey string,
+) (bool, error) {
+	key := publicRecordKey(did, collection, rkey)
+	if exists, ok := p.publicRecords.Get(key); ok {
+		return exists, nil
+	}
+
+	_, err := pds.getRecord(ctx, did, collection, rkey)
+	if errors.Is(err, ErrRecordNotFound) {
+		p.publicRecords.Add(key, false)
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	p.publicRecords.Add(key, true)
+	return true, nil
+}
+
+// Record visibilities. Private records are stored in this node's repo; public records are stored in
+// the user's PDS.
+const (
+	VisibilityPrivate = "private"
+	VisibilityPublic  = "public"
+)
+
+var ErrInvalidVisibility = fmt.Errorf("visibility must be %q or %q", VisibilityPrivate, VisibilityPublic)
+
+// putPublicRecord writes the record to the user's PDS. A record lives in exactly one place, so any private
+// copy is removed afterwards.
+func (p *store) putPublicRecord(
+	ctx context.Context,
+	pds pdsClient,
 	did string,
 	collection string,
 	record map[string]any,
 	rkey string,
 	validate *bool,
 ) error {
-	// It is assumed right now that if this endpoint is called, the caller wants to put a private record into privi.
-	return p.repo.putRecord(did, fmt.Sprintf("%s.%s", collection, rkey), record, validate)
+	if err := pds.putRecord(ctx, did, collection, rkey, record, validate); err != nil {
+		return err
+	}
+	p.publicRecords.Add(publicRecordKey(did, collection, rkey), true)
+	err := p.repo.DeleteRecord(did, collection, rkey)
+	if err != nil && !errors.Is(err, ErrRecordNotFound) {
+		return err
+	}
+	return nil
+}
+
+// setRecordVisibility moves an existing record between this node's repo and the user's PDS. If the record
+// cannot be removed from its old location, the new copy is removed again so that only one copy exists.
+func (p *store) setRecordVisibility(
+	ctx context.Context,
+	pds pdsClient,
+	did string,
+	collection string,
+	rkey string,
+	visibility string,
+) error {
+	switch visibility {
+	case VisibilityPublic:
+		private, err := p.repo.GetRecord(did, collection, rkey)
+		if err != nil {
+			return err
+		}
+		var record map[string]any
+		if err := json.Unmarshal([]byte(private.Rec), &record); err != nil {
+			return err
+		}
+		if err := pds.putRecord(ctx, did, collection, rkey, record, nil); err != nil {
+			return err
+		}
+		p.publicRecords.Add(publicRecordKey(did, collection, rkey), true)
+		if err := p.repo.DeleteRecord(did, collection, rkey); err != nil {
+			if rollbackErr := pds.deleteRecord(ctx, did, collection, rkey); rollbackErr != nil {
+				log.Error().Err(rollbackErr).Msgf("rolling back public copy of %s/%s/%s", did, collection, rkey)
+			} else {
+				p.publicRecords.Add(publicRecordKey(did, collection, rkey), false)
+			}
+			return err
+		}
+		return nil
+	case VisibilityPrivate:
+		record, err := pds.getRecord(ctx, did, collection, rkey)
+		if err != nil {
+			return err
+		}
+		if err := p.repo.PutRecord(did, did, collection, rkey, record, nil); err != nil {
+			return err
+		}
+		if err := pds.deleteRecord(ctx, did, collection, rkey); err != nil {
+			if rollbackErr := p.repo.DeleteRecord(did, collection, rkey); rollbackErr != nil {
+				log.Error().Err(rollbackErr).Msgf("rolling back private copy of %s/%s/%s", did, collection, rkey)
+			}
+			return err
+		}
+		p.publicRecords.Add(publicRecordKey(did, collection, rkey), false)
+		return nil
+	default:
+		return ErrInvalidVisibility
+	}
 }
 
 // getRecord checks permissions on callerDID and then passes through to `repo.getRecord`.
@@ -67,6 +232,7 @@ func (p *store) getRecord(
 		targetDID.String(),
 		collection,
 		rkey,
+		permissions.ActionRead,
 	)
 	if err != nil {
 		return nil, err
@@ -76,21 +242,47 @@ func (p *store) getRecord(
 		return nil, ErrUnauthorized
 	}
 
-	return p.repo.getRecord(string(targetDID), fmt.Sprintf("%s.%s", collection, rkey))
+	return p.repo.GetRecord(string(targetDID), collection, rkey)
+}
+
+// getBlob returns a blob in did's repo if callerDID owns it, or can read one of the records that reference it.
+func (p *store) getBlob(did syntax.DID, cid string, callerDID syntax.DID) (string, []byte, error) {
+	if callerDID != did {
+		records, err := p.repo.ListBlobRecords(did.String(), cid)
+		if err != nil {
+			return "", nil, err
+		}
+		authz := false
+		for _, record := range records {
+			authz, err = p.permissions.HasPermission(
+				callerDID.String(),
+				did.String(),
+				record.Collection,
+				record.Rkey,
+				permissions.ActionRead,
+			)
+			if err != nil {
+				return "", nil, err
+			}
+			if authz {
+				break
+			}
+		}
+		if !authz {
+			return "", nil, ErrUnauthorized
+		}
+	}
+	return p.repo.GetBlob(did.String(), cid)
 }
 
 func (p *store) listRecords(
 	params *habitat.NetworkHabitatRepoListRecordsParams,
 	callerDID syntax.DID,
 ) ([]Record, error) {
-	allow, deny, err := p.permissions.ListReadPermissionsByUser(
-		params.Repo,
-		callerDID.String(),
-		params.Collection,
-	)
+	policy, err := p.permissions.Policy(params.Repo, callerDID.String(), params.Collection, permissions.ActionRead)
 	if err != nil {
 		return nil, err
 	}
 
-	return p.repo.listRecords(params, allow, deny)
+	return p.repo.ListRecords(params, policy)
 }
@@ -0,0 +1,135 @@
+package privi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/eagraf/habitat-new/api/habitat"
+)
+
+// Unified reads over a repo's private records in this node and its public records on the owner's PDS.
+
+// defaultListLimit is the listRecords page size when no limit is given. Unified lists must be paged, since
+// the PDS pages its results.
+const defaultListLimit = 50
+
+// visibleRecord is a record along with where it is stored.
+type visibleRecord struct {
+	Record
+	Visibility string
+	// Cid is only known for public records.
+	Cid string
+}
+
+// uri returns the habitat:// URI of a private record or the at:// URI of a public one.
+func (r *visibleRecord) uri() string {
+	scheme := "habitat"
+	if r.Visibility == VisibilityPublic {
+		scheme = "at"
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", scheme, r.Did, r.Collection, r.Rkey)
+}
+
+// getVisibleRecord returns the private record if the caller is allowed to see it, and otherwise falls back to
+// the public record of the same key.
+func (p *store) getVisibleRecord(
+	ctx context.Context,
+	pds pdsClient,
+	collection string,
+	rkey string,
+	targetDID syntax.DID,
+	callerDID syntax.DID,
+) (*visibleRecord, error) {
+	record, privateErr := p.getRecord(collection, rkey, targetDID, callerDID)
+	if privateErr == nil {
+		return &visibleRecord{Record: *record, Visibility: VisibilityPrivate}, nil
+	} else if !errors.Is(privateErr, ErrUnauthorized) && !errors.Is(privateErr, ErrRecordNotFound) {
+		return nil, privateErr
+	}
+
+	value, err := pds.getRecord(ctx, targetDID.String(), collection, rkey)
+	if errors.Is(err, ErrRecordNotFound) {
+		// Report why the private record was unavailable, without revealing whether it exists.
+		return nil, privateErr
+	} else if err != nil {
+		return nil, err
+	}
+	return newPublicRecord(targetDID.String(), collection, publicRecord{Rkey: rkey, Value: value})
+}
+
+func newPublicRecord(did string, collection string, record publicRecord) (*visibleRecord, error) {
+	bytes, err := json.Marshal(record.Value)
+	if err != nil {
+		return nil, err
+	}
+	return &visibleRecord{
+		Record: Record{
+			Did:        did,
+			Collection: collection,
+			Rkey:       record.Rkey,
+			Rec:        string(bytes),
+			Author:     did,
+		},
+		Visibility: VisibilityPublic,
+		Cid:        record.Cid,
+	}, nil
+}
+
+// listVisibleRecords merges the private records that the caller is allowed to see with the collection's
+// public records, ordered by rkey. Filters and sorts are not supported, since they are evaluated by the
+// private repo's database.
+func (p *store) listVisibleRecords(
+	ctx context.Context,
+	pds pdsClient,
+	params *habitat.NetworkHabitatRepoListRecordsParams,
+	callerDID syntax.DID,
+) ([]visibleRecord, error) {
+	if len(params.Filter) > 0 || params.Sort != "" {
+		return nil, fmt.Errorf("%w: filter and sort cannot be combined with includePublic", ErrInvalidFilter)
+	}
+	if params.Limit == 0 {
+		params.Limit = defaultListLimit
+	}
+
+	private, err := p.listRecords(params, callerDID)
+	if err != nil {
+		return nil, err
+	}
+	public, err := pds.listRecords(
+		ctx,
+		params.Repo,
+		params.Collection,
+		params.Limit,
+		params.Cursor,
+		params.Reverse,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("listing public records: %w", err)
+	}
+
+	// Both lists are ordered by rkey, so merge them and keep the first page.
+	before := func(a string, b string) bool {
+		if params.Reverse {
+			return a > b
+		}
+		return a < b
+	}
+	merged := []visibleRecord{}
+	for len(merged) < int(params.Limit) && (len(private) > 0 || len(public) > 0) {
+		if len(public) == 0 || (len(private) > 0 && before(private[0].Rkey, public[0].Rkey)) {
+			merged = append(merged, visibleRecord{Record: private[0], Visibility: VisibilityPrivate})
+			private = private[1:]
+			continue
+		}
+		record, err := newPublicRecord(params.Repo, params.Collection, public[0])
+		if err != nil {
+			return nil, err
+		}
+		merged = append(merged, *record)
+		public = public[1:]
+	}
+	return merged, nil
+}
@@ -0,0 +1,110 @@
+package privi
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/eagraf/habitat-new/api/habitat"
+	"github.com/eagraf/habitat-new/internal/permissions"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func testStore(t *testing.T) (*store, permissions.Store) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	perms, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	return newStore(perms, repo), perms
+}
+
+func TestGetVisibleRecord(t *testing.T) {
+	p, perms := testStore(t)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+	coll := "my.fake.collection"
+
+	require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, map[string]any{"v": "private"}, "private-rkey", nil))
+	require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, "public-rkey", map[string]any{"v": "public"}, nil))
+
+	// Without permission, only the public record is visible.
+	got, err := p.getVisibleRecord(ctx, pds.client(), coll, "public-rkey", "my-did", "another-did")
+	require.NoError(t, err)
+	require.Equal(t, VisibilityPublic, got.Visibility)
+	require.JSONEq(t, `{"v":"public"}`, got.Rec)
+	require.Equal(t, "at://my-did/my.fake.collection/public-rkey", got.uri())
+
+	_, err = p.getVisibleRecord(ctx, pds.client(), coll, "private-rkey", "my-did", "another-did")
+	require.ErrorIs(t, err, ErrUnauthorized)
+
+	require.NoError(t, perms.AddLexiconReadPermission("another-did", "my-did", coll))
+	got, err = p.getVisibleRecord(ctx, pds.client(), coll, "private-rkey", "my-did", "another-did")
+	require.NoError(t, err)
+	require.Equal(t, VisibilityPrivate, got.Visibility)
+	require.JSONEq(t, `{"v":"private"}`, got.Rec)
+	require.Equal(t, "habitat://my-did/my.fake.collection/private-rkey", got.uri())
+
+	_, err = p.getVisibleRecord(ctx, pds.client(), coll, "missing-rkey", "my-did", "another-did")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+}
+
+func TestListVisibleRecords(t *testing.T) {
+	p, perms := testStore(t)
+	pds := newFakePDS(t)
+	ctx := context.Background()
+	coll := "my.fake.collection"
+
+	// Interleave private and public rkeys.
+	for i := range 6 {
+		rkey := fmt.Sprintf("key-%d", i)
+		if i%2 == 0 {
+			require.NoError(t, p.putRecord(ctx, pds.client(), "my-did", "my-did", coll, map[string]any{}, rkey, nil))
+		} else {
+			require.NoError(t, pds.client().putRecord(ctx, "my-did", coll, rkey, map[string]any{}, nil))
+		}
+	}
+	require.NoError(t, perms.AddLexiconReadPermission("another-did", "my-did", permissions.RecordObject(coll, "key-2")))
+
+	list := func(caller syntax.DID, params habitat.NetworkHabitatRepoListRecordsParams) ([]string, []string) {
+		params.Repo = "my-did"
+		params.Collection = coll
+		records, err := p.listVisibleRecords(ctx, pds.client(), &params, caller)
+		require.NoError(t, err)
+		rkeys, visibilities := []string{}, []string{}
+		for _, r := range records {
+			rkeys = append(rkeys, r.Rkey)
+			visibilities = append(visibilities, r.Visibility)
+		}
+		return rkeys, visibilities
+	}
+
+	rkeys, visibilities := list("my-did", habitat.NetworkHabitatRepoListRecordsParams{})
+	require.Equal(t, []string{"key-0", "key-1", "key-2", "key-3", "key-4", "key-5"}, rkeys)
+	require.Equal(t, []string{
+		VisibilityPrivate, VisibilityPublic, VisibilityPrivate, VisibilityPublic, VisibilityPrivate, VisibilityPublic,
+	}, visibilities)
+
+	// Only permitted private records are merged in
+	rkeys, _ = list("another-did", habitat.NetworkHabitatRepoListRecordsParams{})
+	require.Equal(t, []string{"key-1", "key-2", "key-3", "key-5"}, rkeys)
+
+	// Pages continue from the last rkey across both sources
+	rkeys, _ = list("my-did", habitat.NetworkHabitatRepoListRecordsParams{Limit: 2, Cursor: "key-1"})
+	require.Equal(t, []string{"key-2", "key-3"}, rkeys)
+	rkeys, _ = list("my-did", habitat.NetworkHabitatRepoListRecordsParams{Limit: 3, Reverse: true})
+	require.Equal(t, []string{"key-5", "key-4", "key-3"}, rkeys)
+	rkeys, _ = list("my-did", habitat.NetworkHabitatRepoListRecordsParams{Limit: 3, Reverse: true, Cursor: "key-3"})
+	require.Equal(t, []string{"key-2", "key-1", "key-0"}, rkeys)
+
+	_, err := p.listVisibleRecords(ctx, pds.client(), &habitat.NetworkHabitatRepoListRecordsParams{
+		Repo:       "my-did",
+		Collection: coll,
+		Sort:       "createdAt",
+	}, "my-did")
+	require.ErrorIs(t, err, ErrInvalidFilter)
+}
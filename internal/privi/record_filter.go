@@ -0,0 +1,207 @@
+package privi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/eagraf/habitat-new/api/habitat"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Field-level filtering and sorting for listRecords.
+//
+// Record values are stored as JSON, so predicates are evaluated with the backend's JSON functions
+// (see dialect.fieldExpr). Fields that are queried often can be indexed (see dialect.indexField).
+
+var (
+	ErrInvalidFilter = fmt.Errorf("invalid record filter")
+	ErrInvalidCursor = fmt.Errorf("invalid cursor")
+)
+
+// Field paths are dot-separated identifiers, e.g. "createdAt" or "subject.uri". They are inlined
+// into SQL, so they must be validated against this pattern first.
+var fieldPathRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+
+// Two-character operators come first so that "<=" is not parsed as "<".
+var filterOps = []string{"!=", "<=", ">=", "=", "<", ">"}
+
+// recordFilter is a single predicate on a field within a record's value.
+type recordFilter struct {
+	path  string
+	op    string
+	value any
+}
+
+// parseRecordFilter parses a filter of the form <path><op><value>.
+// The value is interpreted as a JSON scalar if possible (e.g. 123, true, "123"), and as a bare
+// string otherwise.
+func parseRecordFilter(filter string) (*recordFilter, error) {
+	idx := strings.IndexAny(filter, "!=<>")
+	if idx <= 0 {
+		return nil, fmt.Errorf("%w: %q has no operator", ErrInvalidFilter, filter)
+	}
+
+	path := filter[:idx]
+	if !fieldPathRegexp.MatchString(path) {
+		return nil, fmt.Errorf("%w: invalid field path %q", ErrInvalidFilter, path)
+	}
+
+	var op string
+	for _, candidate := range filterOps {
+		if strings.HasPrefix(filter[idx:], candidate) {
+			op = candidate
+			break
+		}
+	}
+	if op == "" {
+		return nil, fmt.Errorf("%w: %q has no operator", ErrInvalidFilter, filter)
+	}
+
+	raw := filter[idx+len(op):]
+	var value any
+	if err := json.Unmarshal([]byte(raw), &value); err != nil {
+		value = raw
+	}
+	switch value.(type) {
+	case nil:
+		if op != "=" && op != "!=" {
+			return nil, fmt.Errorf("%w: null can only be compared with = or !=", ErrInvalidFilter)
+		}
+	case string, float64, bool:
+	default:
+		return nil, fmt.Errorf("%w: value %q is not a scalar", ErrInvalidFilter, raw)
+	}
+
+	return &recordFilter{path: path, op: op, value: value}, nil
+}
+
+func (r *gormRepo) filterExpr(f *recordFilter) clause.Expression {
+	expr := r.dialect.fieldExpr(f.path)
+	if f.value == nil {
+		if f.op == "=" {
+			return clause.Expr{SQL: expr + " IS NULL"}
+		}
+		return clause.Expr{SQL: expr + " IS NOT NULL"}
+	}
+	return clause.Expr{
+		SQL:  fmt.Sprintf("%s %s %s", expr, f.op, r.dialect.valueExpr(f.value)),
+		Vars: []any{f.value},
+	}
+}
+
+// sortCursor is the decoded form of a cursor for listRecords queries ordered by a record field.
+// Ties on the field value are broken by rkey.
+type sortCursor struct {
+	Value any    `json:"v"`
+	Rkey  string `json:"k"`
+}
+
+func decodeSortCursor(cursor string) (*sortCursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	var c sortCursor
+	if err := json.Unmarshal(bytes, &c); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	switch c.Value.(type) {
+	case nil, string, float64, bool:
+	default:
+		return nil, fmt.Errorf("%w: sort value is not a scalar", ErrInvalidCursor)
+	}
+	return &c, nil
+}
+
+// applySort orders the query by the requested field (or by rkey if none) and applies the cursor.
+// Records missing the sort field come first in ascending order and last in descending order, which is
+// how SQLite orders NULLs; other backends must match.
+func (r *gormRepo) applySort(
+	query gorm.ChainInterface[Record],
+	params *habitat.NetworkHabitatRepoListRecordsParams,
+) (gorm.ChainInterface[Record], error) {
+	dir, cmp := "ASC", ">"
+	if params.Reverse {
+		dir, cmp = "DESC", "<"
+	}
+
+	if params.Sort == "" {
+		if params.Cursor != "" {
+			query = query.Where(fmt.Sprintf("rkey %s ?", cmp), params.Cursor)
+		}
+		return query.Order("rkey " + dir), nil
+	}
+
+	if !fieldPathRegexp.MatchString(params.Sort) {
+		return nil, fmt.Errorf("%w: invalid sort field %q", ErrInvalidFilter, params.Sort)
+	}
+	expr := r.dialect.fieldExpr(params.Sort)
+	nulls := "NULLS FIRST"
+	if params.Reverse {
+		nulls = "NULLS LAST"
+	}
+
+	if params.Cursor != "" {
+		c, err := decodeSortCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		val := r.dialect.valueExpr(c.Value)
+		switch {
+		case c.Value == nil && !params.Reverse:
+			query = query.Where(
+				fmt.Sprintf("(%s IS NULL AND rkey > ?) OR %s IS NOT NULL", expr, expr),
+				c.Rkey,
+			)
+		case c.Value == nil && params.Reverse:
+			query = query.Where(fmt.Sprintf("%s IS NULL AND rkey < ?", expr), c.Rkey)
+		case !params.Reverse:
+			query = query.Where(
+				fmt.Sprintf("%s > %s OR (%s = %s AND rkey > ?)", expr, val, expr, val),
+				c.Value, c.Value, c.Rkey,
+			)
+		default:
+			query = query.Where(
+				fmt.Sprintf("%s < %s OR (%s = %s AND rkey < ?) OR %s IS NULL", expr, val, expr, val, expr),
+				c.Value, c.Value, c.Rkey,
+			)
+		}
+	}
+
+	return query.Order(fmt.Sprintf("%s %s %s, rkey %s", expr, dir, nulls, dir)), nil
+}
+
+// nextCursor returns the cursor that continues a listRecords query after the given page, or the
+// empty string if the page was not full.
+func nextCursor(params *habitat.NetworkHabitatRepoListRecordsParams, records []Record) (string, error) {
+	if params.Limit == 0 || len(records) < int(params.Limit) {
+		return "", nil
+	}
+	last := records[len(records)-1]
+	if params.Sort == "" {
+		return last.Rkey, nil
+	}
+
+	var value any
+	if err := json.Unmarshal([]byte(last.Rec), &value); err != nil {
+		return "", err
+	}
+	for _, part := range strings.Split(params.Sort, ".") {
+		obj, ok := value.(map[string]any)
+		if !ok {
+			value = nil
+			break
+		}
+		value = obj[part]
+	}
+
+	bytes, err := json.Marshal(&sortCursor{Value: value, Rkey: last.Rkey})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
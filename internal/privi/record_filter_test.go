@@ -0,0 +1,30 @@
+package privi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRecordFilter(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		want   *recordFilter
+	}{
+		{"createdAt>=2024-01-01", &recordFilter{"createdAt", ">=", "2024-01-01"}},
+		{"subject.uri=at://did:plc:abc/coll/1", &recordFilter{"subject.uri", "=", "at://did:plc:abc/coll/1"}},
+		{"count<3", &recordFilter{"count", "<", float64(3)}},
+		{`count!="3"`, &recordFilter{"count", "!=", "3"}},
+		{"done=true", &recordFilter{"done", "=", true}},
+		{"done=null", &recordFilter{"done", "=", nil}},
+	} {
+		got, err := parseRecordFilter(tc.filter)
+		require.NoError(t, err, tc.filter)
+		require.Equal(t, tc.want, got, tc.filter)
+	}
+
+	for _, bad := range []string{"createdAt", "=value", "a b=c", "a..b=c", "a<null", "a=[1]"} {
+		_, err := parseRecordFilter(bad)
+		require.ErrorIs(t, err, ErrInvalidFilter, bad)
+	}
+}
@@ -5,69 +5,108 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"strconv"
-	"strings"
+	"slices"
 
 	"github.com/bluesky-social/indigo/atproto/atdata"
-	"github.com/eagraf/habitat-new/util"
 	"github.com/ipfs/go-cid"
 	"github.com/multiformats/go-multihash"
-	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 
 	"github.com/eagraf/habitat-new/api/habitat"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/eagraf/habitat-new/internal/permissions"
 )
 
 // Persist private data within repos that mirror public repos.
-// A repo currently implements four basic methods: putRecord, getRecord, uploadBlob, getBlob
+// A repo currently implements basic methods to put, get, list and delete records, and to upload and get blobs.
 // In the future, it is possible to implement sync endpoints and other methods.
 
-// A sqlite-backed repo per user contains the following two columns:
-// [did, record key, record value]
+// A repo per user contains the following columns:
+// [did, collection, record key, record value]
 // For now, store all records in the same database. Eventually, this should be broken up into
 // per-user databases or per-user MST repos.
 
-// We really shouldn't have unexported types that get passed around outside the package, like to `main.go`
-// Leaving this as-is for now.
-type sqliteRepo struct {
+// Repo is the backing store for private records and blobs.
+// There is a SQLite implementation (NewSQLiteRepo) and a Postgres implementation (NewPostgresRepo).
+type Repo interface {
+	// PutRecord puts a record for the given collection and rkey, overwriting any existing record. author is the
+	// DID writing the record, which is kept when an existing record is overwritten.
+	PutRecord(did string, author string, collection string, rkey string, rec map[string]any, validate *bool) error
+	// GetRecord returns ErrRecordNotFound if no record exists for the given key.
+	GetRecord(did string, collection string, rkey string) (*Record, error)
+	// ListRecords lists records in a collection, filtered down to those whose "nsid:rkey" objects the policy
+	// allows access to.
+	ListRecords(params *habitat.NetworkHabitatRepoListRecordsParams, policy *permissions.Policy) ([]Record, error)
+	// ListSharedRecords lists records in a collection across the repos in policies, ordered by repo and rkey and
+	// starting after the given repo and rkey if set. Each repo's records are filtered by its own policy, as in
+	// ListRecords.
+	ListSharedRecords(
+		collection string,
+		policies map[string]*permissions.Policy,
+		afterDid string,
+		afterRkey string,
+		limit int,
+	) ([]Record, error)
+	// DeleteRecord returns ErrRecordNotFound if no record exists for the given key.
+	DeleteRecord(did string, collection string, rkey string) error
+	UploadBlob(did string, data []byte, mimeType string) (*BlobRef, error)
+	// GetBlob returns the blob's mimetype and contents, or ErrRecordNotFound.
+	GetBlob(did string, cid string) (string, []byte, error)
+	// ListBlobRecords lists the records in did's repo that reference the blob.
+	ListBlobRecords(did string, cid string) ([]Record, error)
+}
+
+// dialect captures the parts of a gormRepo that differ between database backends.
+type dialect interface {
+	// fieldExpr returns the SQL expression for a validated field path within a record's value.
+	fieldExpr(path string) string
+	// valueExpr returns the SQL placeholder that a scalar is bound to when compared against a fieldExpr.
+	valueExpr(value any) string
+	// indexField makes filtering and sorting on the given validated field path efficient.
+	indexField(db *gorm.DB, path string) error
+	// containsExpr returns the SQL condition that a record's value contains the string bound to it.
+	containsExpr() string
+}
+
+// gormRepo implements Repo on top of gorm, and is shared by all backends.
+type gormRepo struct {
 	db          *gorm.DB
 	maxBlobSize int
+	dialect     dialect
 }
 
-// Helper function to query sqlite compile-time options to get the max blob size
-// Not sure if this can change across versions, if so we need to keep that stable
-func getMaxBlobSize(db *gorm.DB) (int, error) {
-	sqlDb, err := db.DB()
-	if err != nil {
-		return 0, err
-	}
+var _ Repo = (*gormRepo)(nil)
 
-	rows, err := sqlDb.Query("PRAGMA compile_options;")
-	if err != nil {
-		return 0, err
-	}
-	defer util.Close(rows, func(err error) {
-		log.Err(err).Msgf("error closing db rows")
-	})
-
-	for rows.Next() {
-		var opt string
-		_ = rows.Scan(&opt)
-		if strings.HasPrefix(opt, "MAX_LENGTH=") {
-			return strconv.Atoi(strings.TrimPrefix(opt, "MAX_LENGTH="))
+func newGormRepo(db *gorm.DB, maxBlobSize int, d dialect, indexedFields []string) (*gormRepo, error) {
+	for _, path := range indexedFields {
+		if !fieldPathRegexp.MatchString(path) {
+			return nil, fmt.Errorf("%w: invalid field path %q", ErrInvalidFilter, path)
+		}
+		if err := d.indexField(db, path); err != nil {
+			return nil, err
 		}
 	}
-	return 0, fmt.Errorf("no MAX_LENGTH parameter found")
+	return &gormRepo{
+		db:          db,
+		maxBlobSize: maxBlobSize,
+		dialect:     d,
+	}, nil
 }
 
 type Record struct {
-	Did  string `gorm:"primaryKey"`
-	Rkey string `gorm:"primaryKey"`
-	Rec  string
+	Did        string `gorm:"primaryKey"`
+	Collection string `gorm:"primaryKey"`
+	Rkey       string `gorm:"primaryKey"`
+	Rec        string
+	// The DID that created the record, which is only someone other than Did if they were granted permission
+	// to write to Did's repo.
+	Author string
 }
 
+// objectExpr is the permission object ("nsid:rkey", see permissions.RecordObject) that a records row corresponds
+// to. Permission policies are matched against it (see permissions.Policy.Condition).
+const objectExpr = "collection || ':' || rkey"
+
 type Blob struct {
 	gorm.Model
 	Did      string
@@ -76,25 +115,16 @@ type Blob struct {
 	Blob     []byte
 }
 
-// TODO: create table etc.
-func NewSQLiteRepo(db *gorm.DB) (*sqliteRepo, error) {
-	if err := db.AutoMigrate(&Record{}, &Blob{}); err != nil {
-		return nil, err
-	}
-
-	maxBlobSize, err := getMaxBlobSize(db)
-	if err != nil {
-		return nil, err
-	}
-
-	return &sqliteRepo{
-		db:          db,
-		maxBlobSize: maxBlobSize,
-	}, nil
-}
-
-// putRecord puts a record for the given rkey into the repo no matter what; if a record always exists, it is overwritten.
-func (r *sqliteRepo) putRecord(did string, rkey string, rec map[string]any, validate *bool) error {
+// PutRecord puts a record for the given collection and rkey into the repo no matter what; if a record always exists, it is overwritten.
+// The record's author is only set when it is created.
+func (r *gormRepo) PutRecord(
+	did string,
+	author string,
+	collection string,
+	rkey string,
+	rec map[string]any,
+	validate *bool,
+) error {
 	if validate != nil && *validate {
 		err := atdata.Validate(rec)
 		if err != nil {
@@ -107,11 +137,14 @@ func (r *sqliteRepo) putRecord(did string, rkey string, rec map[string]any, vali
 		return err
 	}
 
-	record := Record{Did: did, Rkey: rkey, Rec: string(bytes)}
+	record := Record{Did: did, Collection: collection, Rkey: rkey, Rec: string(bytes), Author: author}
 	// Always put (even if something exists).
 	return gorm.G[Record](
 		r.db,
-		clause.OnConflict{UpdateAll: true},
+		clause.OnConflict{
+			Columns:   []clause.Column{{Name: "did"}, {Name: "collection"}, {Name: "rkey"}},
+			DoUpdates: clause.AssignmentColumns([]string{"rec"}),
+		},
 	).Create(context.Background(), &record)
 }
 
@@ -120,10 +153,10 @@ var (
 	ErrMultipleRecordsFound = fmt.Errorf("multiple records found for desired query")
 )
 
-func (r *sqliteRepo) getRecord(did string, rkey string) (*Record, error) {
+func (r *gormRepo) GetRecord(did string, collection string, rkey string) (*Record, error) {
 	row, err := gorm.G[Record](
 		r.db,
-	).Where("did = ? and rkey = ?", did, rkey).
+	).Where("did = ? and collection = ? and rkey = ?", did, collection, rkey).
 		First(context.Background())
 	if errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, ErrRecordNotFound
@@ -133,17 +166,31 @@ func (r *sqliteRepo) getRecord(did string, rkey string) (*Record, error) {
 	return &row, nil
 }
 
-type blob struct {
+func (r *gormRepo) DeleteRecord(did string, collection string, rkey string) error {
+	deleted, err := gorm.G[Record](
+		r.db,
+	).Where("did = ? and collection = ? and rkey = ?", did, collection, rkey).
+		Delete(context.Background())
+	if err != nil {
+		return err
+	} else if deleted == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// BlobRef describes an uploaded blob.
+type BlobRef struct {
 	Ref      atdata.CIDLink `json:"cid"`
 	MimeType string         `json:"mimetype"`
 	Size     int64          `json:"size"`
 }
 
-func (r *sqliteRepo) uploadBlob(did string, data []byte, mimeType string) (*blob, error) {
+func (r *gormRepo) UploadBlob(did string, data []byte, mimeType string) (*BlobRef, error) {
 	// Validate blob size
 	if len(data) > r.maxBlobSize {
 		return nil, fmt.Errorf(
-			"blob size is too big, must be < max blob size (based on SQLITE MAX_LENGTH compile option): %d bytes",
+			"blob size is too big, must be < max blob size: %d bytes",
 			r.maxBlobSize,
 		)
 	}
@@ -167,16 +214,16 @@ func (r *sqliteRepo) uploadBlob(did string, data []byte, mimeType string) (*blob
 		return nil, err
 	}
 
-	return &blob{
+	return &BlobRef{
 		Ref:      atdata.CIDLink(cid),
 		MimeType: mimeType,
 		Size:     int64(len(data)),
 	}, nil
 }
 
-// getBlob gets a blob. this is never exposed to the server, because blobs can only be resolved via records that link them (see LexLink)
+// GetBlob gets a blob. this is never exposed to the server, because blobs can only be resolved via records that link them (see LexLink)
 // besides exceptional cases like data migration which we do not support right now.
-func (r *sqliteRepo) getBlob(
+func (r *gormRepo) GetBlob(
 	did string,
 	cid string,
 ) (string /* mimetype */, []byte /* raw blob */, error) {
@@ -192,50 +239,58 @@ func (r *sqliteRepo) getBlob(
 	return row.MimeType, row.Blob, nil
 }
 
-// listRecords implements repo.
-func (r *sqliteRepo) listRecords(
+// ListBlobRecords implements Repo. Records mentioning the CID anywhere are narrowed down to those that have it as
+// a blob ref.
+func (r *gormRepo) ListBlobRecords(did string, cid string) ([]Record, error) {
+	rows, err := gorm.G[Record](r.db).
+		Where("did = ?", did).
+		Where(r.dialect.containsExpr(), cid).
+		Order("collection, rkey").
+		Find(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	records := []Record{}
+	for _, row := range rows {
+		var value any
+		if err := json.Unmarshal([]byte(row.Rec), &value); err != nil {
+			return nil, err
+		}
+		if slices.Contains(findBlobRefs(value), cid) {
+			records = append(records, row)
+		}
+	}
+	return records, nil
+}
+
+// ListRecords implements Repo.
+func (r *gormRepo) ListRecords(
 	params *habitat.NetworkHabitatRepoListRecordsParams,
-	allow []string,
-	deny []string,
+	policy *permissions.Policy,
 ) ([]Record, error) {
-	if len(allow) == 0 {
+	if !policy.AllowsAny() {
 		return []Record{}, nil
 	}
 
 	query := gorm.G[Record](
 		r.db.Debug(),
 	).Where("did = ?", params.Repo).
-		Where("rkey LIKE ?", params.Collection+".%")
-
-	// Build OR conditions for allow list
-	if len(allow) > 0 {
-		allowConditions := r.db.Where("1 = 0") // Start with false condition
-		for _, a := range allow {
-			if strings.HasSuffix(a, "*") {
-				// Wildcard match
-				prefix := strings.TrimSuffix(a, "*")
-				allowConditions = allowConditions.Or("rkey LIKE ?", prefix+"%")
-			} else {
-				// Exact match
-				allowConditions = allowConditions.Or("rkey = ?", a)
-			}
-		}
-		query = query.Where(allowConditions)
-	}
+		Where("collection = ?", params.Collection).
+		Where(policy.Condition(objectExpr))
 
-	// Build deny conditions - use NOT LIKE or != for each deny pattern
-	for _, d := range deny {
-		if strings.HasSuffix(d, "*") {
-			prefix := strings.TrimSuffix(d, "*")
-			query = query.Where("rkey NOT LIKE ?", prefix+"%")
-		} else {
-			query = query.Where("rkey != ?", d)
+	// Field-level predicates on the record value
+	for _, f := range params.Filter {
+		filter, err := parseRecordFilter(f)
+		if err != nil {
+			return nil, err
 		}
+		query = query.Where(r.filterExpr(filter))
 	}
 
-	// Cursor-based pagination
-	if params.Cursor != "" {
-		query = query.Where("rkey > ?", params.Cursor)
+	// Order by the sort field (or rkey) for consistent cursor-based pagination
+	query, err := r.applySort(query, params)
+	if err != nil {
+		return nil, err
 	}
 
 	// Limit
@@ -243,9 +298,6 @@ func (r *sqliteRepo) listRecords(
 		query = query.Limit(int(params.Limit))
 	}
 
-	// Order by rkey for consistent pagination
-	query = query.Order("rkey ASC")
-
 	// Execute query
 	rows, err := query.Find(context.Background())
 	if err != nil {
@@ -253,3 +305,44 @@ func (r *sqliteRepo) listRecords(
 	}
 	return rows, nil
 }
+
+// ListSharedRecords implements Repo.
+func (r *gormRepo) ListSharedRecords(
+	collection string,
+	policies map[string]*permissions.Policy,
+	afterDid string,
+	afterRkey string,
+	limit int,
+) ([]Record, error) {
+	dids := make([]string, 0, len(policies))
+	for did, policy := range policies {
+		if policy.AllowsAny() {
+			dids = append(dids, did)
+		}
+	}
+	if len(dids) == 0 {
+		return []Record{}, nil
+	}
+	slices.Sort(dids)
+
+	repos := r.db.Where("1 = 0")
+	for _, did := range dids {
+		repos = repos.Or(r.db.Where("did = ?", did).Where(policies[did].Condition(objectExpr)))
+	}
+	query := gorm.G[Record](r.db).
+		Where("collection = ?", collection).
+		Where(repos)
+	if afterDid != "" {
+		query = query.Where("did > ? OR (did = ? AND rkey > ?)", afterDid, afterDid, afterRkey)
+	}
+	query = query.Order("did, rkey")
+	if limit != 0 {
+		query = query.Limit(limit)
+	}
+
+	rows, err := query.Find(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	return rows, nil
+}
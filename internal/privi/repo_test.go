@@ -2,34 +2,56 @@ package privi
 
 import (
 	"encoding/json"
-	"os"
-	"path/filepath"
+	"fmt"
 	"testing"
 
 	"github.com/eagraf/habitat-new/api/habitat"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/eagraf/habitat-new/internal/permissions"
 	"github.com/stretchr/testify/require"
-	"gorm.io/driver/sqlite"
-	"gorm.io/gorm"
 )
 
-func TestSQLiteRepoPutAndGetRecord(t *testing.T) {
-	testDBPath := filepath.Join(os.TempDir(), "test_privi.db")
-	defer func() { require.NoError(t, os.Remove(testDBPath)) }()
+// newRepoFunc returns an empty repo for a single test.
+type newRepoFunc func(t *testing.T, indexedFields ...string) Repo
 
-	priviDB, err := gorm.Open(sqlite.Open(testDBPath), &gorm.Config{})
-	require.NoError(t, err)
+// testPolicy allows access to the allow objects and everything under them, except for the deny objects.
+func testPolicy(allow []string, deny []string) *permissions.Policy {
+	var rules []permissions.Rule
+	for _, object := range allow {
+		rules = append(rules, permissions.Rule{Object: object, Effect: permissions.EffectAllow})
+	}
+	for _, object := range deny {
+		rules = append(rules, permissions.Rule{Object: object, Effect: permissions.EffectDeny})
+	}
+	return permissions.NewPolicy(rules...)
+}
 
-	repo, err := NewSQLiteRepo(priviDB)
-	require.NoError(t, err)
+// runRepoConformance runs the behavior that every Repo implementation must satisfy.
+func runRepoConformance(t *testing.T, newRepo newRepoFunc) {
+	t.Run("PutAndGetRecord", func(t *testing.T) { testRepoPutAndGetRecord(t, newRepo(t)) })
+	t.Run("DeleteRecord", func(t *testing.T) { testRepoDeleteRecord(t, newRepo(t)) })
+	t.Run("ListRecords", func(t *testing.T) { testRepoListRecords(t, newRepo(t)) })
+	t.Run("CollectionColumn", func(t *testing.T) { testRepoCollectionColumn(t, newRepo(t)) })
+	t.Run("FilterAndSort", func(t *testing.T) {
+		testRepoFilterAndSort(t, listRecordsRepo(t, newRepo(t)))
+	})
+	t.Run("FilterAndSortIndexed", func(t *testing.T) {
+		testRepoFilterAndSort(t, listRecordsRepo(t, newRepo(t, "createdAt", "subject.uri")))
+	})
+	t.Run("SortedPagination", func(t *testing.T) {
+		testRepoSortedPagination(t, listRecordsRepo(t, newRepo(t, "createdAt")))
+	})
+	t.Run("UploadAndGetBlob", func(t *testing.T) { testRepoUploadAndGetBlob(t, newRepo(t)) })
+	t.Run("ListBlobRecords", func(t *testing.T) { testRepoListBlobRecords(t, newRepo(t)) })
+}
 
+func testRepoPutAndGetRecord(t *testing.T, repo Repo) {
 	key := "test-key"
 	val := map[string]any{"data": "value", "data-1": float64(123), "data-2": true}
 
-	err = repo.putRecord("my-did", key, val, nil)
+	err := repo.PutRecord("my-did", "my-did", "my.collection", key, val, nil)
 	require.NoError(t, err)
 
-	got, err := repo.getRecord("my-did", key)
+	got, err := repo.GetRecord("my-did", "my.collection", key)
 	require.NoError(t, err)
 
 	var unmarshalled map[string]any
@@ -37,124 +59,299 @@ func TestSQLiteRepoPutAndGetRecord(t *testing.T) {
 	require.NoError(t, err)
 
 	require.Equal(t, val, unmarshalled)
-}
 
-func TestSQLiteRepoListRecords(t *testing.T) {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	// Putting again overwrites
+	val["data"] = "new-value"
+	require.NoError(t, repo.PutRecord("my-did", "my-did", "my.collection", key, val, nil))
+	got, err = repo.GetRecord("my-did", "my.collection", key)
 	require.NoError(t, err)
-	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, json.Unmarshal([]byte(got.Rec), &unmarshalled))
+	require.Equal(t, "new-value", unmarshalled["data"])
+
+	// The author is kept when someone else overwrites the record.
+	require.NoError(t, repo.PutRecord("my-did", "other-did", "my.collection", "comment", val, nil))
+	require.NoError(t, repo.PutRecord("my-did", "my-did", "my.collection", "comment", val, nil))
+	got, err = repo.GetRecord("my-did", "my.collection", "comment")
 	require.NoError(t, err)
-	err = repo.putRecord(
+	require.Equal(t, "other-did", got.Author)
+
+	_, err = repo.GetRecord("my-did", "my.collection", "other-key")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+}
+
+func testRepoDeleteRecord(t *testing.T, repo Repo) {
+	require.NoError(t, repo.PutRecord("my-did", "my-did", "my.collection", "key", map[string]any{"a": "b"}, nil))
+
+	require.NoError(t, repo.DeleteRecord("my-did", "my.collection", "key"))
+	_, err := repo.GetRecord("my-did", "my.collection", "key")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+
+	require.ErrorIs(t, repo.DeleteRecord("my-did", "my.collection", "key"), ErrRecordNotFound)
+}
+
+func testRepoListRecords(t *testing.T, repo Repo) {
+	err := repo.PutRecord(
+		"my-did",
 		"my-did",
-		"network.habitat.collection-1.key-1",
+		"network.habitat.collection-1",
+		"key-1",
 		map[string]any{"data": "value"},
 		nil,
 	)
 	require.NoError(t, err)
 
-	err = repo.putRecord(
+	err = repo.PutRecord(
 		"my-did",
-		"network.habitat.collection-1.key-2",
+		"my-did",
+		"network.habitat.collection-1",
+		"key-2",
 		map[string]any{"data": "value"},
 		nil,
 	)
 	require.NoError(t, err)
 
-	err = repo.putRecord(
+	err = repo.PutRecord(
+		"my-did",
 		"my-did",
-		"network.habitat.collection-2.key-2",
+		"network.habitat.collection-2",
+		"key-2",
 		map[string]any{"data": "value"},
 		nil,
 	)
 	require.NoError(t, err)
 
-	records, err := repo.listRecords(
+	records, err := repo.ListRecords(
 		&habitat.NetworkHabitatRepoListRecordsParams{
 			Repo:       "my-did",
 			Collection: "network.habitat.collection-1",
 		},
-		[]string{},
-		[]string{},
+		testPolicy([]string{}, []string{}),
 	)
 	require.NoError(t, err)
 	require.Len(t, records, 0)
 
-	records, err = repo.listRecords(
+	records, err = repo.ListRecords(
 		&habitat.NetworkHabitatRepoListRecordsParams{
 			Repo:       "my-did",
 			Collection: "network.habitat.collection-1",
 		},
-		[]string{"network.habitat.collection-1.key-1", "network.habitat.collection-1.key-2"},
-		[]string{},
+		testPolicy([]string{
+			permissions.RecordObject("network.habitat.collection-1", "key-1"),
+			permissions.RecordObject("network.habitat.collection-1", "key-2"),
+		}, []string{}),
 	)
 	require.NoError(t, err)
 	require.Len(t, records, 2)
 
-	records, err = repo.listRecords(
+	records, err = repo.ListRecords(
 		&habitat.NetworkHabitatRepoListRecordsParams{
 			Repo:       "my-did",
 			Collection: "network.habitat.collection-1",
 		},
-		[]string{"network.habitat.collection-1.*"},
-		[]string{},
+		testPolicy([]string{"network.habitat.collection-1"}, []string{}),
 	)
 	require.NoError(t, err)
 	require.Len(t, records, 2)
 
-	records, err = repo.listRecords(
+	records, err = repo.ListRecords(
 		&habitat.NetworkHabitatRepoListRecordsParams{
 			Repo:       "my-did",
 			Collection: "network.habitat.collection-1",
 		},
-		[]string{"network.habitat.collection-1.*"},
-		[]string{"network.habitat.collection-1.key-1"},
+		testPolicy([]string{"network.habitat.collection-1"}, []string{permissions.RecordObject("network.habitat.collection-1", "key-1")}),
 	)
 	require.NoError(t, err)
 	require.Len(t, records, 1)
 
-	records, err = repo.listRecords(
+	records, err = repo.ListRecords(
 		&habitat.NetworkHabitatRepoListRecordsParams{
 			Repo:       "my-did",
 			Collection: "network.habitat.collection-2",
 		},
-		[]string{"network.habitat.*"},
-		[]string{},
+		testPolicy([]string{"network.habitat"}, []string{}),
 	)
 	require.NoError(t, err)
 	require.Len(t, records, 1)
 
-	records, err = repo.listRecords(
+	records, err = repo.ListRecords(
 		&habitat.NetworkHabitatRepoListRecordsParams{
 			Repo:       "my-did",
 			Collection: "network.habitat.collection-2",
 		},
-		[]string{"network.habitat.*"},
-		[]string{"network.habitat.collection-2.*"},
+		testPolicy([]string{"network.habitat"}, []string{"network.habitat.collection-2"}),
 	)
 	require.NoError(t, err)
 	require.Len(t, records, 0)
 }
 
-func TestUploadAndGetBlob(t *testing.T) {
-	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+func testRepoCollectionColumn(t *testing.T, repo Repo) {
+	// Collections that share a prefix and rkeys containing dots are kept apart.
+	require.NoError(t, repo.PutRecord("my-did", "my-did", "network.habitat.post", "a.b", map[string]any{"n": 1.0}, nil))
+	require.NoError(t, repo.PutRecord("my-did", "my-did", "network.habitat.postComment", "a", map[string]any{"n": 2.0}, nil))
+
+	got, err := repo.GetRecord("my-did", "network.habitat.post", "a.b")
 	require.NoError(t, err)
+	require.Equal(t, "network.habitat.post", got.Collection)
+	require.Equal(t, "a.b", got.Rkey)
 
-	repo, err := NewSQLiteRepo(db)
+	_, err = repo.GetRecord("my-did", "network.habitat.post.a", "b")
+	require.ErrorIs(t, err, ErrRecordNotFound)
+
+	records, err := repo.ListRecords(
+		&habitat.NetworkHabitatRepoListRecordsParams{Repo: "my-did", Collection: "network.habitat.post"},
+		testPolicy([]string{"network.habitat"}, []string{}),
+	)
 	require.NoError(t, err)
+	require.Len(t, records, 1)
+	require.Equal(t, "a.b", records[0].Rkey)
+}
 
+// listRecordsRepo fills the repo with records to filter and sort.
+func listRecordsRepo(t *testing.T, repo Repo) Repo {
+	coll := "network.habitat.post"
+	for i, createdAt := range []string{"2024-03-01", "2024-01-01", "2024-02-01"} {
+		rec := map[string]any{
+			"createdAt": createdAt,
+			"likes":     float64(i),
+			"subject":   map[string]any{"uri": fmt.Sprintf("at://subject-%d", i%2)},
+		}
+		require.NoError(t, repo.PutRecord("my-did", "my-did", coll, fmt.Sprintf("key-%d", i), rec, nil))
+	}
+	require.NoError(t, repo.PutRecord("my-did", "my-did", coll, "key-3", map[string]any{"likes": float64(3)}, nil))
+	return repo
+}
+
+func rkeys(records []Record) []string {
+	keys := []string{}
+	for _, r := range records {
+		keys = append(keys, r.Rkey)
+	}
+	return keys
+}
+
+func testRepoFilterAndSort(t *testing.T, repo Repo) {
+	list := func(params habitat.NetworkHabitatRepoListRecordsParams) []string {
+		params.Repo = "my-did"
+		params.Collection = "network.habitat.post"
+		records, err := repo.ListRecords(&params, testPolicy([]string{"network.habitat.post"}, []string{}))
+		require.NoError(t, err)
+		return rkeys(records)
+	}
+	key := func(i int) string { return fmt.Sprintf("key-%d", i) }
+
+	require.Equal(t, []string{key(0), key(2)}, list(habitat.NetworkHabitatRepoListRecordsParams{
+		Filter: []string{"subject.uri=at://subject-0"},
+	}))
+	require.Equal(t, []string{key(0), key(2)}, list(habitat.NetworkHabitatRepoListRecordsParams{
+		Filter: []string{"createdAt>2024-01-15"},
+	}))
+	require.Equal(t, []string{key(2)}, list(habitat.NetworkHabitatRepoListRecordsParams{
+		Filter: []string{"createdAt>2024-01-15", "likes>=1"},
+	}))
+	require.Equal(t, []string{key(3)}, list(habitat.NetworkHabitatRepoListRecordsParams{
+		Filter: []string{"createdAt=null"},
+	}))
+
+	// Records without the sort field come first when ascending and last when descending.
+	require.Equal(t, []string{key(3), key(1), key(2), key(0)}, list(habitat.NetworkHabitatRepoListRecordsParams{
+		Sort: "createdAt",
+	}))
+	require.Equal(t, []string{key(0), key(2), key(1), key(3)}, list(habitat.NetworkHabitatRepoListRecordsParams{
+		Sort:    "createdAt",
+		Reverse: true,
+	}))
+	require.Equal(t, []string{key(3), key(2), key(1), key(0)}, list(habitat.NetworkHabitatRepoListRecordsParams{
+		Reverse: true,
+	}))
+
+	_, err := repo.ListRecords(&habitat.NetworkHabitatRepoListRecordsParams{
+		Repo:       "my-did",
+		Collection: "network.habitat.post",
+		Sort:       "created At",
+	}, testPolicy([]string{"network.habitat.post"}, []string{}))
+	require.ErrorIs(t, err, ErrInvalidFilter)
+}
+
+func testRepoSortedPagination(t *testing.T, repo Repo) {
+	for _, reverse := range []bool{false, true} {
+		params := &habitat.NetworkHabitatRepoListRecordsParams{
+			Repo:       "my-did",
+			Collection: "network.habitat.post",
+			Sort:       "createdAt",
+			Reverse:    reverse,
+			Limit:      1,
+		}
+		all, err := repo.ListRecords(&habitat.NetworkHabitatRepoListRecordsParams{
+			Repo:       params.Repo,
+			Collection: params.Collection,
+			Sort:       params.Sort,
+			Reverse:    params.Reverse,
+		}, testPolicy([]string{"network.habitat.post"}, []string{}))
+		require.NoError(t, err)
+
+		paged := []Record{}
+		for {
+			page, err := repo.ListRecords(params, testPolicy([]string{"network.habitat.post"}, []string{}))
+			require.NoError(t, err)
+			paged = append(paged, page...)
+			params.Cursor, err = nextCursor(params, page)
+			require.NoError(t, err)
+			if params.Cursor == "" {
+				break
+			}
+		}
+		require.Equal(t, rkeys(all), rkeys(paged))
+	}
+
+	_, err := repo.ListRecords(&habitat.NetworkHabitatRepoListRecordsParams{
+		Repo:       "my-did",
+		Collection: "network.habitat.post",
+		Sort:       "createdAt",
+		Cursor:     "not a cursor",
+	}, testPolicy([]string{"network.habitat.post"}, []string{}))
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func testRepoUploadAndGetBlob(t *testing.T, repo Repo) {
 	did := "did:example:alice"
 	// use an empty blob to avoid hitting sqlite3.SQLITE_LIMIT_LENGTH in test environment
 	blob := []byte("this is my test blob")
 	mtype := "text/plain"
 
-	bmeta, err := repo.uploadBlob(did, blob, mtype)
+	bmeta, err := repo.UploadBlob(did, blob, mtype)
 	require.NoError(t, err)
 	require.NotNil(t, bmeta)
 	require.Equal(t, mtype, bmeta.MimeType)
 	require.Equal(t, int64(len(blob)), bmeta.Size)
 
-	m, gotBlob, err := repo.getBlob(did, bmeta.Ref.String())
+	m, gotBlob, err := repo.GetBlob(did, bmeta.Ref.String())
 	require.NoError(t, err)
 	require.Equal(t, mtype, m)
 	require.Equal(t, blob, gotBlob)
+
+	_, _, err = repo.GetBlob("did:example:bob", bmeta.Ref.String())
+	require.ErrorIs(t, err, ErrRecordNotFound)
+}
+
+func testRepoListBlobRecords(t *testing.T, repo Repo) {
+	did := "did:example:alice"
+	ref, err := repo.UploadBlob(did, []byte("this is my test blob"), "text/plain")
+	require.NoError(t, err)
+	cid := ref.Ref.String()
+	blob := map[string]any{"$type": "blob", "ref": map[string]any{"$link": cid}, "mimeType": "text/plain"}
+
+	require.NoError(t, repo.PutRecord(did, did, "my.collection", "direct", map[string]any{"file": blob}, nil))
+	require.NoError(t, repo.PutRecord(did, did, "my.collection", "nested", map[string]any{
+		"files": []any{map[string]any{"file": blob}},
+	}, nil))
+	require.NoError(t, repo.PutRecord(did, did, "my.collection", "mention", map[string]any{"text": cid}, nil))
+	require.NoError(t, repo.PutRecord("did:example:bob", "did:example:bob", "my.collection", "other", map[string]any{"file": blob}, nil))
+
+	records, err := repo.ListBlobRecords(did, cid)
+	require.NoError(t, err)
+	rkeys := []string{}
+	for _, record := range records {
+		rkeys = append(rkeys, record.Rkey)
+	}
+	require.Equal(t, []string{"direct", "nested"}, rkeys)
 }
@@ -3,16 +3,24 @@ package privi
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/bluesky-social/indigo/atproto/identity"
 	"github.com/bluesky-social/indigo/atproto/syntax"
 	"github.com/google/uuid"
 
 	"github.com/eagraf/habitat-new/api/habitat"
+	"github.com/eagraf/habitat-new/internal/bffauth"
+	"github.com/eagraf/habitat-new/internal/didweb"
+	"github.com/eagraf/habitat-new/internal/oauthclient"
 	"github.com/eagraf/habitat-new/internal/oauthserver"
 	"github.com/eagraf/habitat-new/internal/permissions"
 	"github.com/eagraf/habitat-new/internal/utils"
@@ -26,30 +34,91 @@ type Server struct {
 	// Used for resolving handles -> did, did -> PDS
 	dir identity.Directory
 	// TODO: should this really live here?
-	repo        *sqliteRepo
+	repo        Repo
 	oauthServer *oauthserver.OAuthServer
+	importer    *importer
+
+	// Validates tokens presented by other Habitat nodes. If nil, only OAuth sessions are accepted.
+	nodeAuth bffauth.Server
+
+	// Validates atproto service-auth tokens addressed to this node. If nil, they aren't accepted.
+	serviceAuth *serviceAuthValidator
+
+	// This node's #habitat service endpoint. Reads of repos whose DID documents point at a different
+	// endpoint are forwarded there. If empty, all reads are served locally.
+	serviceEndpoint string
+	forwarder       *forwarder
+
+	// Mints and checks capability tokens. If nil, capabilities are disabled.
+	capabilities *capabilityIssuer
+}
+
+// NodeConfig describes how this node identifies itself to other Habitat nodes and atproto services, and how it
+// authenticates them.
+type NodeConfig struct {
+	// This node's #habitat service endpoint, e.g. https://habitat.example. If empty, reads are never forwarded
+	// and service-auth tokens are neither accepted nor minted.
+	ServiceEndpoint string
+	// Issues the tokens used to authenticate to other Habitat nodes when forwarding requests. May be nil.
+	NodeTokens bffauth.Client
+	// Validates the tokens other nodes present to this one. May be nil.
+	NodeAuth bffauth.Server
+	// Mints service-auth tokens identifying this node. Its DID must be the service endpoint's did:web. Also signs
+	// capability tokens. May be nil, which disables capabilities.
+	Signer *ServiceAuthSigner
 }
 
 // NewServer returns a privi server.
 func NewServer(
 	perms permissions.Store,
-	repo *sqliteRepo,
+	repo Repo,
 	oauthServer *oauthserver.OAuthServer,
+	node NodeConfig,
 ) *Server {
+	store := newStore(perms, repo)
+	dir := identity.DefaultDirectory()
 	server := &Server{
-		store:       newStore(perms, repo),
-		dir:         identity.DefaultDirectory(),
-		repo:        repo,
-		oauthServer: oauthServer,
+		store:           store,
+		dir:             dir,
+		repo:            repo,
+		oauthServer:     oauthServer,
+		nodeAuth:        node.NodeAuth,
+		importer:        newImporter(store),
+		serviceEndpoint: strings.TrimSuffix(node.ServiceEndpoint, "/"),
+		forwarder:       newForwarder(http.DefaultClient, node.NodeTokens, node.Signer),
+	}
+	if serviceDID, err := serviceDIDFor(node.ServiceEndpoint); err != nil {
+		log.Error().Err(err).Msg("service auth is disabled")
+	} else if serviceDID != "" {
+		server.serviceAuth = &serviceAuthValidator{serviceDID: serviceDID, dir: dir}
+	}
+	if node.Signer != nil {
+		server.capabilities = &capabilityIssuer{signer: node.Signer, store: perms}
 	}
 	return server
 }
 
+// serviceDIDFor returns the did:web identifying the node served at the endpoint, or "" if there is no endpoint.
+func serviceDIDFor(serviceEndpoint string) (string, error) {
+	if serviceEndpoint == "" {
+		return "", nil
+	}
+	u, err := url.Parse(serviceEndpoint)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("service endpoint %q has no host", serviceEndpoint)
+	}
+	return didweb.DID(u.Host).String(), nil
+}
+
 var formDecoder = schema.NewDecoder()
 
-// PutRecord puts a potentially encrypted record (see s.inner.putRecord)
+// PutRecord puts a potentially encrypted record (see s.inner.putRecord). Users other than the repo's owner may
+// put private records if they have been granted permission to write to the collection.
 func (s *Server) PutRecord(w http.ResponseWriter, r *http.Request) {
-	callerDID, ok := s.getAuthedUser(w, r)
+	callerDID, pdsHttpClient, ok := s.getAuthedSession(w, r)
 	if !ok {
 		return
 	}
@@ -66,12 +135,13 @@ func (s *Server) PutRecord(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if ownerDID.String() != callerDID.String() {
+	isOwner := ownerDID == callerDID
+	if !isOwner && req.Visibility == VisibilityPublic {
 		utils.LogAndHTTPError(
 			w,
-			fmt.Errorf("only owner can put record"),
-			"only owner can put record",
-			http.StatusMethodNotAllowed,
+			fmt.Errorf("only owner can put public record"),
+			"only owner can put public record",
+			http.StatusForbidden,
 		)
 		return
 	}
@@ -83,9 +153,54 @@ func (s *Server) PutRecord(w http.ResponseWriter, r *http.Request) {
 		rkey = req.Rkey
 	}
 
-	v := true
-	err = s.store.putRecord(ownerDID.String(), req.Collection, req.Record, rkey, &v)
+	// Other users' sessions aren't valid on the owner's PDS, but the public record lookup doesn't need one.
+	var client httpDoer = http.DefaultClient
+	if isOwner {
+		client = pdsHttpClient
+	}
+	pds, err := s.pdsClientFor(r.Context(), ownerDID, client)
 	if err != nil {
+		utils.LogAndHTTPError(w, err, "finding pds", http.StatusInternalServerError)
+		return
+	}
+
+	v := true
+	var uri string
+	switch req.Visibility {
+	case "", VisibilityPrivate:
+		err = s.store.putRecord(
+			r.Context(),
+			pds,
+			ownerDID.String(),
+			callerDID.String(),
+			req.Collection,
+			req.Record,
+			rkey,
+			&v,
+		)
+		uri = fmt.Sprintf("habitat://%s/%s/%s", ownerDID.String(), req.Collection, rkey)
+	case VisibilityPublic:
+		err = s.store.putPublicRecord(
+			r.Context(),
+			pds,
+			ownerDID.String(),
+			req.Collection,
+			req.Record,
+			rkey,
+			&v,
+		)
+		uri = fmt.Sprintf("at://%s/%s/%s", ownerDID.String(), req.Collection, rkey)
+	default:
+		utils.LogAndHTTPError(w, ErrInvalidVisibility, "parsing visibility", http.StatusBadRequest)
+		return
+	}
+	if errors.Is(err, ErrPublicRecordExists) {
+		utils.LogAndHTTPError(w, err, "putting record", http.StatusConflict)
+		return
+	} else if errors.Is(err, ErrUnauthorized) {
+		utils.LogAndHTTPError(w, err, "putting record", http.StatusForbidden)
+		return
+	} else if err != nil {
 		utils.LogAndHTTPError(
 			w,
 			err,
@@ -96,7 +211,7 @@ func (s *Server) PutRecord(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err = json.NewEncoder(w).Encode(&habitat.NetworkHabitatRepoPutRecordOutput{
-		Uri: fmt.Sprintf("habitat://%s/%s/%s", ownerDID.String(), req.Collection, rkey),
+		Uri: uri,
 	}); err != nil {
 		utils.LogAndHTTPError(w, err, "encoding response", http.StatusInternalServerError)
 		return
@@ -118,17 +233,65 @@ func (s *Server) fetchDID(ctx context.Context, didOrHandle string) (syntax.DID,
 }
 
 // Find desired did
-// if other did, forward request there
+// if other did, forward request there (see s.forwardIfRemote)
 // if our own did,
 // --> if authInfo matches then fulfill the request
 // --> otherwise verify requester's token via bff auth --> if they have permissions via permission store --> fulfill request
 
-// GetRecord gets a potentially encrypted record (see s.inner.getRecord)
+// remoteEndpoint returns the endpoint of the Habitat node hosting did's repo, or "" if it should be served by
+// this node.
+func (s *Server) remoteEndpoint(ctx context.Context, did syntax.DID) (string, error) {
+	if s.serviceEndpoint == "" {
+		return "", nil
+	}
+	id, err := s.dir.LookupDID(ctx, did)
+	if err != nil {
+		return "", err
+	}
+	endpoint := strings.TrimSuffix(id.GetServiceEndpoint(habitatServiceID), "/")
+	if endpoint == "" || endpoint == s.serviceEndpoint {
+		return "", nil
+	}
+	return endpoint, nil
+}
+
+// forwardIfRemote forwards the request to the node hosting targetDID's repo if it is not this node, and
+// reports whether it did so. Requests that were already forwarded are never forwarded again.
+func (s *Server) forwardIfRemote(
+	w http.ResponseWriter,
+	r *http.Request,
+	targetDID syntax.DID,
+	callerDID syntax.DID,
+) bool {
+	if r.Header.Get(forwardedHeader) != "" {
+		return false
+	}
+	endpoint, err := s.remoteEndpoint(r.Context(), targetDID)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "identity lookup", http.StatusBadRequest)
+		return true
+	} else if endpoint == "" {
+		return false
+	}
+	if err := s.forwarder.forward(w, r, endpoint, callerDID); err != nil {
+		utils.LogAndHTTPError(w, err, fmt.Sprintf("forwarding request to %s", endpoint), http.StatusBadGateway)
+	}
+	return true
+}
+
+// GetRecord gets a potentially encrypted record (see s.inner.getRecord). Instead of authenticating, callers may
+// present a capability covering the record, which only reads private records from this node's repos.
 func (s *Server) GetRecord(w http.ResponseWriter, r *http.Request) {
-	callerDID, ok := s.getAuthedUser(w, r)
+	capability, ok := s.getCapability(w, r)
 	if !ok {
 		return
 	}
+	var callerDID syntax.DID
+	if capability == nil {
+		if callerDID, ok = s.getAuthedUser(w, r); !ok {
+			return
+		}
+	}
 	var params habitat.NetworkHabitatRepoGetRecordParams
 	err := formDecoder.Decode(&params, r.URL.Query())
 	if err != nil {
@@ -142,19 +305,52 @@ func (s *Server) GetRecord(w http.ResponseWriter, r *http.Request) {
 		utils.LogAndHTTPError(w, err, "identity lookup", http.StatusBadRequest)
 		return
 	}
+	if capability == nil && s.forwardIfRemote(w, r, targetDID, callerDID) {
+		return
+	}
 
-	record, err := s.store.getRecord(params.Collection, params.Rkey, targetDID, callerDID)
+	var record *visibleRecord
+	switch {
+	case capability != nil:
+		covered := capabilityCoversRecord(capability, targetDID.String(), params.Collection, params.Rkey)
+		if !s.useCapability(w, capability, covered) {
+			return
+		}
+		var private *Record
+		private, err = s.repo.GetRecord(targetDID.String(), params.Collection, params.Rkey)
+		if err == nil {
+			record = &visibleRecord{Record: *private, Visibility: VisibilityPrivate}
+		}
+	case params.IncludePublic:
+		var pds pdsClient
+		pds, err = s.pdsClientFor(r.Context(), targetDID, http.DefaultClient)
+		if err != nil {
+			utils.LogAndHTTPError(w, err, "finding pds", http.StatusInternalServerError)
+			return
+		}
+		record, err = s.store.getVisibleRecord(
+			r.Context(),
+			pds,
+			params.Collection,
+			params.Rkey,
+			targetDID,
+			callerDID,
+		)
+	default:
+		var private *Record
+		private, err = s.store.getRecord(params.Collection, params.Rkey, targetDID, callerDID)
+		if err == nil {
+			record = &visibleRecord{Record: *private, Visibility: VisibilityPrivate}
+		}
+	}
 	if err != nil {
 		utils.LogAndHTTPError(w, err, "getting record", http.StatusInternalServerError)
 		return
 	}
 	output := &habitat.NetworkHabitatRepoGetRecordOutput{
-		Uri: fmt.Sprintf(
-			"habitat://%s/%s/%s",
-			targetDID.String(),
-			params.Collection,
-			params.Rkey,
-		),
+		Uri:        record.uri(),
+		Author:     record.Author,
+		Visibility: record.Visibility,
 	}
 	if err := json.Unmarshal([]byte(record.Rec), &output.Value); err != nil {
 		utils.LogAndHTTPError(w, err, "unmarshalling record", http.StatusInternalServerError)
@@ -166,25 +362,186 @@ func (s *Server) GetRecord(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Values of the Habitat-Auth-Method header. Requests with a bearer token but no auth method are treated as
+// carrying an atproto service-auth token.
+const (
+	// The caller's own OAuth session with this node.
+	authMethodOAuth = "oauth"
+	// A bffauth token held by another Habitat node acting for one of its users.
+	authMethodBFFAuth = "bffauth"
+	// A capability token minted by this node, which stands in for the caller on reads (see capabilityIssuer).
+	authMethodCapability = "capability"
+)
+
+// getAuthedUser returns the caller, who is either a user with an OAuth session, or another Habitat node or
+// atproto service acting for one of its users. If the request is not authenticated, an error response is
+// written.
 func (s *Server) getAuthedUser(w http.ResponseWriter, r *http.Request) (syntax.DID, bool) {
-	if r.Header.Get("Habitat-Auth-Method") == "oauth" {
-		didOrHandle, _, ok := s.oauthServer.Validate(w, r)
-		if !ok {
-			return "", false
+	switch r.Header.Get("Habitat-Auth-Method") {
+	case authMethodBFFAuth:
+		return s.getNodeCaller(w, r)
+	case "":
+		if strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+			return s.getServiceAuthCaller(w, r)
 		}
+	}
+	did, _, ok := s.getAuthedSession(w, r)
+	return did, ok
+}
 
-		did, err := s.fetchDID(r.Context(), didOrHandle)
-		if err != nil {
-			utils.LogAndHTTPError(w, err, "parsing at identifier", http.StatusBadRequest)
-			return "", false
-		}
-		return did, true
+// getAuthedSession returns the caller along with a client that is authorized to act for them on their PDS.
+// Only the user's own OAuth session is accepted, so this should be used for anything that writes.
+func (s *Server) getAuthedSession(
+	w http.ResponseWriter,
+	r *http.Request,
+) (syntax.DID, *oauthclient.DpopHttpClient, bool) {
+	if r.Header.Get("Habitat-Auth-Method") != authMethodOAuth {
+		writeAuthRequired(w, fmt.Errorf("missing or unsupported Habitat-Auth-Method"))
+		return "", nil, false
+	}
+	didOrHandle, client, ok := s.oauthServer.Validate(w, r)
+	if !ok {
+		return "", nil, false
+	}
+
+	did, err := s.fetchDID(r.Context(), didOrHandle)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing at identifier", http.StatusBadRequest)
+		return "", nil, false
+	}
+	return did, client, true
+}
+
+// getNodeCaller validates a bffauth token issued to another Habitat node. The token's audience is the
+// DID the node is acting for.
+func (s *Server) getNodeCaller(w http.ResponseWriter, r *http.Request) (syntax.DID, bool) {
+	if s.nodeAuth == nil {
+		writeAuthRequired(w, fmt.Errorf("node-to-node auth is not enabled"))
+		return "", false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		writeAuthRequired(w, fmt.Errorf("missing bearer token"))
+		return "", false
+	}
+	audience, err := s.nodeAuth.ValidateToken(token)
+	if err != nil {
+		writeAuthRequired(w, fmt.Errorf("invalid bffauth token: %w", err))
+		return "", false
+	}
+	did, err := syntax.ParseDID(audience)
+	if err != nil {
+		writeAuthRequired(w, fmt.Errorf("invalid bffauth token audience: %w", err))
+		return "", false
+	}
+	return did, true
+}
+
+// getServiceAuthCaller validates an atproto service-auth token. The token's issuer is the caller.
+func (s *Server) getServiceAuthCaller(w http.ResponseWriter, r *http.Request) (syntax.DID, bool) {
+	if s.serviceAuth == nil {
+		writeAuthRequired(w, fmt.Errorf("service auth is not enabled"))
+		return "", false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	lexMethod := strings.TrimPrefix(r.URL.Path, "/xrpc/")
+	did, err := s.serviceAuth.validate(r.Context(), token, lexMethod)
+	if err != nil {
+		writeAuthRequired(w, fmt.Errorf("invalid service auth token: %w", err))
+		return "", false
+	}
+	return did, true
+}
+
+// writeAuthRequired writes a 401 XRPC error.
+func writeAuthRequired(w http.ResponseWriter, err error) {
+	log.Error().Err(err).Msg("authenticating request")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	if err := json.NewEncoder(w).Encode(&xrpcError{Name: "AuthRequired", Message: err.Error()}); err != nil {
+		log.Error().Err(err).Msg("writing error response")
+	}
+}
+
+// pdsClientFor returns a client for the PDS hosting the given did's repo.
+func (s *Server) pdsClientFor(ctx context.Context, did syntax.DID, client httpDoer) (pdsClient, error) {
+	id, err := s.dir.LookupDID(ctx, did)
+	if err != nil {
+		return nil, err
+	}
+	host := id.PDSEndpoint()
+	if host == "" {
+		return nil, fmt.Errorf("no PDS endpoint found for %s", did)
+	}
+	return newXRPCPDSClient(client, host), nil
+}
+
+// SetRecordVisibility moves one of the caller's records between this node and their PDS.
+func (s *Server) SetRecordVisibility(w http.ResponseWriter, r *http.Request) {
+	callerDID, pdsHttpClient, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	var req habitat.NetworkHabitatRepoSetRecordVisibilityInput
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "reading request body", http.StatusBadRequest)
+		return
+	}
+
+	ownerDID, err := s.fetchDID(r.Context(), req.Repo)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing at identifier", http.StatusBadRequest)
+		return
+	}
+	if ownerDID.String() != callerDID.String() {
+		utils.LogAndHTTPError(
+			w,
+			fmt.Errorf("only owner can change record visibility"),
+			"only owner can change record visibility",
+			http.StatusForbidden,
+		)
+		return
+	}
+
+	pds, err := s.pdsClientFor(r.Context(), ownerDID, pdsHttpClient)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "finding pds", http.StatusInternalServerError)
+		return
+	}
+	err = s.store.setRecordVisibility(
+		r.Context(),
+		pds,
+		ownerDID.String(),
+		req.Collection,
+		req.Rkey,
+		req.Visibility,
+	)
+	if errors.Is(err, ErrInvalidVisibility) {
+		utils.LogAndHTTPError(w, err, "setting record visibility", http.StatusBadRequest)
+		return
+	} else if errors.Is(err, ErrRecordNotFound) {
+		utils.LogAndHTTPError(w, err, "setting record visibility", http.StatusNotFound)
+		return
+	} else if err != nil {
+		utils.LogAndHTTPError(w, err, "setting record visibility", http.StatusInternalServerError)
+		return
+	}
+
+	scheme := "habitat"
+	if req.Visibility == VisibilityPublic {
+		scheme = "at"
+	}
+	if err = json.NewEncoder(w).Encode(&habitat.NetworkHabitatRepoSetRecordVisibilityOutput{
+		Uri: fmt.Sprintf("%s://%s/%s/%s", scheme, ownerDID.String(), req.Collection, req.Rkey),
+	}); err != nil {
+		utils.LogAndHTTPError(w, err, "encoding response", http.StatusInternalServerError)
+		return
 	}
-	return "", false
 }
 
 func (s *Server) UploadBlob(w http.ResponseWriter, r *http.Request) {
-	callerDID, ok := s.getAuthedUser(w, r)
+	callerDID, _, ok := s.getAuthedSession(w, r)
 	if !ok {
 		return
 	}
@@ -205,12 +562,12 @@ func (s *Server) UploadBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	blob, err := s.repo.uploadBlob(string(callerDID), bytes, mimeType)
+	blob, err := s.repo.UploadBlob(string(callerDID), bytes, mimeType)
 	if err != nil {
 		utils.LogAndHTTPError(
 			w,
 			err,
-			"error in repo.uploadBlob",
+			"error in repo.UploadBlob",
 			http.StatusInternalServerError,
 		)
 		return
@@ -231,14 +588,19 @@ func (s *Server) UploadBlob(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetBlob returns a blob to its owner, or to callers who can read a record referencing it. Instead of
+// authenticating, callers may present a capability covering one of those records.
 func (s *Server) GetBlob(w http.ResponseWriter, r *http.Request) {
-	/*
-		// TODO: implement permissions over getBlob
-		callerDID, ok := s.getAuthedUser(w, r)
-		if !ok {
+	capability, ok := s.getCapability(w, r)
+	if !ok {
+		return
+	}
+	var callerDID syntax.DID
+	if capability == nil {
+		if callerDID, ok = s.getAuthedUser(w, r); !ok {
 			return
 		}
-	*/
+	}
 
 	var params habitat.NetworkHabitatRepoGetBlobParams
 	err := formDecoder.Decode(&params, r.URL.Query())
@@ -246,13 +608,45 @@ func (s *Server) GetBlob(w http.ResponseWriter, r *http.Request) {
 		utils.LogAndHTTPError(w, err, "parsing url", http.StatusBadRequest)
 		return
 	}
-
-	mimeType, blob, err := s.repo.getBlob(params.Did, params.Cid)
+	did, err := syntax.ParseDID(params.Did)
 	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing did", http.StatusBadRequest)
+		return
+	}
+	if capability == nil && s.forwardIfRemote(w, r, did, callerDID) {
+		return
+	}
+
+	var mimeType string
+	var blob []byte
+	if capability != nil {
+		var records []Record
+		records, err = s.repo.ListBlobRecords(did.String(), params.Cid)
+		if err != nil {
+			utils.LogAndHTTPError(w, err, "finding blob records", http.StatusInternalServerError)
+			return
+		}
+		covered := slices.ContainsFunc(records, func(record Record) bool {
+			return capabilityCoversRecord(capability, did.String(), record.Collection, record.Rkey)
+		})
+		if !s.useCapability(w, capability, covered) {
+			return
+		}
+		mimeType, blob, err = s.repo.GetBlob(did.String(), params.Cid)
+	} else {
+		mimeType, blob, err = s.store.getBlob(did, params.Cid, callerDID)
+	}
+	if errors.Is(err, ErrUnauthorized) {
+		utils.LogAndHTTPError(w, err, "getting blob", http.StatusForbidden)
+		return
+	} else if errors.Is(err, ErrRecordNotFound) {
+		utils.LogAndHTTPError(w, err, "getting blob", http.StatusNotFound)
+		return
+	} else if err != nil {
 		utils.LogAndHTTPError(
 			w,
 			err,
-			"error in repo.getBlob",
+			"error in repo.GetBlob",
 			http.StatusInternalServerError,
 		)
 		return
@@ -271,11 +665,19 @@ func (s *Server) GetBlob(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ListRecords lists records in a collection. Callers presenting a capability instead of authenticating get the
+// private records it covers, and each page counts as one use.
 func (s *Server) ListRecords(w http.ResponseWriter, r *http.Request) {
-	callerDID, ok := s.getAuthedUser(w, r)
+	capability, ok := s.getCapability(w, r)
 	if !ok {
 		return
 	}
+	var callerDID syntax.DID
+	if capability == nil {
+		if callerDID, ok = s.getAuthedUser(w, r); !ok {
+			return
+		}
+	}
 	var params habitat.NetworkHabitatRepoListRecordsParams
 	err := formDecoder.Decode(&params, r.URL.Query())
 	if err != nil {
@@ -289,27 +691,66 @@ func (s *Server) ListRecords(w http.ResponseWriter, r *http.Request) {
 		utils.LogAndHTTPError(w, err, "identity lookup", http.StatusBadRequest)
 		return
 	}
+	if capability == nil && s.forwardIfRemote(w, r, did, callerDID) {
+		return
+	}
 
 	params.Repo = did.String()
-	records, err := s.store.listRecords(&params, callerDID)
-	if err != nil {
+	var records []visibleRecord
+	switch {
+	case capability != nil:
+		policy := capabilityPolicy(capability, did.String(), params.Collection)
+		if !s.useCapability(w, capability, policy != nil) {
+			return
+		}
+		var private []Record
+		private, err = s.repo.ListRecords(&params, policy)
+		for _, record := range private {
+			records = append(records, visibleRecord{Record: record, Visibility: VisibilityPrivate})
+		}
+	case params.IncludePublic:
+		var pds pdsClient
+		pds, err = s.pdsClientFor(r.Context(), did, http.DefaultClient)
+		if err != nil {
+			utils.LogAndHTTPError(w, err, "finding pds", http.StatusInternalServerError)
+			return
+		}
+		records, err = s.store.listVisibleRecords(r.Context(), pds, &params, callerDID)
+	default:
+		var private []Record
+		private, err = s.store.listRecords(&params, callerDID)
+		for _, record := range private {
+			records = append(records, visibleRecord{Record: record, Visibility: VisibilityPrivate})
+		}
+	}
+	if errors.Is(err, ErrInvalidFilter) || errors.Is(err, ErrInvalidCursor) {
+		utils.LogAndHTTPError(w, err, "listing records", http.StatusBadRequest)
+		return
+	} else if err != nil {
 		utils.LogAndHTTPError(w, err, "listing records", http.StatusInternalServerError)
 		return
 	}
 
+	page := []Record{}
+	for _, record := range records {
+		page = append(page, record.Record)
+	}
+	cursor, err := nextCursor(&params, page)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "building cursor", http.StatusInternalServerError)
+		return
+	}
+
 	output := &habitat.NetworkHabitatRepoListRecordsOutput{
+		Cursor:  cursor,
 		Records: []habitat.NetworkHabitatRepoListRecordsRecord{},
 	}
 	for _, record := range records {
-		rkeyParts := strings.Split(record.Rkey, ".")
-		rkey := rkeyParts[len(rkeyParts)-1]
 		next := habitat.NetworkHabitatRepoListRecordsRecord{
-			Uri: fmt.Sprintf(
-				"habitat://%s/%s/%s",
-				params.Repo,
-				params.Collection,
-				rkey,
-			),
+			Uri:        record.uri(),
+			Cid:        record.Cid,
+			Author:     record.Author,
+			Visibility: record.Visibility,
 		}
 		if err := json.Unmarshal([]byte(record.Rec), &next.Value); err != nil {
 			utils.LogAndHTTPError(w, err, "unmarshalling record", http.StatusInternalServerError)
@@ -323,12 +764,19 @@ func (s *Server) ListRecords(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// ListPermissions returns the caller's lexicons mapped to the grantees with permission to perform the action in
+// the "action" query parameter, which defaults to read.
 func (s *Server) ListPermissions(w http.ResponseWriter, r *http.Request) {
-	callerDID, ok := s.getAuthedUser(w, r)
+	callerDID, _, ok := s.getAuthedSession(w, r)
 	if !ok {
 		return
 	}
-	permissions, err := s.store.permissions.ListReadPermissionsByLexicon(callerDID.String())
+	action, err := permissions.ParseAction(r.URL.Query().Get("action"))
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing action", http.StatusBadRequest)
+		return
+	}
+	permissions, err := s.store.permissions.ListPermissionsByLexicon(callerDID.String(), action)
 	if err != nil {
 		utils.LogAndHTTPError(w, err, "list permissions from store", http.StatusInternalServerError)
 		return
@@ -345,10 +793,15 @@ func (s *Server) ListPermissions(w http.ResponseWriter, r *http.Request) {
 type editPermissionRequest struct {
 	DID     string `json:"did"`
 	Lexicon string `json:"lexicon"`
+	// One of read, create or update. Defaults to read.
+	Action string `json:"action,omitempty"`
+	// Optionally limits when the permission applies. Ignored when removing permissions.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
 }
 
 func (s *Server) AddPermission(w http.ResponseWriter, r *http.Request) {
-	callerDID, ok := s.getAuthedUser(w, r)
+	callerDID, _, ok := s.getAuthedSession(w, r)
 	if !ok {
 		return
 	}
@@ -358,15 +811,30 @@ func (s *Server) AddPermission(w http.ResponseWriter, r *http.Request) {
 		utils.LogAndHTTPError(w, err, "decode json request", http.StatusBadRequest)
 		return
 	}
-	err = s.store.permissions.AddLexiconReadPermission(req.DID, callerDID.String(), req.Lexicon)
+	action, err := permissions.ParseAction(req.Action)
 	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing action", http.StatusBadRequest)
+		return
+	}
+	err = s.store.permissions.AddGrant(callerDID.String(), permissions.Grant{
+		Grantee:   req.DID,
+		Object:    req.Lexicon,
+		Effect:    permissions.EffectAllow,
+		Action:    action,
+		NotBefore: req.NotBefore,
+		ExpiresAt: req.ExpiresAt,
+	})
+	if errors.Is(err, permissions.ErrInvalidObject) || errors.Is(err, permissions.ErrInvalidWindow) {
+		utils.LogAndHTTPError(w, err, "adding permission", http.StatusBadRequest)
+		return
+	} else if err != nil {
 		utils.LogAndHTTPError(w, err, "adding permission", http.StatusInternalServerError)
 		return
 	}
 }
 
 func (s *Server) RemovePermission(w http.ResponseWriter, r *http.Request) {
-	callerDID, ok := s.getAuthedUser(w, r)
+	callerDID, _, ok := s.getAuthedSession(w, r)
 	if !ok {
 		return
 	}
@@ -376,9 +844,504 @@ func (s *Server) RemovePermission(w http.ResponseWriter, r *http.Request) {
 		utils.LogAndHTTPError(w, err, "decode json request", http.StatusBadRequest)
 		return
 	}
-	err = s.store.permissions.RemoveLexiconReadPermission(req.DID, callerDID.String(), req.Lexicon)
+	action, err := permissions.ParseAction(req.Action)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing action", http.StatusBadRequest)
+		return
+	}
+	err = s.store.permissions.RemoveLexiconPermission(req.DID, callerDID.String(), req.Lexicon, action)
 	if err != nil {
 		utils.LogAndHTTPError(w, err, "removing permission", http.StatusInternalServerError)
 		return
 	}
 }
+
+// ListGrants returns every grant the caller has made on their data.
+func (s *Server) ListGrants(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	grants, err := s.store.permissions.ListGrants(callerDID.String())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "list grants from store", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(grants)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// Page sizes for permission event listings.
+const (
+	defaultEventLimit = 50
+	maxEventLimit     = 100
+)
+
+// permissionEventsResponse is a page of permission events. The cursor is the ID of the last event, and is set
+// whenever there may be more events to fetch.
+type permissionEventsResponse struct {
+	Events []permissions.PermissionEvent `json:"events"`
+	Cursor string                        `json:"cursor,omitempty"`
+}
+
+// eventPage parses the "cursor" and "limit" query parameters of permission event listings.
+func eventPage(query url.Values) (int64, int, error) {
+	var cursor int64
+	if c := query.Get("cursor"); c != "" {
+		var err error
+		if cursor, err = strconv.ParseInt(c, 10, 64); err != nil || cursor < 0 {
+			return 0, 0, fmt.Errorf("%w: %q", ErrInvalidCursor, c)
+		}
+	}
+	limit := defaultEventLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit < 1 || limit > maxEventLimit {
+			return 0, 0, fmt.Errorf("limit must be between 1 and %d", maxEventLimit)
+		}
+	}
+	return cursor, limit, nil
+}
+
+// ListPermissionEvents pages through the history of changes to the caller's grants and groups, newest first.
+func (s *Server) ListPermissionEvents(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	before, limit, err := eventPage(r.URL.Query())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing page", http.StatusBadRequest)
+		return
+	}
+	events, err := s.store.permissions.ListPermissionEvents(callerDID.String(), before, limit)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "list permission events from store", http.StatusInternalServerError)
+		return
+	}
+
+	output := &permissionEventsResponse{Events: events}
+	if len(events) == limit {
+		output.Cursor = strconv.FormatInt(events[len(events)-1].ID, 10)
+	}
+	err = json.NewEncoder(w).Encode(output)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ExplainPermission reports how a permission check on the caller's data would be decided. The grantee DID and the
+// object are given by the "grantee" and "object" query parameters, where a record may instead be given by
+// "collection" and "rkey". The action is given by "action", which defaults to read.
+func (s *Server) ExplainPermission(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	grantee, err := syntax.ParseDID(query.Get("grantee"))
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing grantee did", http.StatusBadRequest)
+		return
+	}
+	object := query.Get("object")
+	if collection := query.Get("collection"); collection != "" {
+		if object != "" {
+			err = fmt.Errorf("%w: object and collection are mutually exclusive", permissions.ErrInvalidObject)
+		} else {
+			object, err = collectionObject(collection, query.Get("rkey"))
+		}
+		if err != nil {
+			utils.LogAndHTTPError(w, err, "parsing object", http.StatusBadRequest)
+			return
+		}
+	}
+	action, err := permissions.ParseAction(query.Get("action"))
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing action", http.StatusBadRequest)
+		return
+	}
+
+	explanation, err := s.store.permissions.Explain(grantee.String(), callerDID.String(), object, action)
+	if errors.Is(err, permissions.ErrInvalidObject) {
+		utils.LogAndHTTPError(w, err, "explaining permission", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		utils.LogAndHTTPError(w, err, "explaining permission", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(explanation)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// relationQuery parses the relation and object query parameters of CheckRelation and ExpandRelation. The object
+// must belong to the caller.
+func relationQuery(query url.Values, callerDID string) (string, permissions.ObjectRef, error) {
+	object, err := permissions.ParseObjectRef(query.Get("object"))
+	if err != nil {
+		return "", permissions.ObjectRef{}, err
+	}
+	if object.Owner() != callerDID {
+		err := fmt.Errorf("%w: %s doesn't belong to the caller", permissions.ErrInvalidTuple, object)
+		return "", permissions.ObjectRef{}, err
+	}
+	return query.Get("relation"), object, nil
+}
+
+// CheckRelation checks a relation or permission on one of the caller's objects or groups against the relation
+// tuples (see permissions.HabitatSchema). The subject, relation and object are given as query parameters, such as
+// "user:did:plc:bob", "read" and "object:did:plc:alice/com.habitat.posts".
+func (s *Server) CheckRelation(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	query := r.URL.Query()
+	subject, err := permissions.ParseSubject(query.Get("subject"))
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing subject", http.StatusBadRequest)
+		return
+	}
+	relation, object, err := relationQuery(query, callerDID.String())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing object", http.StatusBadRequest)
+		return
+	}
+
+	allowed, err := s.store.permissions.Check(subject, relation, object)
+	if errors.Is(err, permissions.ErrInvalidTuple) {
+		utils.LogAndHTTPError(w, err, "checking relation", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		utils.LogAndHTTPError(w, err, "checking relation", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(map[string]bool{"allowed": allowed})
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ExpandRelation returns the tree of subjects with a relation or permission to one of the caller's objects or
+// groups. The relation and object are given as query parameters, as for CheckRelation.
+func (s *Server) ExpandRelation(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	relation, object, err := relationQuery(r.URL.Query(), callerDID.String())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing object", http.StatusBadRequest)
+		return
+	}
+
+	tree, err := s.store.permissions.Expand(relation, object)
+	if errors.Is(err, permissions.ErrInvalidTuple) {
+		utils.LogAndHTTPError(w, err, "expanding relation", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		utils.LogAndHTTPError(w, err, "expanding relation", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(tree)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+type editGrantRequest struct {
+	// A DID, or "group:<name>" for one of the caller's groups.
+	Grantee string `json:"grantee"`
+	// An NSID prefix, an NSID or a single record ("nsid:rkey"). A record may instead be given by collection and
+	// rkey.
+	Object     string `json:"object,omitempty"`
+	Collection string `json:"collection,omitempty"`
+	Rkey       string `json:"rkey,omitempty"`
+	// allow or deny. Defaults to allow, and is ignored when removing grants.
+	Effect string `json:"effect,omitempty"`
+	// One of read, create or update. Defaults to read.
+	Action string `json:"action,omitempty"`
+	// Optionally limits when the grant applies. Ignored when removing grants.
+	NotBefore *time.Time `json:"notBefore,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// grant returns the grant described by the request.
+func (req *editGrantRequest) grant() (permissions.Grant, error) {
+	grant := permissions.Grant{
+		Grantee:   req.Grantee,
+		Object:    req.Object,
+		Effect:    permissions.Effect(req.Effect),
+		NotBefore: req.NotBefore,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if !strings.HasPrefix(req.Grantee, permissions.GroupGranteePrefix) {
+		if _, err := syntax.ParseDID(req.Grantee); err != nil {
+			return grant, fmt.Errorf("grantee must be a did or group: %w", err)
+		}
+	}
+	if req.Collection != "" {
+		if req.Object != "" {
+			return grant, fmt.Errorf("%w: object and collection are mutually exclusive", permissions.ErrInvalidObject)
+		}
+		object, err := collectionObject(req.Collection, req.Rkey)
+		if err != nil {
+			return grant, err
+		}
+		grant.Object = object
+	}
+	if grant.Effect == "" {
+		grant.Effect = permissions.EffectAllow
+	}
+	action, err := permissions.ParseAction(req.Action)
+	if err != nil {
+		return grant, err
+	}
+	grant.Action = action
+	return grant, nil
+}
+
+// collectionObject returns the permission object for a collection, or for a single record in it if rkey is set.
+func collectionObject(collection string, rkey string) (string, error) {
+	if _, err := syntax.ParseNSID(collection); err != nil {
+		return "", fmt.Errorf("%w: %w", permissions.ErrInvalidObject, err)
+	}
+	if rkey == "" {
+		return collection, nil
+	}
+	if _, err := syntax.ParseRecordKey(rkey); err != nil {
+		return "", fmt.Errorf("%w: %w", permissions.ErrInvalidObject, err)
+	}
+	return permissions.RecordObject(collection, rkey), nil
+}
+
+// AddGrant allows or denies a grantee access to an NSID prefix, an NSID or a single record in the caller's repo.
+func (s *Server) AddGrant(w http.ResponseWriter, r *http.Request) {
+	s.editGrant(w, r, "adding grant", func(owner string, grant permissions.Grant) error {
+		return s.store.permissions.AddGrant(owner, grant)
+	})
+}
+
+// RemoveGrant removes a grant, whatever its effect.
+func (s *Server) RemoveGrant(w http.ResponseWriter, r *http.Request) {
+	s.editGrant(w, r, "removing grant", func(owner string, grant permissions.Grant) error {
+		return s.store.permissions.RemoveGrant(owner, grant.Grantee, grant.Object, grant.Action)
+	})
+}
+
+func (s *Server) editGrant(
+	w http.ResponseWriter,
+	r *http.Request,
+	action string,
+	edit func(owner string, grant permissions.Grant) error,
+) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	req := &editGrantRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "decode json request", http.StatusBadRequest)
+		return
+	}
+	grant, err := req.grant()
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing grant", http.StatusBadRequest)
+		return
+	}
+	err = edit(callerDID.String(), grant)
+	switch {
+	case err == nil:
+	case errors.Is(err, permissions.ErrGroupNotFound):
+		utils.LogAndHTTPError(w, err, action, http.StatusNotFound)
+	case errors.Is(err, permissions.ErrInvalidObject),
+		errors.Is(err, permissions.ErrInvalidEffect),
+		errors.Is(err, permissions.ErrInvalidAction),
+		errors.Is(err, permissions.ErrInvalidWindow):
+		utils.LogAndHTTPError(w, err, action, http.StatusBadRequest)
+	default:
+		utils.LogAndHTTPError(w, err, action, http.StatusInternalServerError)
+	}
+}
+
+// ListGroups returns the caller's permission groups and their members.
+func (s *Server) ListGroups(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	groups, err := s.store.permissions.ListGroups(callerDID.String())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "list groups from store", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(groups)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+type editGroupRequest struct {
+	Group string `json:"group"`
+	// A DID, or "group:<name>" for another of the caller's groups. Only used when adding or removing members.
+	Member string `json:"member,omitempty"`
+}
+
+func (s *Server) CreateGroup(w http.ResponseWriter, r *http.Request) {
+	s.editGroup(w, r, "creating group", func(owner string, req *editGroupRequest) error {
+		return s.store.permissions.CreateGroup(owner, req.Group)
+	})
+}
+
+func (s *Server) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	s.editGroup(w, r, "deleting group", func(owner string, req *editGroupRequest) error {
+		return s.store.permissions.DeleteGroup(owner, req.Group)
+	})
+}
+
+func (s *Server) AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	s.editGroup(w, r, "adding group member", func(owner string, req *editGroupRequest) error {
+		return s.store.permissions.AddGroupMember(owner, req.Group, req.Member)
+	})
+}
+
+func (s *Server) RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	s.editGroup(w, r, "removing group member", func(owner string, req *editGroupRequest) error {
+		return s.store.permissions.RemoveGroupMember(owner, req.Group, req.Member)
+	})
+}
+
+// editGroup decodes an editGroupRequest and applies edit to one of the caller's groups.
+func (s *Server) editGroup(
+	w http.ResponseWriter,
+	r *http.Request,
+	action string,
+	edit func(owner string, req *editGroupRequest) error,
+) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	req := &editGroupRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "decode json request", http.StatusBadRequest)
+		return
+	}
+	if req.Member != "" && !strings.HasPrefix(req.Member, permissions.GroupGranteePrefix) {
+		if _, err := syntax.ParseDID(req.Member); err != nil {
+			utils.LogAndHTTPError(w, err, "parsing member did", http.StatusBadRequest)
+			return
+		}
+	}
+	err = edit(callerDID.String(), req)
+	switch {
+	case err == nil:
+	case errors.Is(err, permissions.ErrGroupNotFound):
+		utils.LogAndHTTPError(w, err, action, http.StatusNotFound)
+	case errors.Is(err, permissions.ErrGroupExists):
+		utils.LogAndHTTPError(w, err, action, http.StatusConflict)
+	case errors.Is(err, permissions.ErrInvalidGroupName), errors.Is(err, permissions.ErrGroupInItself):
+		utils.LogAndHTTPError(w, err, action, http.StatusBadRequest)
+	default:
+		utils.LogAndHTTPError(w, err, action, http.StatusInternalServerError)
+	}
+}
+
+type importCollectionRequest struct {
+	Repo       string `json:"repo"`
+	Collection string `json:"collection"`
+}
+
+type importCollectionResponse struct {
+	ID string `json:"id"`
+}
+
+// ImportCollection starts moving one of the caller's public collections into privi as private records, deleting
+// each public record once its private copy is written. There is no copy-only mode, since a record only ever has
+// one visibility (see ErrPublicRecordExists). Progress can be followed with GetImportStatus.
+func (s *Server) ImportCollection(w http.ResponseWriter, r *http.Request) {
+	callerDID, pdsHttpClient, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	req := &importCollectionRequest{}
+	err := json.NewDecoder(r.Body).Decode(req)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "decode json request", http.StatusBadRequest)
+		return
+	}
+
+	ownerDID, err := s.fetchDID(r.Context(), req.Repo)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing at identifier", http.StatusBadRequest)
+		return
+	}
+	if ownerDID.String() != callerDID.String() {
+		utils.LogAndHTTPError(
+			w,
+			fmt.Errorf("only owner can import records"),
+			"only owner can import records",
+			http.StatusForbidden,
+		)
+		return
+	}
+	if _, err := syntax.ParseNSID(req.Collection); err != nil {
+		utils.LogAndHTTPError(w, err, "parsing collection", http.StatusBadRequest)
+		return
+	}
+
+	pds, err := s.pdsClientFor(r.Context(), ownerDID, pdsHttpClient)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "finding pds", http.StatusInternalServerError)
+		return
+	}
+	// The import outlives this request.
+	id := s.importer.start(
+		context.WithoutCancel(r.Context()),
+		pds,
+		ownerDID.String(),
+		req.Collection,
+	)
+
+	err = json.NewEncoder(w).Encode(&importCollectionResponse{ID: id})
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "encoding response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetImportStatus reports the progress of one of the caller's imports.
+func (s *Server) GetImportStatus(w http.ResponseWriter, r *http.Request) {
+	callerDID, _, ok := s.getAuthedSession(w, r)
+	if !ok {
+		return
+	}
+	status, ok := s.importer.status(r.URL.Query().Get("id"))
+	if !ok || status.Did != callerDID.String() {
+		utils.LogAndHTTPError(w, fmt.Errorf("import not found"), "getting import status", http.StatusNotFound)
+		return
+	}
+
+	err := json.NewEncoder(w).Encode(&status)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "encoding response", http.StatusInternalServerError)
+		return
+	}
+}
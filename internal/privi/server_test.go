@@ -0,0 +1,148 @@
+package privi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/eagraf/habitat-new/internal/bffauth"
+	"github.com/eagraf/habitat-new/internal/permissions"
+	"github.com/stretchr/testify/require"
+)
+
+func requireAuthRequired(t *testing.T, w *httptest.ResponseRecorder) {
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+	var xerr xrpcError
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&xerr))
+	require.Equal(t, "AuthRequired", xerr.Name)
+	require.NotEmpty(t, xerr.Message)
+}
+
+func TestGetAuthedUserBFFAuth(t *testing.T) {
+	key := []byte("test-signing-key")
+	s := &Server{nodeAuth: bffauth.NewProvider(bffauth.NewInMemorySessionPersister(), key)}
+
+	authed := func(method string, authorization string) (syntax.DID, bool, *httptest.ResponseRecorder) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/xrpc/com.habitat.getRecord", nil)
+		if method != "" {
+			r.Header.Set("Habitat-Auth-Method", method)
+		}
+		if authorization != "" {
+			r.Header.Set("Authorization", authorization)
+		}
+		did, ok := s.getAuthedUser(w, r)
+		return did, ok, w
+	}
+
+	token, err := bffauth.GenerateJWT(key, "did:web:caller.example")
+	require.NoError(t, err)
+	did, ok, _ := authed("bffauth", "Bearer "+token)
+	require.True(t, ok)
+	require.Equal(t, syntax.DID("did:web:caller.example"), did)
+
+	otherKeyToken, err := bffauth.GenerateJWT([]byte("another-key"), "did:web:caller.example")
+	require.NoError(t, err)
+	_, ok, w := authed("bffauth", "Bearer "+otherKeyToken)
+	require.False(t, ok)
+	requireAuthRequired(t, w)
+
+	notADID, err := bffauth.GenerateJWT(key, "caller")
+	require.NoError(t, err)
+	_, ok, w = authed("bffauth", "Bearer "+notADID)
+	require.False(t, ok)
+	requireAuthRequired(t, w)
+
+	_, ok, w = authed("bffauth", "")
+	require.False(t, ok)
+	requireAuthRequired(t, w)
+
+	// Requests without an auth method used to be dropped without a response.
+	_, ok, w = authed("", "")
+	require.False(t, ok)
+	requireAuthRequired(t, w)
+}
+
+func TestGetAuthedSessionRejectsNodeTokens(t *testing.T) {
+	key := []byte("test-signing-key")
+	s := &Server{nodeAuth: bffauth.NewProvider(bffauth.NewInMemorySessionPersister(), key)}
+	token, err := bffauth.GenerateJWT(key, "did:web:caller.example")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/xrpc/com.habitat.addPermission", nil)
+	r.Header.Set("Habitat-Auth-Method", "bffauth")
+	r.Header.Set("Authorization", "Bearer "+token)
+	_, _, ok := s.getAuthedSession(w, r)
+	require.False(t, ok)
+	requireAuthRequired(t, w)
+}
+
+func TestGetAuthedUserBFFAuthDisabled(t *testing.T) {
+	s := &Server{}
+	token, err := bffauth.GenerateJWT([]byte("test-signing-key"), "did:web:caller.example")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/xrpc/com.habitat.getRecord", nil)
+	r.Header.Set("Habitat-Auth-Method", "bffauth")
+	r.Header.Set("Authorization", "Bearer "+token)
+	_, ok := s.getAuthedUser(w, r)
+	require.False(t, ok)
+	requireAuthRequired(t, w)
+}
+
+func TestEditGrantRequest(t *testing.T) {
+	grant, err := (&editGrantRequest{Grantee: "did:web:bob", Object: "com.habitat"}).grant()
+	require.NoError(t, err)
+	require.Equal(t, permissions.Grant{
+		Grantee: "did:web:bob",
+		Object:  "com.habitat",
+		Effect:  permissions.EffectAllow,
+		Action:  permissions.ActionRead,
+	}, grant)
+
+	grant, err = (&editGrantRequest{
+		Grantee:    "group:family",
+		Collection: "com.habitat.photos",
+		Rkey:       "beach.2025",
+		Effect:     "deny",
+	}).grant()
+	require.NoError(t, err)
+	require.Equal(t, "com.habitat.photos:beach.2025", grant.Object)
+	require.Equal(t, permissions.EffectDeny, grant.Effect)
+
+	for _, req := range []editGrantRequest{
+		{Grantee: "bob", Object: "com.habitat"},
+		{Grantee: "did:web:bob", Object: "com.habitat", Collection: "com.habitat.photos"},
+		{Grantee: "did:web:bob", Collection: "not an nsid"},
+		{Grantee: "did:web:bob", Object: "com.habitat", Action: "delete"},
+	} {
+		_, err := req.grant()
+		require.Error(t, err, req)
+	}
+}
+
+func TestEventPage(t *testing.T) {
+	cursor, limit, err := eventPage(url.Values{})
+	require.NoError(t, err)
+	require.Equal(t, int64(0), cursor)
+	require.Equal(t, defaultEventLimit, limit)
+
+	cursor, limit, err = eventPage(url.Values{"cursor": {"42"}, "limit": {"10"}})
+	require.NoError(t, err)
+	require.Equal(t, int64(42), cursor)
+	require.Equal(t, 10, limit)
+
+	for _, bad := range []string{"junk", "-1"} {
+		_, _, err = eventPage(url.Values{"cursor": {bad}})
+		require.ErrorIs(t, err, ErrInvalidCursor, bad)
+	}
+	for _, bad := range []string{"0", "101", "ten"} {
+		_, _, err = eventPage(url.Values{"limit": {bad}})
+		require.Error(t, err, bad)
+	}
+}
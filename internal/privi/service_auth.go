@@ -0,0 +1,235 @@
+package privi
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"fmt"
+	"math/big"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Atproto inter-service auth tokens, which PDSes and AppViews mint on a user's behalf when calling other
+// services, and which this node mints when calling other services as itself.
+// See https://atproto.com/specs/xrpc#inter-service-authentication-jwt.
+
+const serviceAuthLeeway = 5 * time.Second
+
+type serviceAuthClaims struct {
+	jwt.RegisteredClaims
+	// The XRPC method the token may be used for.
+	LexMethod string `json:"lxm,omitempty"`
+}
+
+// serviceAuthValidator checks that tokens were signed by the issuer's atproto signing key and are meant for
+// this service.
+type serviceAuthValidator struct {
+	// This service's DID. Tokens may also be addressed to its #habitat service.
+	serviceDID string
+	dir        identity.Directory
+}
+
+// validate returns the DID of the user the token was issued for. lexMethod is the NSID of the XRPC method
+// being called.
+func (v *serviceAuthValidator) validate(ctx context.Context, token string, lexMethod string) (syntax.DID, error) {
+	claims := &serviceAuthClaims{}
+	_, err := jwt.ParseWithClaims(
+		token,
+		claims,
+		v.issuerKey(ctx),
+		jwt.WithValidMethods([]string{SigningMethodES256K.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(serviceAuthLeeway),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if !slices.ContainsFunc(claims.Audience, func(aud string) bool {
+		return aud == v.serviceDID || aud == v.serviceDID+"#"+habitatServiceID
+	}) {
+		return "", fmt.Errorf("%w: token is not addressed to %s", jwt.ErrTokenInvalidAudience, v.serviceDID)
+	}
+	// Tokens without lxm are valid for any method, but minting them is discouraged.
+	if claims.LexMethod != "" && claims.LexMethod != lexMethod {
+		return "", fmt.Errorf("%w: token is for %s, not %s", jwt.ErrTokenInvalidClaims, claims.LexMethod, lexMethod)
+	}
+	return issuerDID(claims.Issuer)
+}
+
+// issuerKey returns a jwt.Keyfunc that resolves the issuer's signing key from its DID document.
+func (v *serviceAuthValidator) issuerKey(ctx context.Context) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		iss, err := token.Claims.GetIssuer()
+		if err != nil {
+			return nil, err
+		}
+		did, err := issuerDID(iss)
+		if err != nil {
+			return nil, err
+		}
+		id, err := v.dir.LookupDID(ctx, did)
+		if err != nil {
+			return nil, fmt.Errorf("%w: resolving %s: %w", jwt.ErrTokenInvalidIssuer, did, err)
+		}
+		key, err := id.PublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", jwt.ErrTokenInvalidIssuer, err)
+		}
+		verificationKey, err := jwtVerificationKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", jwt.ErrTokenInvalidIssuer, err)
+		}
+		return verificationKey, nil
+	}
+}
+
+// jwtVerificationKey returns the key that jwt's ES256K or ES256 signing method verifies signatures from key with.
+func jwtVerificationKey(key atcrypto.PublicKey) (any, error) {
+	switch key := key.(type) {
+	case *atcrypto.PublicKeyK256:
+		return key, nil
+	case *atcrypto.PublicKeyP256:
+		// jwt's own ES256 implementation verifies against crypto/ecdsa keys.
+		b := key.UncompressedBytes()
+		return &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(b[1:33]),
+			Y:     new(big.Int).SetBytes(b[33:]),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+// issuerDID parses the DID out of an iss claim, which may reference a service in the DID document, as in
+// did:web:example.com#atproto_labeler.
+func issuerDID(iss string) (syntax.DID, error) {
+	did, _, _ := strings.Cut(iss, "#")
+	parsed, err := syntax.ParseDID(did)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", jwt.ErrTokenInvalidIssuer, err)
+	}
+	return parsed, nil
+}
+
+// serviceAuthTTL is how long minted tokens are valid for. Tokens are minted per request, so this only needs to
+// cover clock skew and the request itself.
+const serviceAuthTTL = time.Minute
+
+// ServiceAuthSigner mints service-auth tokens identifying this node, for calling other services as itself.
+type ServiceAuthSigner struct {
+	// This node's DID, which must publish the key's public half in its DID document.
+	did string
+	key atcrypto.PrivateKey
+}
+
+// NewServiceAuthSigner returns a signer for the node identified by did. The key must be a K-256 or P-256 key.
+func NewServiceAuthSigner(did string, key atcrypto.PrivateKey) (*ServiceAuthSigner, error) {
+	switch key.(type) {
+	case *atcrypto.PrivateKeyK256, *atcrypto.PrivateKeyP256:
+	default:
+		return nil, fmt.Errorf("unsupported signing key type %T", key)
+	}
+	if _, err := syntax.ParseDID(did); err != nil {
+		return nil, err
+	}
+	return &ServiceAuthSigner{did: did, key: key}, nil
+}
+
+// Mint returns a token addressed to aud (a service DID, optionally with a #service fragment) that may only be
+// used to call lexMethod.
+func (s *ServiceAuthSigner) Mint(aud string, lexMethod string) (string, error) {
+	now := time.Now()
+	claims := &serviceAuthClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    s.did,
+			Audience:  jwt.ClaimStrings{aud},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(serviceAuthTTL)),
+			ID:        uuid.NewString(),
+		},
+		LexMethod: lexMethod,
+	}
+	return s.sign(claims)
+}
+
+// sign returns a JWT of the claims signed with the node's key.
+func (s *ServiceAuthSigner) sign(claims jwt.Claims) (string, error) {
+	switch key := s.key.(type) {
+	case *atcrypto.PrivateKeyK256:
+		return jwt.NewWithClaims(SigningMethodES256K, claims).SignedString(key)
+	case *atcrypto.PrivateKeyP256:
+		// jwt's ES256 implementation signs with crypto/ecdsa, which doesn't produce the low-S signatures
+		// atproto requires, so sign with atcrypto instead.
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		signingString, err := token.SigningString()
+		if err != nil {
+			return "", err
+		}
+		sig, err := key.HashAndSign([]byte(signingString))
+		if err != nil {
+			return "", err
+		}
+		return signingString + "." + token.EncodeSegment(sig), nil
+	default:
+		return "", fmt.Errorf("unsupported signing key type %T", key)
+	}
+}
+
+// verify parses a token that was signed by sign into claims, checking its signature and registered claims.
+func (s *ServiceAuthSigner) verify(token string, claims jwt.Claims) error {
+	pub, err := s.key.PublicKey()
+	if err != nil {
+		return err
+	}
+	key, err := jwtVerificationKey(pub)
+	if err != nil {
+		return err
+	}
+	_, err = jwt.ParseWithClaims(
+		token,
+		claims,
+		func(*jwt.Token) (any, error) { return key, nil },
+		jwt.WithValidMethods([]string{SigningMethodES256K.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithIssuer(s.did),
+		jwt.WithLeeway(serviceAuthLeeway),
+	)
+	return err
+}
+
+// client returns an httpDoer that authenticates each XRPC request to the service aud with a freshly minted
+// token scoped to the request's method.
+func (s *ServiceAuthSigner) client(client httpDoer, aud string) httpDoer {
+	return &serviceAuthClient{client: client, signer: s, aud: aud}
+}
+
+type serviceAuthClient struct {
+	client httpDoer
+	signer *ServiceAuthSigner
+	aud    string
+}
+
+func (c *serviceAuthClient) Do(req *http.Request) (*http.Response, error) {
+	lexMethod, ok := strings.CutPrefix(req.URL.Path, "/xrpc/")
+	if !ok {
+		return nil, fmt.Errorf("%s is not an xrpc path", req.URL.Path)
+	}
+	token, err := c.signer.Mint(c.aud, lexMethod)
+	if err != nil {
+		return nil, fmt.Errorf("minting service auth token: %w", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return c.client.Do(req)
+}
@@ -0,0 +1,252 @@
+package privi
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/bluesky-social/indigo/atproto/identity"
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+// signTestServiceAuth signs the claims the way a PDS does, without going through jwt's signing methods.
+func signTestServiceAuth(t *testing.T, alg string, priv atcrypto.PrivateKey, claims serviceAuthClaims) string {
+	encode := func(v any) string {
+		b, err := json.Marshal(v)
+		require.NoError(t, err)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signingString := encode(map[string]string{"alg": alg, "typ": "JWT"}) + "." + encode(claims)
+	sig, err := priv.HashAndSign([]byte(signingString))
+	require.NoError(t, err)
+	return signingString + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func testServiceAuthClaims(iss string, aud string, lxm string) serviceAuthClaims {
+	return serviceAuthClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    iss,
+			Audience:  jwt.ClaimStrings{aud},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+		},
+		LexMethod: lxm,
+	}
+}
+
+// insertIdentityWithKey adds an identity whose atproto signing key is priv's public key.
+func insertIdentityWithKey(t *testing.T, dir *identity.MockDirectory, did syntax.DID, priv atcrypto.PrivateKey) {
+	pub, err := priv.PublicKey()
+	require.NoError(t, err)
+	dir.Insert(identity.Identity{
+		DID: did,
+		Keys: map[string]identity.VerificationMethod{
+			"atproto": {Type: "Multikey", PublicKeyMultibase: pub.Multibase()},
+		},
+	})
+}
+
+func TestServiceAuthValidate(t *testing.T) {
+	k256, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	p256, err := atcrypto.GeneratePrivateKeyP256()
+	require.NoError(t, err)
+
+	dir := identity.NewMockDirectory()
+	insertIdentityWithKey(t, &dir, "did:web:k256.example", k256)
+	insertIdentityWithKey(t, &dir, "did:web:p256.example", p256)
+
+	v := &serviceAuthValidator{serviceDID: "did:web:node.example", dir: &dir}
+	ctx := context.Background()
+	lxm := "com.habitat.getRecord"
+
+	// ES256K, as minted by most PDSes.
+	token := signTestServiceAuth(t, "ES256K", k256, testServiceAuthClaims("did:web:k256.example", "did:web:node.example", lxm))
+	did, err := v.validate(ctx, token, lxm)
+	require.NoError(t, err)
+	require.Equal(t, syntax.DID("did:web:k256.example"), did)
+
+	// ES256, addressed to the #habitat service.
+	token = signTestServiceAuth(
+		t,
+		"ES256",
+		p256,
+		testServiceAuthClaims("did:web:p256.example", "did:web:node.example#habitat", lxm),
+	)
+	did, err = v.validate(ctx, token, lxm)
+	require.NoError(t, err)
+	require.Equal(t, syntax.DID("did:web:p256.example"), did)
+
+	// Issuers may reference a service in their DID document.
+	token = signTestServiceAuth(
+		t,
+		"ES256K",
+		k256,
+		testServiceAuthClaims("did:web:k256.example#atproto_labeler", "did:web:node.example", lxm),
+	)
+	did, err = v.validate(ctx, token, lxm)
+	require.NoError(t, err)
+	require.Equal(t, syntax.DID("did:web:k256.example"), did)
+
+	// Tokens without lxm are valid for every method.
+	token = signTestServiceAuth(t, "ES256K", k256, testServiceAuthClaims("did:web:k256.example", "did:web:node.example", ""))
+	_, err = v.validate(ctx, token, lxm)
+	require.NoError(t, err)
+}
+
+func TestServiceAuthValidateRejects(t *testing.T) {
+	k256, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	other, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+
+	dir := identity.NewMockDirectory()
+	insertIdentityWithKey(t, &dir, "did:web:k256.example", k256)
+
+	v := &serviceAuthValidator{serviceDID: "did:web:node.example", dir: &dir}
+	ctx := context.Background()
+	lxm := "com.habitat.getRecord"
+	valid := testServiceAuthClaims("did:web:k256.example", "did:web:node.example", lxm)
+
+	wrongAudience := valid
+	wrongAudience.Audience = jwt.ClaimStrings{"did:web:another-node.example"}
+	_, err = v.validate(ctx, signTestServiceAuth(t, "ES256K", k256, wrongAudience), lxm)
+	require.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+
+	_, err = v.validate(ctx, signTestServiceAuth(t, "ES256K", k256, valid), "com.habitat.listRecords")
+	require.ErrorIs(t, err, jwt.ErrTokenInvalidClaims)
+
+	expired := valid
+	expired.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+	_, err = v.validate(ctx, signTestServiceAuth(t, "ES256K", k256, expired), lxm)
+	require.ErrorIs(t, err, jwt.ErrTokenExpired)
+
+	noExpiry := valid
+	noExpiry.ExpiresAt = nil
+	_, err = v.validate(ctx, signTestServiceAuth(t, "ES256K", k256, noExpiry), lxm)
+	require.ErrorIs(t, err, jwt.ErrTokenRequiredClaimMissing)
+
+	_, err = v.validate(ctx, signTestServiceAuth(t, "ES256K", other, valid), lxm)
+	require.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+	// The key type must match the algorithm.
+	_, err = v.validate(ctx, signTestServiceAuth(t, "ES256", k256, valid), lxm)
+	require.Error(t, err)
+
+	unknownIssuer := valid
+	unknownIssuer.Issuer = "did:web:unknown.example"
+	_, err = v.validate(ctx, signTestServiceAuth(t, "ES256K", k256, unknownIssuer), lxm)
+	require.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+}
+
+func TestGetAuthedUserServiceAuth(t *testing.T) {
+	k256, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	dir := identity.NewMockDirectory()
+	insertIdentityWithKey(t, &dir, "did:web:k256.example", k256)
+
+	serviceDID, err := serviceDIDFor("https://node.example:8443")
+	require.NoError(t, err)
+	require.Equal(t, "did:web:node.example%3A8443", serviceDID)
+	s := &Server{serviceAuth: &serviceAuthValidator{serviceDID: serviceDID, dir: &dir}}
+
+	authed := func(path string) (syntax.DID, bool, *httptest.ResponseRecorder) {
+		token := signTestServiceAuth(
+			t,
+			"ES256K",
+			k256,
+			testServiceAuthClaims("did:web:k256.example", serviceDID, "com.habitat.getRecord"),
+		)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		did, ok := s.getAuthedUser(w, r)
+		return did, ok, w
+	}
+
+	did, ok, _ := authed("/xrpc/com.habitat.getRecord?repo=did:web:someone")
+	require.True(t, ok)
+	require.Equal(t, syntax.DID("did:web:k256.example"), did)
+
+	_, ok, w := authed("/xrpc/com.habitat.listRecords")
+	require.False(t, ok)
+	requireAuthRequired(t, w)
+}
+
+func TestServiceAuthSignerMint(t *testing.T) {
+	k256, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	p256, err := atcrypto.GeneratePrivateKeyP256()
+	require.NoError(t, err)
+
+	dir := identity.NewMockDirectory()
+	v := &serviceAuthValidator{serviceDID: "did:web:them.example", dir: &dir}
+	ctx := context.Background()
+
+	for did, key := range map[syntax.DID]atcrypto.PrivateKey{
+		"did:web:k256.example": k256,
+		"did:web:p256.example": p256,
+	} {
+		insertIdentityWithKey(t, &dir, did, key)
+		signer, err := NewServiceAuthSigner(did.String(), key)
+		require.NoError(t, err)
+
+		token, err := signer.Mint("did:web:them.example#habitat", "com.habitat.getRecord")
+		require.NoError(t, err)
+		got, err := v.validate(ctx, token, "com.habitat.getRecord")
+		require.NoError(t, err)
+		require.Equal(t, did, got)
+
+		// Tokens are scoped to a single method.
+		_, err = v.validate(ctx, token, "com.habitat.listRecords")
+		require.ErrorIs(t, err, jwt.ErrTokenInvalidClaims)
+	}
+
+	_, err = NewServiceAuthSigner("not a did", k256)
+	require.Error(t, err)
+}
+
+func TestServiceAuthClient(t *testing.T) {
+	key, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	dir := identity.NewMockDirectory()
+	insertIdentityWithKey(t, &dir, "did:web:me.example", key)
+	signer, err := NewServiceAuthSigner("did:web:me.example", key)
+	require.NoError(t, err)
+
+	v := &serviceAuthValidator{serviceDID: "did:web:them.example", dir: &dir}
+	them := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("Authorization")[len("Bearer "):]
+		did, err := v.validate(r.Context(), token, r.URL.Path[len("/xrpc/"):])
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(did))
+	}))
+	t.Cleanup(them.Close)
+
+	client := signer.client(http.DefaultClient, "did:web:them.example")
+	for _, method := range []string{"com.habitat.getRecord", "com.habitat.listRecords"} {
+		req, err := http.NewRequest(http.MethodGet, them.URL+"/xrpc/"+method, nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		require.NoError(t, resp.Body.Close())
+		// The caller's request is left untouched.
+		require.Empty(t, req.Header.Get("Authorization"))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, them.URL+"/not-xrpc", nil)
+	require.NoError(t, err)
+	_, err = client.Do(req)
+	require.Error(t, err)
+}
@@ -0,0 +1,185 @@
+package privi
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/bluesky-social/indigo/atproto/syntax"
+	"github.com/eagraf/habitat-new/api/habitat"
+	"github.com/eagraf/habitat-new/internal/permissions"
+	"github.com/eagraf/habitat-new/internal/utils"
+)
+
+// sharedCursor is the decoded form of a listSharedRecords cursor: the last record of the previous page.
+type sharedCursor struct {
+	Did  string `json:"d"`
+	Rkey string `json:"k"`
+}
+
+func encodeSharedCursor(record Record) (string, error) {
+	bytes, err := json.Marshal(&sharedCursor{Did: record.Did, Rkey: record.Rkey})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func decodeSharedCursor(cursor string) (*sharedCursor, error) {
+	bytes, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	var c sharedCursor
+	if err := json.Unmarshal(bytes, &c); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	if c.Did == "" {
+		return nil, fmt.Errorf("%w: missing repo", ErrInvalidCursor)
+	}
+	return &c, nil
+}
+
+// listSharedRecords lists the records in a collection that every owner has shared with callerDID, filtering each
+// owner's records as listRecords would.
+func (p *store) listSharedRecords(
+	params *habitat.NetworkHabitatRepoListSharedRecordsParams,
+	callerDID syntax.DID,
+) ([]Record, error) {
+	var after sharedCursor
+	if params.Cursor != "" {
+		c, err := decodeSharedCursor(params.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		after = *c
+	}
+
+	shares, err := p.permissions.ListSharedWith(callerDID.String())
+	if err != nil {
+		return nil, err
+	}
+	policies := map[string]*permissions.Policy{}
+	for _, share := range shares {
+		if _, ok := policies[share.Owner]; ok {
+			continue
+		}
+		policy, err := p.permissions.Policy(
+			share.Owner,
+			callerDID.String(),
+			params.Collection,
+			permissions.ActionRead,
+		)
+		if err != nil {
+			return nil, err
+		}
+		policies[share.Owner] = policy
+	}
+	return p.repo.ListSharedRecords(params.Collection, policies, after.Did, after.Rkey, int(params.Limit))
+}
+
+// ListShared returns the owners and objects that have been shared with the caller.
+func (s *Server) ListShared(w http.ResponseWriter, r *http.Request) {
+	callerDID, ok := s.getAuthedUser(w, r)
+	if !ok {
+		return
+	}
+	shares, err := s.store.permissions.ListSharedWith(callerDID.String())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "list shares from store", http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(shares)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListShareEvents notifies the caller of what has newly been shared with them: it returns the grants allowing
+// them something, directly or through a group, made after the event given by the cursor. Joining a group counts
+// as being granted what the group already has. Polling with the returned cursor, which is always set once there
+// has been an event, only returns newer ones.
+func (s *Server) ListShareEvents(w http.ResponseWriter, r *http.Request) {
+	callerDID, ok := s.getAuthedUser(w, r)
+	if !ok {
+		return
+	}
+	after, limit, err := eventPage(r.URL.Query())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing page", http.StatusBadRequest)
+		return
+	}
+	events, err := s.store.permissions.ListShareEvents(callerDID.String(), after, limit)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "list share events from store", http.StatusInternalServerError)
+		return
+	}
+
+	output := &permissionEventsResponse{Events: events}
+	if len(events) > 0 {
+		output.Cursor = strconv.FormatInt(events[len(events)-1].ID, 10)
+	} else if after != 0 {
+		output.Cursor = strconv.FormatInt(after, 10)
+	}
+	err = json.NewEncoder(w).Encode(output)
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "json marshal response", http.StatusInternalServerError)
+		return
+	}
+}
+
+// ListSharedRecords pages through the records of a collection that have been shared with the caller, across
+// every repo on this node.
+func (s *Server) ListSharedRecords(w http.ResponseWriter, r *http.Request) {
+	callerDID, ok := s.getAuthedUser(w, r)
+	if !ok {
+		return
+	}
+	var params habitat.NetworkHabitatRepoListSharedRecordsParams
+	err := formDecoder.Decode(&params, r.URL.Query())
+	if err != nil {
+		utils.LogAndHTTPError(w, err, "parsing url", http.StatusBadRequest)
+		return
+	}
+
+	records, err := s.store.listSharedRecords(&params, callerDID)
+	if errors.Is(err, ErrInvalidCursor) {
+		utils.LogAndHTTPError(w, err, "listing shared records", http.StatusBadRequest)
+		return
+	} else if err != nil {
+		utils.LogAndHTTPError(w, err, "listing shared records", http.StatusInternalServerError)
+		return
+	}
+
+	output := &habitat.NetworkHabitatRepoListSharedRecordsOutput{
+		Records: []habitat.NetworkHabitatRepoListSharedRecordsRecord{},
+	}
+	if params.Limit != 0 && len(records) == int(params.Limit) {
+		output.Cursor, err = encodeSharedCursor(records[len(records)-1])
+		if err != nil {
+			utils.LogAndHTTPError(w, err, "building cursor", http.StatusInternalServerError)
+			return
+		}
+	}
+	for _, record := range records {
+		private := visibleRecord{Record: record, Visibility: VisibilityPrivate}
+		next := habitat.NetworkHabitatRepoListSharedRecordsRecord{
+			Uri:    private.uri(),
+			Author: record.Author,
+		}
+		if err := json.Unmarshal([]byte(record.Rec), &next.Value); err != nil {
+			utils.LogAndHTTPError(w, err, "unmarshalling record", http.StatusInternalServerError)
+			return
+		}
+		output.Records = append(output.Records, next)
+	}
+	if err := json.NewEncoder(w).Encode(output); err != nil {
+		utils.LogAndHTTPError(w, err, "encoding response", http.StatusInternalServerError)
+		return
+	}
+}
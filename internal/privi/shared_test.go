@@ -0,0 +1,73 @@
+package privi
+
+import (
+	"testing"
+
+	"github.com/eagraf/habitat-new/api/habitat"
+	"github.com/eagraf/habitat-new/internal/permissions"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestListSharedRecords(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"))
+	require.NoError(t, err)
+	perms, err := permissions.NewSQLiteStore(db)
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+	p := newStore(perms, repo)
+
+	coll := "my.fake.photos"
+	for _, owner := range []string{"alice", "carol", "dave"} {
+		for _, rkey := range []string{"1", "2", "3"} {
+			require.NoError(t, repo.PutRecord(owner, owner, coll, rkey, map[string]any{}, nil))
+		}
+	}
+	require.NoError(t, repo.PutRecord("alice", "alice", "my.fake.posts", "1", map[string]any{}, nil))
+
+	// Alice shares everything but one record through a group, carol shares a single record and dave shares
+	// nothing.
+	require.NoError(t, perms.CreateGroup("alice", "friends"))
+	require.NoError(t, perms.AddGroupMember("alice", "friends", "bob"))
+	require.NoError(t, perms.AddLexiconReadPermission(permissions.GroupGrantee("friends"), "alice", coll+".*"))
+	require.NoError(t, perms.AddGrant("alice", permissions.Grant{
+		Grantee: "bob",
+		Object:  permissions.RecordObject(coll, "2"),
+		Effect:  permissions.EffectDeny,
+		Action:  permissions.ActionRead,
+	}))
+	require.NoError(t, perms.AddGrant("carol", permissions.Grant{
+		Grantee: "bob",
+		Object:  permissions.RecordObject(coll, "3"),
+		Effect:  permissions.EffectAllow,
+		Action:  permissions.ActionRead,
+	}))
+
+	var listed []string
+	params := &habitat.NetworkHabitatRepoListSharedRecordsParams{Collection: coll, Limit: 2}
+	for {
+		records, err := p.listSharedRecords(params, "bob")
+		require.NoError(t, err)
+		for _, record := range records {
+			listed = append(listed, record.Did+"/"+record.Rkey)
+		}
+		if len(records) < int(params.Limit) {
+			break
+		}
+		params.Cursor, err = encodeSharedCursor(records[len(records)-1])
+		require.NoError(t, err)
+	}
+	require.Equal(t, []string{"alice/1", "alice/3", "carol/3"}, listed)
+
+	records, err := p.listSharedRecords(&habitat.NetworkHabitatRepoListSharedRecordsParams{Collection: coll}, "erin")
+	require.NoError(t, err)
+	require.Empty(t, records)
+
+	_, err = p.listSharedRecords(
+		&habitat.NetworkHabitatRepoListSharedRecordsParams{Collection: coll, Cursor: "junk"},
+		"bob",
+	)
+	require.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -10,15 +10,45 @@ package privi
 
 import (
 	"crypto"
+	"crypto/rand"
 	"errors"
 
 	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/golang-jwt/jwt/v5"
+	"gitlab.com/yawning/secp256k1-voi/secec"
 )
 
 // SigningMethodSecp256k1 is the implementation of jwt.SigningMethod.
 type SigningMethodSecp256k1 struct {
 	alg  string
 	hash crypto.Hash
+	// Whether signatures carry the recovery byte V.
+	recoverable bool
+}
+
+// Specific instances for ES256K and ES256K-R.
+var (
+	SigningMethodES256K  *SigningMethodSecp256k1
+	SigningMethodES256KR *SigningMethodSecp256k1
+)
+
+func init() {
+	SigningMethodES256K = &SigningMethodSecp256k1{
+		alg:  "ES256K",
+		hash: crypto.SHA256,
+	}
+	jwt.RegisterSigningMethod(SigningMethodES256K.Alg(), func() jwt.SigningMethod {
+		return SigningMethodES256K
+	})
+
+	SigningMethodES256KR = &SigningMethodSecp256k1{
+		alg:         "ES256K-R",
+		hash:        crypto.SHA256,
+		recoverable: true,
+	}
+	jwt.RegisterSigningMethod(SigningMethodES256KR.Alg(), func() jwt.SigningMethod {
+		return SigningMethodES256KR
+	})
 }
 
 // Errors returned on different problems.
@@ -30,11 +60,17 @@ var (
 	ErrHashUnavailable = errors.New("hasher unavailable")
 )
 
+// Signature lengths of R || S and R || S || V.
+const (
+	secp256k1SigLen            = 64
+	secp256k1RecoverableSigLen = 65
+)
+
 // Verify verifies a secp256k1 signature in a JWT. The type of key has to be
-// *ecdsa.PublicKey.
+// *atcrypto.PublicKeyK256.
 //
-// Verify it is a secp256k1 key before passing, otherwise it will validate with
-// that type of key instead. This can be done using ethereum's crypto package.
+// For ES256K-R, the public key recovered from the signature must also match
+// the given key. High-S signatures are rejected, as atproto requires.
 func (sm *SigningMethodSecp256k1) Verify(signingString string, signature []byte, key interface{}) error {
 	pub, ok := key.(*atcrypto.PublicKeyK256)
 	if !ok {
@@ -45,14 +81,67 @@ func (sm *SigningMethodSecp256k1) Verify(signingString string, signature []byte,
 		return ErrHashUnavailable
 	}
 
-	return pub.HashAndVerify([]byte(signingString), signature)
+	if !sm.recoverable {
+		if len(signature) != secp256k1SigLen {
+			return ErrBadSignature
+		}
+		return pub.HashAndVerify([]byte(signingString), signature)
+	}
+
+	if len(signature) != secp256k1RecoverableSigLen {
+		return ErrBadSignature
+	}
+	sececPub, err := secec.NewPublicKey(pub.UncompressedBytes())
+	if err != nil {
+		return ErrWrongKeyFormat
+	}
+	hasher := sm.hash.New()
+	hasher.Write([]byte(signingString))
+	if !sececPub.Verify(hasher.Sum(nil), signature, &secec.ECDSAOptions{
+		Hash:            sm.hash,
+		Encoding:        secec.EncodingCompactRecoverable,
+		RejectMalleable: true,
+	}) {
+		return ErrVerification
+	}
+	return nil
 }
 
 // Sign produces a secp256k1 signature for a JWT. The type of key has
-// to be *PrivateKey.
+// to be *atcrypto.PrivateKeyK256.
 func (sm *SigningMethodSecp256k1) Sign(signingString string, key interface{}) ([]byte, error) {
-	// Don't need this
-	return nil, nil
+	priv, ok := key.(*atcrypto.PrivateKeyK256)
+	if !ok {
+		return nil, ErrWrongKeyFormat
+	}
+
+	if !sm.hash.Available() {
+		return nil, ErrHashUnavailable
+	}
+
+	if !sm.recoverable {
+		sig, err := priv.HashAndSign([]byte(signingString))
+		if err != nil {
+			return nil, errors.Join(ErrFailedSigning, err)
+		}
+		return sig, nil
+	}
+
+	// atcrypto doesn't produce recoverable signatures, so sign with the underlying library.
+	sececPriv, err := secec.NewPrivateKey(priv.Bytes())
+	if err != nil {
+		return nil, ErrWrongKeyFormat
+	}
+	hasher := sm.hash.New()
+	hasher.Write([]byte(signingString))
+	sig, err := sececPriv.Sign(rand.Reader, hasher.Sum(nil), &secec.ECDSAOptions{
+		Hash:     sm.hash,
+		Encoding: secec.EncodingCompactRecoverable,
+	})
+	if err != nil {
+		return nil, errors.Join(ErrFailedSigning, err)
+	}
+	return sig, nil
 }
 
 // Alg returns the algorithm name.
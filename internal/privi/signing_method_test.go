@@ -0,0 +1,125 @@
+package privi
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/bluesky-social/indigo/atproto/atcrypto"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/yawning/secp256k1-voi/secec"
+)
+
+// K-256 vectors from the atproto interop fixtures (atcrypto/testdata/signature-fixtures.json).
+func TestSigningMethodES256KVectors(t *testing.T) {
+	message, err := base64.RawStdEncoding.DecodeString("oWVoZWxsb2V3b3JsZA")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		didKey    string
+		signature string
+		valid     bool
+	}{
+		{
+			name:      "low-S signature",
+			didKey:    "did:key:zQ3shqwJEJyMBsBXCWyCBpUBMqxcon9oHB7mCvx4sSpMdLJwc",
+			signature: "5WpdIuEUUfVUYaozsi8G0B3cWO09cgZbIIwg1t2YKdUn/FEznOndsz/qgiYb89zwxYCbB71f7yQK5Lr7NasfoA",
+			valid:     true,
+		},
+		{
+			name:      "high-S signature",
+			didKey:    "did:key:zQ3shqwJEJyMBsBXCWyCBpUBMqxcon9oHB7mCvx4sSpMdLJwc",
+			signature: "5WpdIuEUUfVUYaozsi8G0B3cWO09cgZbIIwg1t2YKdXYA67MYxYiTMAVfdnkDCMN9S5B3vHosRe07aORmoshoQ",
+			valid:     false,
+		},
+		{
+			name:      "DER-encoded signature",
+			didKey:    "did:key:zQ3shnriYMXc8wvkbJqfNWh5GXn2bVAeqTC92YuNbek4npqGF",
+			signature: "MEUCIQCWumUqJqOCqInXF7AzhIRg2MhwRz2rWZcOEsOjPmNItgIgXJH7RnqfYY6M0eg33wU0sFYDlprwdOcpRn78Sz5ePgk",
+			valid:     false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pub, err := atcrypto.ParsePublicDIDKey(tc.didKey)
+			require.NoError(t, err)
+			sig, err := base64.RawStdEncoding.DecodeString(tc.signature)
+			require.NoError(t, err)
+
+			err = SigningMethodES256K.Verify(string(message), sig, pub)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestSigningMethodES256KRVector(t *testing.T) {
+	pub, err := atcrypto.ParsePublicDIDKey("did:key:zQ3shokFTS3brHcDQrn82RUDfCZESWL1ZdCEJwekUDPQiYBme")
+	require.NoError(t, err)
+	token := "eyJhbGciOiJFUzI1NkstUiIsInR5cCI6IkpXVCJ9.eyJpc3MiOiJkaWQ6ZXhhbXBsZTphbGljZSJ9." +
+		"2HRG_u4pvLvnAYocrLRYufHSUWqDmDM_1dewmVdlf-0AVINCF50SvvSaa86w0nVeCmXqbJW3lFcrRaJGWRkSBwA"
+
+	parsed, err := jwt.Parse(token, func(*jwt.Token) (any, error) { return pub, nil })
+	require.NoError(t, err)
+	require.Equal(t, SigningMethodES256KR, parsed.Method)
+	iss, err := parsed.Claims.GetIssuer()
+	require.NoError(t, err)
+	require.Equal(t, "did:example:alice", iss)
+
+	// The key can be recovered from the signature alone.
+	signingString := token[:strings.LastIndex(token, ".")]
+	sig, err := base64.RawURLEncoding.DecodeString(token[strings.LastIndex(token, ".")+1:])
+	require.NoError(t, err)
+	r, s, v, err := secec.ParseCompactRecoverableSignature(sig)
+	require.NoError(t, err)
+	digest := sha256.Sum256([]byte(signingString))
+	recovered, err := secec.RecoverPublicKey(digest[:], r, s, v)
+	require.NoError(t, err)
+	require.Equal(t, pub.UncompressedBytes(), recovered.Bytes())
+}
+
+func TestSigningMethodSecp256k1RoundTrip(t *testing.T) {
+	priv, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	pub, err := priv.PublicKey()
+	require.NoError(t, err)
+	other, err := atcrypto.GeneratePrivateKeyK256()
+	require.NoError(t, err)
+	otherPub, err := other.PublicKey()
+	require.NoError(t, err)
+
+	for _, method := range []*SigningMethodSecp256k1{SigningMethodES256K, SigningMethodES256KR} {
+		t.Run(method.Alg(), func(t *testing.T) {
+			require.Equal(t, method, jwt.GetSigningMethod(method.Alg()))
+
+			token, err := jwt.NewWithClaims(method, jwt.MapClaims{"iss": "did:example:alice"}).SignedString(priv)
+			require.NoError(t, err)
+
+			parsed, err := jwt.Parse(token, func(*jwt.Token) (any, error) { return pub, nil })
+			require.NoError(t, err)
+			require.Equal(t, method, parsed.Method)
+
+			_, err = jwt.Parse(token, func(*jwt.Token) (any, error) { return otherPub, nil })
+			require.ErrorIs(t, err, jwt.ErrTokenSignatureInvalid)
+
+			// Other key types are rejected.
+			p256, err := atcrypto.GeneratePrivateKeyP256()
+			require.NoError(t, err)
+			_, err = method.Sign("signing string", p256)
+			require.ErrorIs(t, err, ErrWrongKeyFormat)
+		})
+	}
+
+	// Signatures of one algorithm don't verify as the other.
+	sig, err := SigningMethodES256KR.Sign("signing string", priv)
+	require.NoError(t, err)
+	require.Len(t, sig, 65)
+	require.ErrorIs(t, SigningMethodES256K.Verify("signing string", sig, pub), ErrBadSignature)
+	require.NoError(t, SigningMethodES256K.Verify("signing string", sig[:64], pub))
+	require.ErrorIs(t, SigningMethodES256KR.Verify("signing string", sig[:64], pub), ErrBadSignature)
+}
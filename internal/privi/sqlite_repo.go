@@ -0,0 +1,113 @@
+package privi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/eagraf/habitat-new/util"
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// NewSQLiteRepo returns a Repo backed by SQLite, applying any pending migrations (see SQLiteMigrations) first.
+// indexedFields are record field paths (e.g. "createdAt") that are commonly used to filter or sort in
+// listRecords; each gets an indexed generated column.
+func NewSQLiteRepo(db *gorm.DB, indexedFields ...string) (Repo, error) {
+	ran, err := SQLiteMigrations.Up(db)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range ran {
+		log.Info().Msgf("applied privi migration %d: %s", m.Version, m.Name)
+	}
+
+	maxBlobSize, err := getMaxBlobSize(db)
+	if err != nil {
+		return nil, err
+	}
+
+	return newGormRepo(db, maxBlobSize, &sqliteDialect{indexedFields: map[string]string{}}, indexedFields)
+}
+
+// Helper function to query sqlite compile-time options to get the max blob size
+// Not sure if this can change across versions, if so we need to keep that stable
+func getMaxBlobSize(db *gorm.DB) (int, error) {
+	sqlDb, err := db.DB()
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := sqlDb.Query("PRAGMA compile_options;")
+	if err != nil {
+		return 0, err
+	}
+	defer util.Close(rows, func(err error) {
+		log.Err(err).Msgf("error closing db rows")
+	})
+
+	for rows.Next() {
+		var opt string
+		_ = rows.Scan(&opt)
+		if strings.HasPrefix(opt, "MAX_LENGTH=") {
+			return strconv.Atoi(strings.TrimPrefix(opt, "MAX_LENGTH="))
+		}
+	}
+	return 0, fmt.Errorf("no MAX_LENGTH parameter found")
+}
+
+// sqliteDialect evaluates record fields with json_extract over the JSON text in the rec column.
+type sqliteDialect struct {
+	// Record field paths backed by an indexed generated column, mapped to the column name.
+	indexedFields map[string]string
+}
+
+var _ dialect = (*sqliteDialect)(nil)
+
+func (d *sqliteDialect) fieldExpr(path string) string {
+	if col, ok := d.indexedFields[path]; ok {
+		return col
+	}
+	return fmt.Sprintf("json_extract(rec, '$.%s')", path)
+}
+
+// json_extract returns SQL values that compare directly against bound Go scalars.
+func (d *sqliteDialect) valueExpr(value any) string {
+	return "?"
+}
+
+func (d *sqliteDialect) containsExpr() string {
+	return "instr(rec, ?) > 0"
+}
+
+// fieldColumnName maps a field path to the name of the generated column backing it.
+func fieldColumnName(path string) string {
+	return "field_" + strings.ReplaceAll(path, ".", "__")
+}
+
+// indexField adds a virtual generated column for the given field path along with an index on it,
+// so that filtering and sorting on that field does not require a full table scan. It is a no-op if
+// the column already exists.
+func (d *sqliteDialect) indexField(db *gorm.DB, path string) error {
+	col := fieldColumnName(path)
+	if !db.Migrator().HasColumn(&Record{}, col) {
+		err := db.Exec(fmt.Sprintf(
+			"ALTER TABLE records ADD COLUMN %s GENERATED ALWAYS AS (json_extract(rec, '$.%s')) VIRTUAL",
+			col,
+			path,
+		)).Error
+		if err != nil {
+			return fmt.Errorf("adding generated column for %s: %w", path, err)
+		}
+	}
+
+	err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS idx_records_%s ON records(did, %s)", col, col)).Error
+	if err != nil {
+		return fmt.Errorf("creating index for %s: %w", path, err)
+	}
+
+	d.indexedFields[path] = col
+	return nil
+}
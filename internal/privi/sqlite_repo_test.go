@@ -0,0 +1,54 @@
+package privi
+
+import (
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func newTestSQLiteRepo(t *testing.T, indexedFields ...string) Repo {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "repo.db")), &gorm.Config{})
+	require.NoError(t, err)
+	repo, err := NewSQLiteRepo(db, indexedFields...)
+	require.NoError(t, err)
+	return repo
+}
+
+func TestSQLiteRepoConformance(t *testing.T) {
+	runRepoConformance(t, newTestSQLiteRepo)
+}
+
+func TestSQLiteRepoMigrateRecordCollections(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Exec("CREATE TABLE records (did text, rkey text, rec text, PRIMARY KEY (did, rkey))").Error)
+	require.NoError(t, db.Exec(
+		"INSERT INTO records (did, rkey, rec) VALUES (?, ?, ?), (?, ?, ?)",
+		"my-did", "network.habitat.post.key-1", `{"data":"value"}`,
+		"my-did", "network.habitat.like.key-2", `{"data":"other"}`,
+	).Error)
+
+	repo, err := NewSQLiteRepo(db)
+	require.NoError(t, err)
+
+	got, err := repo.GetRecord("my-did", "network.habitat.post", "key-1")
+	require.NoError(t, err)
+	require.Equal(t, `{"data":"value"}`, got.Rec)
+
+	got, err = repo.GetRecord("my-did", "network.habitat.like", "key-2")
+	require.NoError(t, err)
+	require.Equal(t, `{"data":"other"}`, got.Rec)
+
+	require.False(t, db.Migrator().HasTable("records_legacy"))
+
+	// Migrating an already-migrated database is a no-op.
+	_, err = NewSQLiteRepo(db)
+	require.NoError(t, err)
+	_, err = repo.GetRecord("my-did", "network.habitat.post", "key-1")
+	require.NoError(t, err)
+}